@@ -3,13 +3,24 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 
 	"github.com/gorilla/mux"
+	"humanitec.io/walhallapiadaptor/internal/logging"
+	"humanitec.io/walhallapiadaptor/internal/walhallapi"
 )
 
 type Module struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	// Builds are ordered from newest to oldest version
+	Builds []ModuleBuild `json:"builds"`
+	// Latest is the build of the latest version, or nil if no version qualifies
+	Latest *ModuleBuild `json:"latest"`
+}
+
+// ModuleV1 is a Module as returned by v1 of the API, which calls the name "id".
+type ModuleV1 struct {
 	ID     string        `json:"id"`
 	Source string        `json:"source"`
 	Builds []ModuleBuild `json:"builds"`
@@ -25,73 +36,138 @@ type ModuleBuild struct {
 //
 func (s *server) listOrgs() func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		walhall, err := s.newWalhall(r.Header.Get("authorization"))
+		walhall, err := s.walhall(r)
 		if err != nil {
-			w.WriteHeader(403)
-			fmt.Fprint(w, `"Unable to parse JWT"`)
+			unauthorized(w, err, `"Unable to parse JWT"`)
 			return
 		}
 		orgs, err := walhall.ListOrgs()
 		if err != nil {
-			log.Printf("list apps: %v\n", err)
-			w.WriteHeader(http.StatusInternalServerError)
-		}
-		orgNames := make([]string, len(orgs))
-		i := 0
-		for k := range orgs {
-			orgNames[i] = k
-			i++
+			logging.FromContext(r.Context()).Error("list orgs", "error", err)
+			upstreamFailed(w, err)
+			return
 		}
 		encoder := json.NewEncoder(w)
-		err = encoder.Encode(orgNames)
+		err = encoder.Encode(sortedNames(orgs))
 		if err != nil {
-			log.Println(err)
+			logging.FromContext(r.Context()).Error("encode response", "error", err)
 			w.WriteHeader(500)
 			return
 		}
 	}
 }
 
-// listModules returns a handler which returns a list of all the modules available to the user in an org
+// listModules returns a handler which returns a list of all the modules available to the user in an org, in the shape
+// of the given API version
 //
-func (s *server) listModules() func(w http.ResponseWriter, r *http.Request) {
+func (s *server) listModules(version apiVersion) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		params := mux.Vars(r)
-		walhall, err := s.newWalhall(r.Header.Get("authorization"))
+		walhall, err := s.walhall(r)
 		if err != nil {
-			w.WriteHeader(403)
-			fmt.Fprint(w, `"Unable to parse JWT"`)
+			unauthorized(w, err, `"Unable to parse JWT"`)
 			return
 		}
 		walhallModules, err := walhall.ListModules(params["orgId"])
 		if err != nil {
-			log.Printf("list modules: %v\n", err)
-			w.WriteHeader(http.StatusInternalServerError)
+			logging.FromContext(r.Context()).Error("list modules", "error", err)
+			upstreamFailed(w, err)
 			return
 		}
 
-		modules := make([]Module, len(walhallModules))
-		for iM, module := range walhallModules {
-			builds := make([]ModuleBuild, len(module.Versions))
-			for iV, version := range module.Versions {
-				builds[iV] = ModuleBuild{
-					Image:  fmt.Sprintf("%s/%s/%s:%s", s.registryName, params["orgId"], module.Image, version.Version),
-					Commit: "UNKNOWN",
-					Branch: "UNKNOWN",
-					Tags:   []string{version.Version},
-				}
-			}
-			modules[iM] = Module{
-				ID:     module.Name,
-				Source: "Github",
-				Builds: builds,
+		modules := s.modules(params["orgId"], walhallModules)
+		var response interface{} = modules
+		if version == v1 {
+			modulesV1 := make([]ModuleV1, len(modules))
+			for i, module := range modules {
+				modulesV1[i] = ModuleV1{ID: module.Name, Source: module.Source, Builds: module.Builds}
 			}
+			response = modulesV1
+		}
+		encoder := json.NewEncoder(w)
+		err = encoder.Encode(response)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("encode response", "error", err)
+			w.WriteHeader(500)
+			return
+		}
+	}
+}
+
+// modules converts the modules Walhall lists for an org, with a build for each version pushed to the registry. The
+// builds are ordered by version, newest first, with the server's tag policy.
+func (s *server) modules(org string, walhallModules []walhallapi.Module) []Module {
+	modules := make([]Module, len(walhallModules))
+	for iM, module := range walhallModules {
+		versions := append([]walhallapi.ModuleVersion(nil), module.Versions...)
+		s.tagPolicy.Sort(versions)
+		builds := make([]ModuleBuild, len(versions))
+		for iV, version := range versions {
+			builds[len(versions)-1-iV] = s.moduleBuild(org, module, version)
+		}
+		modules[iM] = Module{
+			Name:   module.Name,
+			Source: "Github",
+			Builds: builds,
+		}
+		if latest, ok := s.tagPolicy.Latest(module.Versions); ok {
+			build := s.moduleBuild(org, module, latest)
+			modules[iM].Latest = &build
+		}
+	}
+	return modules
+}
+
+// moduleBuild returns the build of a version of module in the registry.
+func (s *server) moduleBuild(org string, module walhallapi.Module, version walhallapi.ModuleVersion) ModuleBuild {
+	return ModuleBuild{
+		Image:  fmt.Sprintf("%s/%s/%s:%s", s.registryName, org, module.Image, version.Version),
+		Commit: "UNKNOWN",
+		Branch: "UNKNOWN",
+		Tags:   []string{version.Version},
+	}
+}
+
+// getLatestModuleBuild returns a handler which returns the build of the latest version of a module, as picked by the
+// server's tag policy
+//
+func (s *server) getLatestModuleBuild() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		walhall, err := s.walhall(r)
+		if err != nil {
+			unauthorized(w, err, `"Unable to parse JWT"`)
+			return
+		}
+		walhallModules, err := walhall.ListModules(params["orgId"])
+		if err != nil {
+			logging.FromContext(r.Context()).Error("list modules", "error", err)
+			upstreamFailed(w, err)
+			return
 		}
 
+		var module *Module
+		modules := s.modules(params["orgId"], walhallModules)
+		for i := range modules {
+			if modules[i].Name == params["moduleId"] {
+				module = &modules[i]
+				break
+			}
+		}
+		switch {
+		case module == nil:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `"Module not found"`)
+			return
+		case module.Latest == nil:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `"Module has no latest build"`)
+			return
+		}
 		encoder := json.NewEncoder(w)
-		err = encoder.Encode(modules)
+		err = encoder.Encode(module.Latest)
 		if err != nil {
-			log.Println(err)
+			logging.FromContext(r.Context()).Error("encode response", "error", err)
 			w.WriteHeader(500)
 			return
 		}
@@ -103,22 +179,21 @@ func (s *server) listModules() func(w http.ResponseWriter, r *http.Request) {
 func (s *server) refreshModules() func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		params := mux.Vars(r)
-		walhall, err := s.newWalhall(r.Header.Get("authorization"))
+		walhall, err := s.walhall(r)
 		if err != nil {
-			w.WriteHeader(403)
-			fmt.Fprint(w, `"Unable to parse JWT"`)
+			unauthorized(w, err, `"Unable to parse JWT"`)
 			return
 		}
 		status, err := walhall.RefreshModules(params["orgId"])
 		if err != nil {
-			log.Printf("list modules: %v\n", err)
-			w.WriteHeader(http.StatusInternalServerError)
+			logging.FromContext(r.Context()).Error("refresh modules", "error", err)
+			upstreamFailed(w, err)
 			return
 		}
 		encoder := json.NewEncoder(w)
 		err = encoder.Encode(status)
 		if err != nil {
-			log.Println(err)
+			logging.FromContext(r.Context()).Error("encode response", "error", err)
 			w.WriteHeader(500)
 			return
 		}
@@ -130,22 +205,21 @@ func (s *server) refreshModules() func(w http.ResponseWriter, r *http.Request) {
 func (s *server) getRefreshModulesStatus() func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		params := mux.Vars(r)
-		walhall, err := s.newWalhall(r.Header.Get("authorization"))
+		walhall, err := s.walhall(r)
 		if err != nil {
-			w.WriteHeader(403)
-			fmt.Fprint(w, `"Unable to parse JWT"`)
+			unauthorized(w, err, `"Unable to parse JWT"`)
 			return
 		}
 		status, err := walhall.GetRefreshModulesStatus(params["orgId"])
 		if err != nil {
-			log.Printf("list modules: %v\n", err)
-			w.WriteHeader(http.StatusInternalServerError)
+			logging.FromContext(r.Context()).Error("get refresh modules status", "error", err)
+			upstreamFailed(w, err)
 			return
 		}
 		encoder := json.NewEncoder(w)
 		err = encoder.Encode(status)
 		if err != nil {
-			log.Println(err)
+			logging.FromContext(r.Context()).Error("encode response", "error", err)
 			w.WriteHeader(500)
 			return
 		}
@@ -1,33 +1,66 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"os"
 	"reflect"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
+	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
 	"github.com/matryer/is"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"humanitec.io/walhallapiadaptor/internal/auth"
+	"humanitec.io/walhallapiadaptor/internal/config"
+	"humanitec.io/walhallapiadaptor/internal/ratelimit"
+	"humanitec.io/walhallapiadaptor/internal/tracing"
 	"humanitec.io/walhallapiadaptor/internal/walhallapi"
+	"humanitec.io/walhallapiadaptor/walhallpb"
 )
 
 // NOTE: *_mock.go files are generated via the following commands:
 // $ mockgen -source=../../internal/walhallapi/types.go -destination=walhallapier_mock.go -package=main WalhallAPIer
 
+// testJWT is sent with every request. It is never parsed unless a verifier is configured.
+const testJWT = "JWT eyJhbGciOiJIUzI1NiJ9.e30.c2lnbmF0dXJl"
+
 type mocks struct {
 	walhall  walhallapi.WalhallAPIer
 	registry string
+	verifier *auth.Verifier
+	policy   *auth.Policy
+	jwt      string
+	tags     walhallapi.TagPolicy
 }
 
 func ExecuteRequest(mocks mocks, method, url string, body io.Reader, t *testing.T) *httptest.ResponseRecorder {
 	server := server{
-		newWalhall: func(jwt string) (walhallapi.WalhallAPIer, error) {
+		newWalhall: func(ctx context.Context, jwt string) (walhallapi.WalhallAPIer, error) {
 			return mocks.walhall, nil
 		},
 		registryName: mocks.registry,
+		verifier:     mocks.verifier,
+		policy:       mocks.policy,
+		tagPolicy:    mocks.tags,
 	}
 	server.setupRoutes()
 
@@ -41,6 +74,11 @@ func ExecuteRequest(mocks mocks, method, url string, body io.Reader, t *testing.
 	if err != nil {
 		t.Errorf("creating request: %v", err)
 	}
+	if mocks.jwt != "" {
+		req.Header.Set("Authorization", mocks.jwt)
+	} else {
+		req.Header.Set("Authorization", testJWT)
+	}
 
 	w := httptest.NewRecorder()
 	server.router.ServeHTTP(w, req)
@@ -113,40 +151,41 @@ func TestListModules(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	expectedModules := []Module{
-		Module{
+	// Tags which are not semantic versions are ordered by ID, newest first
+	expectedModules := []ModuleV1{
+		ModuleV1{
 			ID:     "test-module-one",
 			Source: "Github",
 			Builds: []ModuleBuild{
 				ModuleBuild{
-					Image:  "registry.walhall.io/org-one/test-module-one:VERSION_ONE",
+					Image:  "registry.walhall.io/org-one/test-module-one:VERSION_TWO",
 					Commit: "UNKNOWN",
 					Branch: "UNKNOWN",
-					Tags:   []string{"VERSION_ONE"},
+					Tags:   []string{"VERSION_TWO"},
 				},
 				ModuleBuild{
-					Image:  "registry.walhall.io/org-one/test-module-one:VERSION_TWO",
+					Image:  "registry.walhall.io/org-one/test-module-one:VERSION_ONE",
 					Commit: "UNKNOWN",
 					Branch: "UNKNOWN",
-					Tags:   []string{"VERSION_TWO"},
+					Tags:   []string{"VERSION_ONE"},
 				},
 			},
 		},
-		Module{
+		ModuleV1{
 			ID:     "test-module-two",
 			Source: "Github",
 			Builds: []ModuleBuild{
 				ModuleBuild{
-					Image:  "registry.walhall.io/org-one/test-module-two:VERSION_ONE",
+					Image:  "registry.walhall.io/org-one/test-module-two:VERSION_TWO",
 					Commit: "UNKNOWN",
 					Branch: "UNKNOWN",
-					Tags:   []string{"VERSION_ONE"},
+					Tags:   []string{"VERSION_TWO"},
 				},
 				ModuleBuild{
-					Image:  "registry.walhall.io/org-one/test-module-two:VERSION_TWO",
+					Image:  "registry.walhall.io/org-one/test-module-two:VERSION_ONE",
 					Commit: "UNKNOWN",
 					Branch: "UNKNOWN",
-					Tags:   []string{"VERSION_TWO"},
+					Tags:   []string{"VERSION_ONE"},
 				},
 			},
 		},
@@ -198,7 +237,7 @@ func TestListModules(t *testing.T) {
 
 	resp := ExecuteRequest(mocks{walhall: m, registry: "registry.walhall.io"}, http.MethodGet, "/orgs/org-one/modules", nil, t)
 
-	var actual []Module
+	var actual []ModuleV1
 	json.Unmarshal(resp.Body.Bytes(), &actual)
 	is.Equal(actual, expectedModules)
 }
@@ -242,3 +281,1223 @@ func TestGetRefreshModulesStatus(t *testing.T) {
 	json.Unmarshal(resp.Body.Bytes(), &actual)
 	is.Equal(actual, "success")
 }
+
+func TestUpstreamUnavailable(t *testing.T) {
+	is := is.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := NewMockWalhallAPIer(ctrl)
+
+	m.
+		EXPECT().
+		ListModules("org-one").
+		Return(nil, fmt.Errorf("list modules: %w", &walhallapi.CircuitOpenError{Host: "api.walhall.io", RetryAfter: 1500 * time.Millisecond})).
+		Times(1)
+	m.
+		EXPECT().
+		RefreshModules("org-one").
+		Return("", fmt.Errorf("refresh modules: %w", walhallapi.ErrNotFound)).
+		Times(1)
+
+	resp := ExecuteRequest(mocks{walhall: m}, http.MethodGet, "/orgs/org-one/modules", nil, t)
+	is.Equal(resp.Code, http.StatusServiceUnavailable)
+	is.Equal(resp.Header().Get("Retry-After"), "2")
+
+	resp = ExecuteRequest(mocks{walhall: m}, http.MethodPost, "/orgs/org-one/modules/refresh", nil, t)
+	is.Equal(resp.Code, http.StatusInternalServerError)
+}
+
+func TestUpstreamCircuitOpen(t *testing.T) {
+	is := is.New(t)
+	calls := 0
+	breaker := walhallapi.NewCircuitBreakerDoer(doerFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		return &http.Response{StatusCode: http.StatusBadGateway, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+	}), walhallapi.BreakerPolicy{FailureThreshold: 1, Cooldown: 30 * time.Second})
+	server := server{
+		newWalhall: func(ctx context.Context, jwt string) (walhallapi.WalhallAPIer, error) {
+			return walhallapi.NewWithContext(ctx, "http://api.walhall.io", jwt, breaker)
+		},
+	}
+	server.setupRoutes()
+	request := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/orgs", nil)
+		req.Header.Set("Authorization", testJWT)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	// The failure opens the circuit, after which requests fail fast without calling Walhall
+	is.Equal(request().Code, http.StatusInternalServerError)
+	w := request()
+	is.Equal(w.Code, http.StatusServiceUnavailable)
+	is.Equal(w.Header().Get("Retry-After"), "30")
+	is.Equal(calls, 1)
+}
+
+func TestRejectsUnverifiedJWT(t *testing.T) {
+	is := is.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// No calls to Walhall are expected.
+	m := NewMockWalhallAPIer(ctrl)
+	verifier, err := auth.NewVerifier(auth.VerifierConfig{HMACSecrets: []string{"secret"}})
+	is.NoErr(err)
+
+	resp := ExecuteRequest(mocks{walhall: m, verifier: verifier}, http.MethodGet, "/orgs", nil, t)
+
+	is.Equal(resp.Code, http.StatusUnauthorized)
+}
+
+func TestRejectsMissingCredentials(t *testing.T) {
+	is := is.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	server := server{
+		newWalhall: func(ctx context.Context, jwt string) (walhallapi.WalhallAPIer, error) {
+			return NewMockWalhallAPIer(ctrl), nil
+		},
+	}
+	server.setupRoutes()
+
+	req := httptest.NewRequest(http.MethodGet, "/orgs", nil)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+
+	is.Equal(w.Code, http.StatusUnauthorized)
+	is.True(w.Header().Get("WWW-Authenticate") != "")
+}
+
+func signedTestJWT(t *testing.T, scope string, orgUUIDs ...string) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, walhallapi.WalhallClaims{
+		Username: "test-user",
+		Scope:    scope,
+		OrgUUIDs: orgUUIDs,
+	}).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "JWT " + token
+}
+
+func TestAuthorizeScopes(t *testing.T) {
+	is := is.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := NewMockWalhallAPIer(ctrl)
+	m.
+		EXPECT().
+		GetRefreshModulesStatus("org-one").
+		Return("success", nil).
+		Times(1)
+	policy := &auth.Policy{
+		DefaultScopes: []string{"read"},
+		Routes:        map[string][]string{"refreshModules": {"write"}},
+	}
+
+	resp := ExecuteRequest(mocks{walhall: m, policy: policy, jwt: signedTestJWT(t, "read")}, http.MethodPost, "/orgs/org-one/modules/refresh", nil, t)
+	is.Equal(resp.Code, http.StatusForbidden)
+
+	resp = ExecuteRequest(mocks{walhall: m, policy: policy, jwt: signedTestJWT(t, "read")}, http.MethodGet, "/orgs/org-one/modules/refresh", nil, t)
+	is.Equal(resp.Code, http.StatusOK)
+}
+
+func TestAuthorizeOrgMembership(t *testing.T) {
+	is := is.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := NewMockWalhallAPIer(ctrl)
+	m.
+		EXPECT().
+		ListOrgs().
+		Return(map[string]string{
+			"org-one": "ORGID01",
+			"org-two": "ORGID02",
+		}, nil).
+		Times(2)
+	m.
+		EXPECT().
+		GetRefreshModulesStatus("org-one").
+		Return("success", nil).
+		Times(1)
+	policy := &auth.Policy{OrgMembership: true}
+
+	resp := ExecuteRequest(mocks{walhall: m, policy: policy, jwt: signedTestJWT(t, "read", "ORGID01")}, http.MethodGet, "/orgs/org-one/modules/refresh", nil, t)
+	is.Equal(resp.Code, http.StatusOK)
+
+	resp = ExecuteRequest(mocks{walhall: m, policy: policy, jwt: signedTestJWT(t, "read", "ORGID01")}, http.MethodGet, "/orgs/org-two/modules/refresh", nil, t)
+	is.Equal(resp.Code, http.StatusForbidden)
+}
+
+func TestRequestIDIsEchoed(t *testing.T) {
+	is := is.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := NewMockWalhallAPIer(ctrl)
+	m.EXPECT().ListOrgs().Return(map[string]string{}, nil).Times(2)
+
+	resp := ExecuteRequest(mocks{walhall: m}, http.MethodGet, "/orgs", nil, t)
+	is.True(resp.Header().Get("X-Request-ID") != "")
+
+	server := server{
+		newWalhall: func(ctx context.Context, jwt string) (walhallapi.WalhallAPIer, error) {
+			return m, nil
+		},
+	}
+	server.setupRoutes()
+	req := httptest.NewRequest(http.MethodGet, "/orgs", nil)
+	req.Header.Set("Authorization", testJWT)
+	req.Header.Set("X-Request-ID", "client-supplied-id")
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	is.Equal(w.Header().Get("X-Request-ID"), "client-supplied-id")
+}
+
+func TestMetrics(t *testing.T) {
+	is := is.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := NewMockWalhallAPIer(ctrl)
+	m.EXPECT().ListOrgs().Return(map[string]string{}, nil).Times(1)
+	m.EXPECT().ListOrgs().Return(map[string]string{}, nil).Times(1)
+	before := httpRequests.Value("/orgs", http.MethodGet, "200")
+	beforeV1 := httpRequests.Value("/v1/orgs", http.MethodGet, "200")
+
+	// Unversioned paths keep the label they had before versioning
+	ExecuteRequest(mocks{walhall: m}, http.MethodGet, "/orgs", nil, t)
+	is.Equal(httpRequests.Value("/orgs", http.MethodGet, "200"), before+1)
+	ExecuteRequest(mocks{walhall: m}, http.MethodGet, "/v1/orgs", nil, t)
+	is.Equal(httpRequests.Value("/v1/orgs", http.MethodGet, "200"), beforeV1+1)
+
+	// The metrics endpoint does not require credentials
+	server := server{}
+	server.setupRoutes()
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	is.Equal(w.Code, http.StatusOK)
+	is.True(strings.Contains(w.Body.String(), `walhallapiadaptor_http_requests_total{route="/orgs",method="GET",status="200"}`))
+	is.True(strings.Contains(w.Body.String(), "walhallapiadaptor_http_requests_in_flight 0"))
+}
+
+func TestRateLimit(t *testing.T) {
+	is := is.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := NewMockWalhallAPIer(ctrl)
+	m.
+		EXPECT().
+		RefreshModules(gomock.Any()).
+		Return("started", nil).
+		Times(4)
+	m.
+		EXPECT().
+		GetRefreshModulesStatus("org-one").
+		Return("started", nil).
+		Times(1)
+	server := server{
+		newWalhall: func(ctx context.Context, jwt string) (walhallapi.WalhallAPIer, error) {
+			return m, nil
+		},
+		readLimiter:  ratelimit.New(ratelimit.Limit{PerMinute: 60, Burst: 5}),
+		writeLimiter: ratelimit.New(ratelimit.Limit{PerMinute: 1, Burst: 1}),
+	}
+	server.setupRoutes()
+	request := func(method, url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, nil)
+		req.Header.Set("Authorization", testJWT)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request(http.MethodPost, "/orgs/org-one/modules/refresh")
+	is.Equal(w.Code, http.StatusOK)
+	is.Equal(w.Header().Get("X-RateLimit-Limit"), "1")
+	is.Equal(w.Header().Get("X-RateLimit-Remaining"), "0")
+
+	w = request(http.MethodPost, "/orgs/org-one/modules/refresh")
+	is.Equal(w.Code, http.StatusTooManyRequests)
+	is.Equal(w.Header().Get("Retry-After"), "60")
+
+	w = request(http.MethodPost, "/orgs/org-two/modules/refresh")
+	is.Equal(w.Code, http.StatusOK) // budgets are per org
+
+	w = request(http.MethodGet, "/orgs/org-one/modules/refresh")
+	is.Equal(w.Code, http.StatusOK) // reads have their own budget
+	is.Equal(w.Header().Get("X-RateLimit-Limit"), "5")
+	is.Equal(w.Header().Get("X-RateLimit-Remaining"), "4")
+
+	w = request(http.MethodPost, "/v2/graphql")
+	is.Equal(w.Header().Get("X-RateLimit-Limit"), "5") // GraphQL queries are reads
+
+	// Behind a trusted proxy, each client forwarded by it has its own budget
+	server.trustedProxies = []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}
+	proxied := func(client string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/orgs/org-three/modules/refresh", nil)
+		req.Header.Set("Authorization", testJWT)
+		req.Header.Set("X-Forwarded-For", client)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+	is.Equal(proxied("203.0.113.1").Code, http.StatusOK)
+	is.Equal(proxied("203.0.113.1").Code, http.StatusTooManyRequests)
+	is.Equal(proxied("203.0.113.2").Code, http.StatusOK)
+}
+
+func TestRateLimitKey(t *testing.T) {
+	is := is.New(t)
+	ctx := auth.WithClaims(context.Background(), walhallapi.WalhallClaims{UserUUID: "user-one"})
+	var s server
+	is.Equal(s.rateLimitKey(ctx, "10.0.0.1", "org-one"), "10.0.0.1\xfforg-one") // unverified claims are ignored
+
+	verifier, err := auth.NewVerifier(auth.VerifierConfig{HMACSecrets: []string{"secret"}})
+	is.NoErr(err)
+	s.verifier = verifier
+	is.Equal(s.rateLimitKey(ctx, "10.0.0.1", "org-one"), "user-one\xfforg-one")
+	is.Equal(s.rateLimitKey(context.Background(), "10.0.0.1", "org-one"), "10.0.0.1\xfforg-one")
+}
+
+func TestClientAddr(t *testing.T) {
+	is := is.New(t)
+	var s server
+	forwarded := []string{"203.0.113.9, 198.51.100.7", "10.0.0.2"}
+	is.Equal(s.clientAddr("10.0.0.1:1234", nil), "10.0.0.1")
+	is.Equal(s.clientAddr("10.0.0.1:1234", forwarded), "10.0.0.1") // without trusted proxies the header is ignored
+
+	s.trustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	is.Equal(s.clientAddr("10.0.0.1:1234", nil), "10.0.0.1")
+	is.Equal(s.clientAddr("10.0.0.1:1234", forwarded), "198.51.100.7") // hops the client added are not trusted
+	is.Equal(s.clientAddr("[::ffff:10.0.0.1]:1234", forwarded), "198.51.100.7")
+	is.Equal(s.clientAddr("192.0.2.1:1234", forwarded), "192.0.2.1") // only trusted proxies may forward
+	is.Equal(s.clientAddr("10.0.0.1:1234", []string{"10.0.0.3", "unknown"}), "10.0.0.1")
+	is.Equal(s.clientAddr("10.0.0.1:1234", []string{"10.0.0.3"}), "10.0.0.3")
+}
+
+type doerFunc func(req *http.Request) (*http.Response, error)
+
+func (f doerFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestHealthAndReadiness(t *testing.T) {
+	is := is.New(t)
+	calls := 0
+	server := server{
+		walhallAPIPrefix: "http://api.walhall.io",
+		registryName:     "registry.walhall.io",
+		doer: doerFunc(func(req *http.Request) (*http.Response, error) {
+			calls++
+			return &http.Response{StatusCode: http.StatusNotFound, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+		}),
+	}
+	server.setupRoutes()
+
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	is.Equal(w.Code, http.StatusOK)
+
+	for i := 0; i < 2; i++ {
+		w = httptest.NewRecorder()
+		server.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		is.Equal(w.Code, http.StatusOK)
+	}
+	is.Equal(calls, 1) // the second probe used the cached result
+
+	var report readinessReport
+	is.NoErr(json.Unmarshal(w.Body.Bytes(), &report))
+	is.Equal(report.Status, "ok")
+	is.Equal(report.Checks["walhall_api_reachable"].Status, "ok")
+}
+
+func TestReadinessFailsWithoutConfiguration(t *testing.T) {
+	is := is.New(t)
+	server := server{}
+	server.setupRoutes()
+
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	is.Equal(w.Code, http.StatusServiceUnavailable)
+
+	var report readinessReport
+	is.NoErr(json.Unmarshal(w.Body.Bytes(), &report))
+	is.Equal(report.Checks["walhall_api_prefix"].Status, "fail")
+	is.Equal(report.Checks["registry"].Status, "fail")
+}
+
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	is := is.New(t)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server := server{}
+	server.readiness = server.newReadinessChecker()
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		fmt.Fprint(w, `"deployed"`)
+	})}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	is.NoErr(err)
+
+	signals := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- server.serve(srv, listener, signals, 0, 5*time.Second)
+	}()
+
+	responses := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String() + "/deploy")
+		if err != nil {
+			responses <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		responses <- string(body)
+	}()
+
+	<-started
+	signals <- syscall.SIGTERM
+	// The in-flight request is allowed to finish after the signal
+	time.Sleep(50 * time.Millisecond)
+	is.Equal(server.readiness.Report(context.Background()).Status, "shutting_down")
+	close(release)
+
+	is.Equal(<-responses, `"deployed"`)
+	is.NoErr(<-served)
+}
+
+func TestServeFailsReadinessBeforeClosing(t *testing.T) {
+	is := is.New(t)
+	server := server{}
+	server.readiness = server.newReadinessChecker()
+	srv := &http.Server{Handler: http.HandlerFunc(server.readyz())}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	is.NoErr(err)
+
+	signals := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() {
+		served <- server.serve(srv, listener, signals, time.Minute, 5*time.Second)
+	}()
+	signals <- syscall.SIGTERM
+	time.Sleep(50 * time.Millisecond)
+
+	// During the delay the listener still accepts requests, so the failing probe can be observed
+	resp, err := http.Get("http://" + listener.Addr().String() + "/readyz")
+	is.NoErr(err)
+	resp.Body.Close()
+	is.Equal(resp.StatusCode, http.StatusServiceUnavailable)
+
+	// A second signal ends the delay
+	signals <- syscall.SIGTERM
+	is.NoErr(<-served)
+	_, err = http.Get("http://" + listener.Addr().String() + "/readyz")
+	is.True(err != nil) // the listener is closed
+}
+
+func TestServeStopsGRPCWhenHTTPFails(t *testing.T) {
+	is := is.New(t)
+	server := server{}
+	server.grpcServer = server.newGRPCServer()
+	var err error
+	server.grpcListener, err = net.Listen("tcp", "127.0.0.1:0")
+	is.NoErr(err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	is.NoErr(err)
+	listener.Close()
+
+	err = server.serve(&http.Server{}, listener, make(chan os.Signal), 0, 5*time.Second)
+	is.True(err != nil)
+	_, err = net.Dial("tcp", server.grpcListener.Addr().String())
+	is.True(err != nil) // the gRPC listener is closed too
+}
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	is := is.New(t)
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	is.NoErr(json.Unmarshal([]byte(openAPISpec), &spec))
+
+	server := server{}
+	err := server.routes().Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil // e.g. the prefix of the api subrouter
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, method := range methods {
+			if _, ok := spec.Paths[path][strings.ToLower(method)]; !ok {
+				t.Errorf("%s %s (%s) is not described in the OpenAPI document", method, path, route.GetName())
+			}
+		}
+		return nil
+	})
+	is.NoErr(err)
+}
+
+func TestOpenAPIIsServedWithoutAuthentication(t *testing.T) {
+	is := is.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	w := ExecuteRequest(mocks{walhall: NewMockWalhallAPIer(ctrl), jwt: "JWT invalid"}, "GET", "/openapi.json", nil, t)
+	is.Equal(w.Code, http.StatusOK)
+	is.Equal(w.Header().Get("Content-Type"), "application/json")
+	var spec map[string]interface{}
+	is.NoErr(json.Unmarshal(w.Body.Bytes(), &spec))
+	is.Equal(spec["openapi"], "3.0.3")
+}
+
+func TestValidateRejectsInvalidRequests(t *testing.T) {
+	is := is.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// No calls to Walhall are expected
+	w := ExecuteRequest(mocks{walhall: NewMockWalhallAPIer(ctrl)}, "GET", "/orgs/my%20org!/modules", nil, t)
+	is.Equal(w.Code, http.StatusBadRequest)
+	var body struct {
+		Message string `json:"message"`
+		Errors  []struct {
+			In    string `json:"in"`
+			Field string `json:"field"`
+		} `json:"errors"`
+	}
+	is.NoErr(json.Unmarshal(w.Body.Bytes(), &body))
+	is.Equal(len(body.Errors), 1)
+	is.Equal(body.Errors[0].In, "path")
+	is.Equal(body.Errors[0].Field, "orgId")
+}
+
+func TestVersionedModules(t *testing.T) {
+	for name, tc := range map[string]struct {
+		url         string
+		nameField   string
+		deprecation string
+		link        string
+	}{
+		"unversioned": {"/orgs/org-one/modules", "id", "true", `</v2/orgs/org-one/modules>; rel="successor-version"`},
+		"v1":          {"/v1/orgs/org-one/modules", "id", "true", `</v2/orgs/org-one/modules>; rel="successor-version"`},
+		"v2":          {"/v2/orgs/org-one/modules", "name", "", ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			is := is.New(t)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			m := NewMockWalhallAPIer(ctrl)
+			m.EXPECT().ListModules("org-one").Return([]walhallapi.Module{{Name: "test-module-one", Image: "test-module-one"}}, nil).Times(1)
+			resp := ExecuteRequest(mocks{walhall: m, registry: "registry.walhall.io"}, http.MethodGet, tc.url, nil, t)
+			is.Equal(resp.Code, http.StatusOK)
+			is.Equal(resp.Header().Get("Deprecation"), tc.deprecation)
+			is.Equal(resp.Header().Get("Link"), tc.link)
+
+			var modules []map[string]interface{}
+			is.NoErr(json.Unmarshal(resp.Body.Bytes(), &modules))
+			is.Equal(len(modules), 1)
+			is.Equal(modules[0][tc.nameField], "test-module-one")
+		})
+	}
+}
+
+func TestLatestModuleBuild(t *testing.T) {
+	walhallModules := []walhallapi.Module{
+		{Name: "auth", Image: "auth", Versions: []walhallapi.ModuleVersion{
+			{ID: 3, Version: "NEW_TAG"}, {ID: 2, Version: "1.1.0"}, {ID: 1, Version: "1.0"}, {ID: 4, Version: "2.0.0-rc.1"},
+		}},
+		{Name: "web", Image: "web", Versions: []walhallapi.ModuleVersion{{ID: 5, Version: "NEW_TAG"}}},
+	}
+	for name, tc := range map[string]struct {
+		tags   walhallapi.TagPolicy
+		builds []string
+		latest string
+		web    string
+	}{
+		"oldest":  {walhallapi.TagsOldest, []string{"2.0.0-rc.1", "1.1.0", "1.0", "NEW_TAG"}, "1.1.0", "NEW_TAG"},
+		"newest":  {walhallapi.TagsNewest, []string{"NEW_TAG", "2.0.0-rc.1", "1.1.0", "1.0"}, "NEW_TAG", "NEW_TAG"},
+		"exclude": {walhallapi.TagsExcluded, []string{"2.0.0-rc.1", "1.1.0", "1.0", "NEW_TAG"}, "1.1.0", ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			is := is.New(t)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			m := NewMockWalhallAPIer(ctrl)
+			m.EXPECT().ListModules("org-one").Return(walhallModules, nil).AnyTimes()
+			mocks := mocks{walhall: m, registry: "registry.walhall.io", tags: tc.tags}
+
+			resp := ExecuteRequest(mocks, http.MethodGet, "/v2/orgs/org-one/modules", nil, t)
+			is.Equal(resp.Code, http.StatusOK)
+			var modules []Module
+			is.NoErr(json.Unmarshal(resp.Body.Bytes(), &modules))
+			var tags []string
+			for _, build := range modules[0].Builds {
+				tags = append(tags, build.Tags[0])
+			}
+			is.Equal(tags, tc.builds) // builds are ordered newest first
+			is.Equal(modules[0].Latest.Tags, []string{tc.latest})
+			is.Equal(modules[0].Latest.Image, "registry.walhall.io/org-one/auth:"+tc.latest)
+
+			resp = ExecuteRequest(mocks, http.MethodGet, "/v2/orgs/org-one/modules/auth/builds/latest", nil, t)
+			is.Equal(resp.Code, http.StatusOK)
+			var build ModuleBuild
+			is.NoErr(json.Unmarshal(resp.Body.Bytes(), &build))
+			is.Equal(build, *modules[0].Latest)
+
+			resp = ExecuteRequest(mocks, http.MethodGet, "/v2/orgs/org-one/modules/web/builds/latest", nil, t)
+			if tc.web == "" {
+				is.Equal(resp.Code, http.StatusNotFound) // only other tags, which are excluded
+				is.Equal(modules[1].Latest, nil)
+			} else {
+				is.Equal(resp.Code, http.StatusOK)
+				is.Equal(modules[1].Latest.Tags, []string{tc.web})
+			}
+
+			resp = ExecuteRequest(mocks, http.MethodGet, "/v2/orgs/org-one/modules/missing/builds/latest", nil, t)
+			is.Equal(resp.Code, http.StatusNotFound)
+		})
+	}
+
+	// Existing clients get the same order and the latest build without a version prefix
+	is := is.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := NewMockWalhallAPIer(ctrl)
+	m.EXPECT().ListModules("org-one").Return(walhallModules, nil).Times(2)
+	mocks := mocks{walhall: m, registry: "registry.walhall.io", tags: walhallapi.TagsOldest}
+
+	resp := ExecuteRequest(mocks, http.MethodGet, "/orgs/org-one/modules", nil, t)
+	is.Equal(resp.Code, http.StatusOK)
+	var modules []ModuleV1
+	is.NoErr(json.Unmarshal(resp.Body.Bytes(), &modules))
+	var tags []string
+	for _, build := range modules[0].Builds {
+		tags = append(tags, build.Tags[0])
+	}
+	is.Equal(tags, []string{"2.0.0-rc.1", "1.1.0", "1.0", "NEW_TAG"})
+
+	resp = ExecuteRequest(mocks, http.MethodGet, "/orgs/org-one/modules/auth/builds/latest", nil, t)
+	is.Equal(resp.Code, http.StatusOK)
+	var build ModuleBuild
+	is.NoErr(json.Unmarshal(resp.Body.Bytes(), &build))
+	is.Equal(build.Tags, []string{"1.1.0"})
+	is.Equal(resp.Header().Get("Deprecation"), "true")
+}
+
+func TestCORS(t *testing.T) {
+	is := is.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := NewMockWalhallAPIer(ctrl)
+	m.EXPECT().ListOrgs().Return(map[string]string{"org-one": "ORGID01"}, nil).Times(1)
+	cfg := config.Default().CORS
+	cfg.AllowedOrigins = []string{"https://console.walhall.io"}
+	cfg.AllowCredentials = true
+	server := server{
+		newWalhall: func(ctx context.Context, jwt string) (walhallapi.WalhallAPIer, error) { return m, nil },
+		cors:       newCORS(cfg),
+	}
+	server.setupRoutes()
+	request := func(method, origin string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/v2/orgs", nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		req.Header.Set("Origin", origin)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Preflight requests are answered without credentials
+	w := request(http.MethodOptions, "https://console.walhall.io", http.Header{
+		"Access-Control-Request-Method":  []string{"DELETE"},
+		"Access-Control-Request-Headers": []string{"authorization, x-api-key"},
+	})
+	is.Equal(w.Code, http.StatusNoContent)
+	is.Equal(w.Header().Get("Access-Control-Allow-Origin"), "https://console.walhall.io")
+	is.Equal(w.Header().Get("Access-Control-Allow-Methods"), "DELETE")
+	is.Equal(w.Header().Get("Access-Control-Allow-Headers"), "Authorization,X-Api-Key")
+	is.Equal(w.Header().Get("Access-Control-Allow-Credentials"), "true")
+	is.Equal(w.Header().Get("Access-Control-Max-Age"), "600")
+
+	w = request(http.MethodOptions, "https://console.walhall.io", http.Header{
+		"Access-Control-Request-Method": []string{"CONNECT"},
+	})
+	is.Equal(w.Code, http.StatusMethodNotAllowed)
+
+	// Actual requests get the CORS headers along with the response
+	w = request(http.MethodGet, "https://console.walhall.io", http.Header{"Authorization": []string{testJWT}})
+	is.Equal(w.Code, http.StatusOK)
+	is.Equal(w.Header().Get("Access-Control-Allow-Origin"), "https://console.walhall.io")
+	is.True(strings.Contains(w.Header().Get("Access-Control-Expose-Headers"), "X-Request-Id"))
+	is.Equal(w.Header().Get("Vary"), "Origin")
+
+	// Other origins get no CORS headers
+	w = request(http.MethodOptions, "https://evil.example.com", http.Header{
+		"Access-Control-Request-Method": []string{"GET"},
+	})
+	is.Equal(w.Header().Get("Access-Control-Allow-Origin"), "")
+}
+
+func TestCookieCrossSiteRequest(t *testing.T) {
+	is := is.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := NewMockWalhallAPIer(ctrl)
+	m.EXPECT().RefreshModules(gomock.Any()).Return("started", nil).Times(2)
+	server := server{
+		newWalhall: func(ctx context.Context, jwt string) (walhallapi.WalhallAPIer, error) {
+			return m, nil
+		},
+		credentials: auth.Credentials{CookieName: "walhall_jwt", AllowedOrigins: []string{"https://console.walhall.io"}},
+	}
+	server.setupRoutes()
+	request := func(header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/orgs/org-one/modules/refresh", nil)
+		req.AddCookie(&http.Cookie{Name: "walhall_jwt", Value: testJWT})
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	// A form on another site cannot make the browser change anything with the user's cookie
+	is.Equal(request(http.Header{"Origin": {"https://evil.example.com"}}).Code, http.StatusForbidden)
+	is.Equal(request(nil).Code, http.StatusForbidden)
+
+	is.Equal(request(http.Header{"Origin": {"https://console.walhall.io"}}).Code, http.StatusOK)
+	is.Equal(request(http.Header{"X-Requested-With": {"XMLHttpRequest"}}).Code, http.StatusOK)
+}
+
+func executeGraphQL(m walhallapi.WalhallAPIer, query string) *httptest.ResponseRecorder {
+	return serveGraphQL(&server{
+		newWalhall: func(ctx context.Context, jwt string) (walhallapi.WalhallAPIer, error) {
+			return m, nil
+		},
+	}, query)
+}
+
+func serveGraphQL(server *server, query string) *httptest.ResponseRecorder {
+	server.setupRoutes()
+	body, _ := json.Marshal(map[string]string{"query": query})
+	req := httptest.NewRequest(http.MethodPost, "/v2/graphql", strings.NewReader(string(body)))
+	req.Header.Set("Authorization", testJWT)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestGraphQL(t *testing.T) {
+	is := is.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var envs []walhallapi.Environment
+	is.NoErr(json.Unmarshal([]byte(`[
+		{"env_uuid": "ENV01", "name": "development", "logic_module_versions": [
+			{"id": 1, "version_uuid": "MV01", "version": "1.0.0", "logic_module": {"name": "module-one"}},
+			{"id": 2, "version_uuid": "MV02", "version": "2.0.0", "logic_module": {"name": "module-two"}}
+		]},
+		{"env_uuid": "ENV02", "name": "production", "logic_module_versions": []}
+	]`), &envs))
+
+	// Each distinct call is made once, however often the query asks for it
+	m := NewMockWalhallAPIer(ctrl)
+	m.EXPECT().ListOrgs().Return(map[string]string{"org-one": "ORGID01", "org-two": "ORGID02"}, nil).Times(1)
+	m.EXPECT().ListApps("org-one").Return(map[string]string{"app-b": "APP02", "app-a": "APP01"}, nil).Times(1)
+	m.EXPECT().ListApps("org-two").Return(map[string]string{}, nil).Times(1)
+	m.EXPECT().ListEnvs("org-one", "app-a").Return(envs, nil).Times(1)
+	m.EXPECT().ListEnvs("org-one", "app-b").Return(nil, nil).Times(1)
+	for _, id := range []int{1, 2} {
+		m.EXPECT().
+			GetConfigsForModuleVersionInEnv(walhallapi.Environment{UUID: "ENV01"}, walhallapi.ModuleVersion{ID: id}).
+			Return([]walhallapi.Config{{ID: 10 + id, Name: fmt.Sprintf("config-%d", id),
+				Spec: map[string]interface{}{"replicas": 2}}}, nil).
+			Times(1)
+	}
+
+	w := executeGraphQL(m, `{
+		orgs { name apps { name envs { name moduleVersions { version module { name } configs { name specification } } } } }
+		one: org(name: "org-one") { uuid apps { name } app(name: "app-a") { env(name: "development") { uuid } } }
+		missing: org(name: "org-three") { name }
+	}`)
+	is.Equal(w.Code, http.StatusOK)
+
+	var result struct {
+		Data struct {
+			Orgs []struct {
+				Name string
+				Apps []struct {
+					Name string
+					Envs []struct {
+						Name           string
+						ModuleVersions []struct {
+							Version string
+							Module  struct{ Name string }
+							Configs []struct {
+								Name          string
+								Specification map[string]interface{}
+							}
+						}
+					}
+				}
+			}
+			One struct {
+				UUID string
+				App  struct{ Env struct{ UUID string } }
+			}
+			Missing *struct{}
+		}
+		Errors []interface{}
+	}
+	is.NoErr(json.Unmarshal(w.Body.Bytes(), &result))
+	is.Equal(len(result.Errors), 0)
+	is.Equal(len(result.Data.Orgs), 2)
+	is.Equal(result.Data.Orgs[0].Name, "org-one")
+	is.Equal(len(result.Data.Orgs[0].Apps), 2)
+	is.Equal(result.Data.Orgs[0].Apps[0].Name, "app-a")
+	dev := result.Data.Orgs[0].Apps[0].Envs[0]
+	is.Equal(dev.Name, "development")
+	is.Equal(len(dev.ModuleVersions), 2)
+	is.Equal(dev.ModuleVersions[1].Module.Name, "module-two")
+	is.Equal(dev.ModuleVersions[1].Configs[0].Name, "config-2")
+	is.Equal(dev.ModuleVersions[1].Configs[0].Specification["replicas"], 2.0)
+	is.Equal(result.Data.One.UUID, "ORGID01")
+	is.Equal(result.Data.One.App.Env.UUID, "ENV01")
+	is.True(result.Data.Missing == nil)
+}
+
+func TestGraphQLAuthorization(t *testing.T) {
+	is := is.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// No modules are listed: the token lacks the scope listModules requires
+	m := NewMockWalhallAPIer(ctrl)
+	m.EXPECT().ListOrgs().Return(map[string]string{"org-one": "ORGID01", "org-two": "ORGID02"}, nil).Times(1)
+	m.EXPECT().ListApps("org-one").Return(map[string]string{"app": "APPID01"}, nil).Times(1)
+	server := server{
+		newWalhall: func(ctx context.Context, jwt string) (walhallapi.WalhallAPIer, error) {
+			return m, nil
+		},
+		policy: &auth.Policy{
+			DefaultScopes: []string{"read"},
+			Routes:        map[string][]string{"listModules": {"modules"}},
+			OrgMembership: true,
+		},
+	}
+	server.setupRoutes()
+
+	query := `{ orgs { name } other: org(name: "org-two") { name } mine: org(name: "org-one") { apps { name } modules { name } } }`
+	body, _ := json.Marshal(map[string]string{"query": query})
+	req := httptest.NewRequest(http.MethodPost, "/v2/graphql", strings.NewReader(string(body)))
+	req.Header.Set("Authorization", signedTestJWT(t, "read", "ORGID01"))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	is.Equal(w.Code, http.StatusOK)
+
+	var result struct {
+		Data struct {
+			Orgs  []struct{ Name string }
+			Other *struct{ Name string }
+			Mine  struct {
+				Apps    []struct{ Name string }
+				Modules []struct{ Name string }
+			}
+		}
+		Errors []struct {
+			Message string
+			Path    []interface{}
+		}
+	}
+	is.NoErr(json.Unmarshal(w.Body.Bytes(), &result))
+	is.Equal(len(result.Data.Orgs), 1) // only the orgs of the token
+	is.Equal(result.Data.Orgs[0].Name, "org-one")
+	is.Equal(result.Data.Other, nil) // like GET /v2/orgs/org-two/modules, which is forbidden
+	is.Equal(len(result.Data.Mine.Apps), 1)
+	is.Equal(result.Data.Mine.Modules, nil)
+	is.Equal(len(result.Errors), 1)
+	is.Equal(result.Errors[0].Message, "insufficient scope for modules, requires modules")
+	is.Equal(result.Errors[0].Path, []interface{}{"mine", "modules"})
+}
+
+func TestGraphQLErrors(t *testing.T) {
+	is := is.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := NewMockWalhallAPIer(ctrl)
+	m.EXPECT().ListOrgs().Return(map[string]string{"org-one": "ORGID01"}, nil).Times(1)
+	m.EXPECT().
+		ListModules("org-one").
+		Return(nil, fmt.Errorf("list modules: %w", &walhallapi.CircuitOpenError{Host: "api.walhall.io", RetryAfter: time.Second})).
+		Times(1)
+
+	// Fields which fail are reported without upstream details, alongside the rest of the data
+	w := executeGraphQL(m, `{ orgs { name } org(name: "org-one") { modules { name } } }`)
+	is.Equal(w.Code, http.StatusOK)
+	var result struct {
+		Data struct {
+			Orgs []struct{ Name string }
+		}
+		Errors []struct{ Message string }
+	}
+	is.NoErr(json.Unmarshal(w.Body.Bytes(), &result))
+	is.Equal(len(result.Data.Orgs), 1)
+	is.Equal(len(result.Errors), 1)
+	is.Equal(result.Errors[0].Message, "Walhall is unavailable")
+
+	// Queries which cannot be executed are rejected
+	w = executeGraphQL(m, `{ orgs { unknownField } }`)
+	is.Equal(w.Code, http.StatusBadRequest)
+	w = executeGraphQL(m, `mutation { deleteOrg }`)
+	is.Equal(w.Code, http.StatusBadRequest)
+}
+
+func TestGraphQLLimits(t *testing.T) {
+	is := is.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := NewMockWalhallAPIer(ctrl)
+	m.EXPECT().ListOrgs().Return(map[string]string{"org-one": "ORGID01", "org-two": "ORGID02"}, nil).Times(1)
+	m.EXPECT().ListOrgs().Return(map[string]string{"org-one": "ORGID01", "org-two": "ORGID02", "org-three": "ORGID03"}, nil).Times(1)
+	m.EXPECT().ListApps("org-one").Return(map[string]string{"app": "APPID01"}, nil).Times(1)
+	m.EXPECT().ListApps("org-two").Return(map[string]string{}, nil).Times(1)
+	server := server{
+		newWalhall: func(ctx context.Context, jwt string) (walhallapi.WalhallAPIer, error) {
+			return m, nil
+		},
+		graphQLMaxCalls: 3,
+	}
+	var result struct {
+		Data   interface{}
+		Errors []struct{ Message string }
+	}
+
+	// A query within the budget is answered
+	w := serveGraphQL(&server, `{ orgs { apps { name } } }`)
+	is.Equal(w.Code, http.StatusOK)
+
+	// The same query needs one call too many once there is a third org, so it is rejected without listing any apps
+	w = serveGraphQL(&server, `{ orgs { apps { name } } }`)
+	is.Equal(w.Code, http.StatusBadRequest)
+	is.NoErr(json.Unmarshal(w.Body.Bytes(), &result))
+	is.Equal(result.Data, nil)
+	is.Equal(len(result.Errors), 1)
+	is.Equal(result.Errors[0].Message, "query needs more than 3 calls to Walhall")
+
+	// Queries nested too deeply are rejected before any call is made, also through fragments
+	w = executeGraphQL(m, `{ __schema { types { fields { type { ofType { ofType { ofType { ofType { ofType { ofType {
+		ofType { ofType { ofType { ofType { ofType { name } } } } } } } } } } } } } } } }`)
+	is.Equal(w.Code, http.StatusBadRequest)
+	is.NoErr(json.Unmarshal(w.Body.Bytes(), &result))
+	is.Equal(result.Errors[0].Message, "query is nested 16 levels deep, at most 15 are allowed")
+	server.graphQLMaxDepth = 3
+	w = serveGraphQL(&server, `{ orgs { ...orgApps } } fragment orgApps on Org { apps { envs { name } } }`)
+	is.Equal(w.Code, http.StatusBadRequest)
+	is.NoErr(json.Unmarshal(w.Body.Bytes(), &result))
+	is.Equal(result.Errors[0].Message, "query is nested 4 levels deep, at most 3 are allowed")
+}
+
+func TestGRPCTracingAndMetrics(t *testing.T) {
+	is := is.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := NewMockWalhallAPIer(ctrl)
+	m.EXPECT().ListOrgs().Return(map[string]string{"org-one": "ORGID01"}, nil).Times(1)
+	var span *tracing.Span
+	server := server{
+		newWalhall: func(ctx context.Context, jwt string) (walhallapi.WalhallAPIer, error) {
+			span = tracing.SpanFromContext(ctx)
+			return m, nil
+		},
+	}
+	client, ctx, stop := dialGRPC(t, &server, testJWT)
+	defer stop()
+	before := grpcCalls.Value("/walhall.v1.Walhall/ListOrgs", "OK")
+
+	// Calls continue the caller's trace and are counted by method and code
+	traceparent := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	ctx = metadata.AppendToOutgoingContext(ctx, tracing.TraceparentHeader, traceparent)
+	_, err := client.ListOrgs(ctx, &walhallpb.ListOrgsRequest{})
+	is.NoErr(err)
+	is.True(span != nil)
+	is.Equal(span.SpanContext().TraceID.String(), "0af7651916cd43dd8448eb211c80319c")
+	is.Equal(grpcCalls.Value("/walhall.v1.Walhall/ListOrgs", "OK"), before+1)
+	is.Equal(grpcInFlight.Value(), float64(0))
+}
+
+// dialGRPC serves the gRPC API of server in memory and returns a client calling it with jwt.
+func dialGRPC(t *testing.T, server *server, jwt string) (walhallpb.WalhallClient, context.Context, func()) {
+	srv := server.newGRPCServer()
+	listener := bufconn.Listen(1 << 20)
+	go srv.Serve(listener)
+	conn, err := grpc.NewClient("passthrough:///walhall",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if jwt != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", jwt)
+	}
+	return walhallpb.NewWalhallClient(conn), ctx, func() {
+		conn.Close()
+		srv.Stop()
+	}
+}
+
+func TestGRPC(t *testing.T) {
+	is := is.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := NewMockWalhallAPIer(ctrl)
+	m.EXPECT().ListOrgs().Return(map[string]string{"org-two": "ORGID02", "org-one": "ORGID01"}, nil).Times(1)
+	m.EXPECT().
+		ListModules("org-one").
+		Return([]walhallapi.Module{{Name: "module-one", Image: "module-one", Versions: []walhallapi.ModuleVersion{{Version: "1.0.0"}}}}, nil).
+		Times(1)
+	m.EXPECT().GetEnvDetail("org-one", "app", "staging").Return(walhallapi.EnvironmentDetail{}, walhallapi.ErrNotFound).Times(1)
+	m.EXPECT().
+		GetRefreshModulesStatus("org-one").
+		Return("", fmt.Errorf("get status: %w", &walhallapi.CircuitOpenError{Host: "api.walhall.io", RetryAfter: time.Second})).
+		Times(1)
+
+	var jwts []string
+	server := server{
+		newWalhall: func(ctx context.Context, jwt string) (walhallapi.WalhallAPIer, error) {
+			jwts = append(jwts, jwt)
+			return m, nil
+		},
+		registryName: "registry.walhall.io",
+	}
+	client, ctx, stop := dialGRPC(t, &server, "Bearer "+strings.TrimPrefix(testJWT, "JWT "))
+	defer stop()
+
+	orgs, err := client.ListOrgs(ctx, &walhallpb.ListOrgsRequest{})
+	is.NoErr(err)
+	is.Equal(orgs.Orgs, []string{"org-one", "org-two"})
+	is.Equal(jwts[0], testJWT) // the JWT is passed through in the form Walhall expects
+
+	modules, err := client.ListModules(ctx, &walhallpb.ListModulesRequest{Org: "org-one"})
+	is.NoErr(err)
+	is.Equal(len(modules.Modules), 1)
+	is.Equal(modules.Modules[0].Name, "module-one")
+	is.Equal(modules.Modules[0].Builds[0].Image, "registry.walhall.io/org-one/module-one:1.0.0")
+	is.Equal(modules.Modules[0].Latest.Image, "registry.walhall.io/org-one/module-one:1.0.0")
+
+	_, err = client.GetEnv(ctx, &walhallpb.GetEnvRequest{Org: "org-one", App: "app", Env: "staging"})
+	is.Equal(status.Code(err), codes.NotFound)
+
+	var header metadata.MD
+	_, err = client.GetRefreshModulesStatus(ctx, &walhallpb.GetRefreshModulesStatusRequest{Org: "org-one"}, grpc.Header(&header))
+	is.Equal(status.Code(err), codes.Unavailable)
+	is.Equal(header.Get("retry-after"), []string{"1"})
+	is.Equal(len(header.Get("x-request-id")), 1)
+
+	_, err = client.ListModules(ctx, &walhallpb.ListModulesRequest{})
+	is.Equal(status.Code(err), codes.InvalidArgument)
+}
+
+func TestGRPCSetModuleVersions(t *testing.T) {
+	is := is.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var env walhallapi.Environment
+	is.NoErr(json.Unmarshal([]byte(`{"env_uuid": "ENV01", "name": "development", "logic_module_versions": [
+		{"id": 1, "version_uuid": "MV01", "version": "1.0.0", "logic_module": {"name": "module-one"}}
+	]}`), &env))
+	modules := []walhallapi.Module{
+		{Name: "module-one", Versions: []walhallapi.ModuleVersion{{ID: 3, Version: "NEW_TAG"}, {ID: 2, Version: "1.1.0"}, {ID: 1, Version: "1.0.0"}}},
+		{Name: "module-two", Versions: []walhallapi.ModuleVersion{{ID: 4, Version: "2.0.0"}}},
+	}
+
+	m := NewMockWalhallAPIer(ctrl)
+	m.EXPECT().GetEnv("org-one", "app", "development").Return(env, nil).Times(3)
+	m.EXPECT().ListModules("org-one").Return(modules, nil).Times(3)
+	m.EXPECT().PatchEnv(env, []int{2, 4}).Return(env, nil).Times(1)
+	m.EXPECT().PatchEnv(env, []int{3}).Return(env, nil).Times(1)
+	m.EXPECT().DeployToEnvironment(env).Return(nil).Times(1)
+	server := server{
+		newWalhall: func(ctx context.Context, jwt string) (walhallapi.WalhallAPIer, error) {
+			return m, nil
+		},
+	}
+	client, ctx, stop := dialGRPC(t, &server, testJWT)
+	defer stop()
+
+	// Module references are resolved to the newest matching version
+	pbEnv, err := client.SetModuleVersions(ctx, &walhallpb.SetModuleVersionsRequest{
+		Org: "org-one", App: "app", Env: "development", Modules: []string{"module-one@^1.0", "module-two@latest"},
+	})
+	is.NoErr(err)
+	is.Equal(pbEnv.Name, "development")
+
+	_, err = client.Deploy(ctx, &walhallpb.DeployRequest{
+		Org: "org-one", App: "app", Env: "development", Modules: []string{"module-one@NEW_TAG"},
+	})
+	is.NoErr(err)
+
+	_, err = client.SetModuleVersions(ctx, &walhallpb.SetModuleVersionsRequest{
+		Org: "org-one", App: "app", Env: "development", Modules: []string{"module-one@^3"},
+	})
+	is.Equal(status.Code(err), codes.NotFound)
+	_, err = client.SetModuleVersions(ctx, &walhallpb.SetModuleVersionsRequest{
+		Org: "org-one", App: "app", Env: "development", Modules: []string{"module-one@^x"},
+	})
+	is.Equal(status.Code(err), codes.InvalidArgument)
+	_, err = client.SetModuleVersions(ctx, &walhallpb.SetModuleVersionsRequest{Org: "org-one", App: "app", Env: "development"})
+	is.Equal(status.Code(err), codes.InvalidArgument)
+}
+
+func TestGRPCTagPolicy(t *testing.T) {
+	is := is.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	env := walhallapi.Environment{UUID: "ENV01", Name: "development"}
+	modules := []walhallapi.Module{
+		{Name: "module-one", Versions: []walhallapi.ModuleVersion{{ID: 3, Version: "NEW_TAG"}, {ID: 2, Version: "1.1.0"}}},
+	}
+
+	m := NewMockWalhallAPIer(ctrl)
+	m.EXPECT().GetEnv("org-one", "app", "development").Return(env, nil).Times(1)
+	m.EXPECT().ListModules("org-one").Return(modules, nil).Times(1)
+	m.EXPECT().PatchEnv(env, []int{3}).Return(env, nil).Times(1)
+	server := server{
+		newWalhall: func(ctx context.Context, jwt string) (walhallapi.WalhallAPIer, error) {
+			return m, nil
+		},
+		tagPolicy: walhallapi.TagsNewest,
+	}
+	client, ctx, stop := dialGRPC(t, &server, testJWT)
+	defer stop()
+
+	// latest is resolved with the configured tag policy
+	_, err := client.SetModuleVersions(ctx, &walhallpb.SetModuleVersionsRequest{
+		Org: "org-one", App: "app", Env: "development", Modules: []string{"module-one@latest"},
+	})
+	is.NoErr(err)
+}
+
+func TestGRPCConfigs(t *testing.T) {
+	is := is.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var env walhallapi.Environment
+	is.NoErr(json.Unmarshal([]byte(`{"env_uuid": "ENV01", "name": "development", "logic_module_versions": [
+		{"id": 1, "version_uuid": "MV01", "version": "1.0.0", "logic_module": {"name": "module-one"}},
+		{"id": 2, "version_uuid": "MV02", "version": "2.0.0", "logic_module": {"name": "module-two"}}
+	]}`), &env))
+	config := walhallapi.Config{ID: 7, Name: "config", Spec: map[string]interface{}{}, EnvUUID: "ENV01", ModuleVersionID: 1}
+	detail := walhallapi.EnvironmentDetail{Environment: env, Configs: map[int][]walhallapi.Config{1: {config}}}
+	partial := fmt.Errorf("get env detail: %w", &walhallapi.PartialError{
+		Total: 2, Failures: []walhallapi.ItemError{{Index: 1, Err: errors.New("connection refused")}},
+	})
+
+	m := NewMockWalhallAPIer(ctrl)
+	m.EXPECT().GetEnvDetail("org-one", "app", "development").Return(detail, nil).Times(4)
+	m.EXPECT().GetEnvDetail("org-one", "app", "staging").Return(detail, partial).Times(2)
+	updated := config
+	updated.Name = "renamed"
+	m.EXPECT().UpdateConfiguration(updated).Return(updated, nil).Times(1)
+	m.EXPECT().DeleteConfiguration(7).Return(nil).Times(1)
+	server := server{
+		newWalhall: func(ctx context.Context, jwt string) (walhallapi.WalhallAPIer, error) {
+			return m, nil
+		},
+	}
+	client, ctx, stop := dialGRPC(t, &server, testJWT)
+	defer stop()
+
+	// A config is only changed through the env it belongs to, and stays in it
+	pbConfig, err := client.UpdateConfig(ctx, &walhallpb.UpdateConfigRequest{
+		Org: "org-one", App: "app", Env: "development",
+		Config: &walhallpb.Config{Id: 7, Name: "renamed", EnvUuid: "ENV02", ModuleVersionId: 3},
+	})
+	is.NoErr(err)
+	is.Equal(pbConfig.Name, "renamed")
+	_, err = client.UpdateConfig(ctx, &walhallpb.UpdateConfigRequest{
+		Org: "org-one", App: "app", Env: "development", Config: &walhallpb.Config{Id: 8, Name: "renamed"},
+	})
+	is.Equal(status.Code(err), codes.NotFound)
+	_, err = client.DeleteConfig(ctx, &walhallpb.DeleteConfigRequest{Org: "org-one", App: "app", Env: "development", Id: 8})
+	is.Equal(status.Code(err), codes.NotFound)
+	_, err = client.DeleteConfig(ctx, &walhallpb.DeleteConfigRequest{Org: "org-one", App: "app", Env: "development", Id: 7})
+	is.NoErr(err)
+	_, err = client.DeleteConfig(ctx, &walhallpb.DeleteConfigRequest{Org: "org-one", Id: 7})
+	is.Equal(status.Code(err), codes.InvalidArgument)
+
+	// If the configs of some module versions could not be fetched, the others are still returned
+	pbEnv, err := client.GetEnv(ctx, &walhallpb.GetEnvRequest{Org: "org-one", App: "app", Env: "staging"})
+	is.NoErr(err)
+	is.Equal(len(pbEnv.ModuleVersions), 2)
+	is.Equal(len(pbEnv.ModuleVersions[0].Configs), 1)
+	is.Equal(pbEnv.ModuleVersions[0].ConfigsError, "")
+	is.Equal(pbEnv.ModuleVersions[1].ConfigsError, "Call to Walhall failed")
+	_, err = client.DeleteConfig(ctx, &walhallpb.DeleteConfigRequest{Org: "org-one", App: "app", Env: "staging", Id: 8})
+	is.Equal(status.Code(err), codes.Internal)
+}
+
+func TestGRPCAuth(t *testing.T) {
+	is := is.New(t)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := NewMockWalhallAPIer(ctrl)
+	m.EXPECT().ListOrgs().Return(map[string]string{"org-one": "ORGID01", "org-two": "ORGID02"}, nil).AnyTimes()
+	m.EXPECT().GetRefreshModulesStatus("org-one").Return("success", nil).Times(1)
+	server := server{
+		newWalhall: func(ctx context.Context, jwt string) (walhallapi.WalhallAPIer, error) {
+			return m, nil
+		},
+		policy: &auth.Policy{
+			OrgMembership: true,
+			DefaultScopes: []string{"read"},
+			Routes:        map[string][]string{"refreshModules": {"write"}},
+		},
+	}
+
+	client, ctx, stop := dialGRPC(t, &server, "")
+	_, err := client.ListOrgs(ctx, &walhallpb.ListOrgsRequest{})
+	is.Equal(status.Code(err), codes.Unauthenticated)
+	stop()
+
+	// The policy applies to methods by their route names
+	client, ctx, stop = dialGRPC(t, &server, signedTestJWT(t, "read", "ORGID01"))
+	defer stop()
+	_, err = client.GetRefreshModulesStatus(ctx, &walhallpb.GetRefreshModulesStatusRequest{Org: "org-one"})
+	is.NoErr(err)
+	_, err = client.RefreshModules(ctx, &walhallpb.RefreshModulesRequest{Org: "org-one"})
+	is.Equal(status.Code(err), codes.PermissionDenied)
+	_, err = client.GetRefreshModulesStatus(ctx, &walhallpb.GetRefreshModulesStatusRequest{Org: "org-two"})
+	is.Equal(status.Code(err), codes.PermissionDenied)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/handlers"
+	"humanitec.io/walhallapiadaptor/internal/config"
+)
+
+// newCORS returns a middleware which answers preflight requests and adds CORS headers to responses for the configured
+// origins. It runs before routing and authentication, as browsers send preflight requests without credentials.
+func newCORS(cfg config.CORSConfig) func(http.Handler) http.Handler {
+	options := []handlers.CORSOption{
+		handlers.AllowedOrigins(cfg.AllowedOrigins),
+		handlers.AllowedMethods(cfg.AllowedMethods),
+		handlers.AllowedHeaders(cfg.AllowedHeaders),
+		handlers.ExposedHeaders(cfg.ExposedHeaders),
+		handlers.MaxAge(int(time.Duration(cfg.MaxAge) / time.Second)),
+		handlers.OptionStatusCode(http.StatusNoContent),
+	}
+	if cfg.AllowCredentials {
+		options = append(options, handlers.AllowCredentials())
+	}
+	cors := handlers.CORS(options...)
+
+	anyOrigin := false
+	for _, origin := range cfg.AllowedOrigins {
+		anyOrigin = anyOrigin || origin == "*"
+	}
+	return func(next http.Handler) http.Handler {
+		withCORS := cors(next)
+		if anyOrigin {
+			return withCORS
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Responses differ by origin, so caches must not hand one origin's response to another
+			w.Header().Add("Vary", "Origin")
+			withCORS.ServeHTTP(w, r)
+		})
+	}
+}
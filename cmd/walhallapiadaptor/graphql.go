@@ -0,0 +1,535 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"humanitec.io/walhallapiadaptor/internal/logging"
+	"humanitec.io/walhallapiadaptor/internal/walhallapi"
+)
+
+// graphQLLoaders batch and deduplicate the calls to Walhall made while resolving one GraphQL request.
+type graphQLLoaders struct {
+	// checkScopes authorizes loading a field as the route named like it, see server.checkScopes
+	checkScopes func(route string) error
+
+	orgs    *batchLoader // key: struct{}{}, value: map[string]string from org names to UUIDs
+	apps    *batchLoader // key: org name, value: map[string]string from app names to UUIDs
+	envs    *batchLoader // key: appKey, value: []walhallapi.Environment
+	modules *batchLoader // key: org name, value: []walhallapi.Module
+	configs *batchLoader // key: configsKey, value: []walhallapi.Config
+
+	budget *callBudget
+}
+
+// The limits of one GraphQL request unless configured otherwise. The deepest query over the Walhall model has 7
+// levels, the introspection query of GraphiQL 12.
+const (
+	defaultGraphQLMaxDepth = 15
+	defaultGraphQLMaxCalls = 200
+)
+
+type appKey struct {
+	org, app string
+}
+
+type configsKey struct {
+	envUUID         string
+	moduleVersionID int
+}
+
+// newGraphQLLoaders returns the loaders for one request. The orgs the request may not access are left out, like
+// their REST routes are forbidden.
+func (s *server) newGraphQLLoaders(ctx context.Context, walhall walhallapi.WalhallAPIer) *graphQLLoaders {
+	parallelism := s.parallelism
+	if parallelism < 1 {
+		parallelism = walhallapi.DefaultParallelism
+	}
+	budget := &callBudget{max: s.graphQLMaxCalls}
+	if budget.max < 1 {
+		budget.max = defaultGraphQLMaxCalls
+	}
+	return &graphQLLoaders{
+		checkScopes: func(route string) error {
+			return s.checkScopes(ctx, route)
+		},
+		budget: budget,
+		orgs: newBatchLoader(ctx, parallelism, budget, func(key interface{}) (interface{}, error) {
+			orgs, err := walhall.ListOrgs()
+			if err != nil {
+				return nil, err
+			}
+			return s.memberOrgs(ctx, orgs), nil
+		}),
+		apps: newBatchLoader(ctx, parallelism, budget, func(key interface{}) (interface{}, error) {
+			return walhall.ListApps(key.(string))
+		}),
+		envs: newBatchLoader(ctx, parallelism, budget, func(key interface{}) (interface{}, error) {
+			app := key.(appKey)
+			return walhall.ListEnvs(app.org, app.app)
+		}),
+		modules: newBatchLoader(ctx, parallelism, budget, func(key interface{}) (interface{}, error) {
+			return walhall.ListModules(key.(string))
+		}),
+		configs: newBatchLoader(ctx, parallelism, budget, func(key interface{}) (interface{}, error) {
+			k := key.(configsKey)
+			return walhall.GetConfigsForModuleVersionInEnv(walhallapi.Environment{UUID: k.envUUID},
+				walhallapi.ModuleVersion{ID: k.moduleVersionID})
+		}),
+	}
+}
+
+func loadersFrom(p graphql.ResolveParams) *graphQLLoaders {
+	return p.Context.Value(loadersKey).(*graphQLLoaders)
+}
+
+// authorizeField returns the loaders of a request if it may resolve a field like the named route, which is the route
+// or gRPC method returning the same data, e.g. listModules for the modules of an org.
+func authorizeField(p graphql.ResolveParams, route string) (*graphQLLoaders, error) {
+	loaders := loadersFrom(p)
+	var insufficient *insufficientScopeError
+	if err := loaders.checkScopes(route); errors.As(err, &insufficient) {
+		return nil, fmt.Errorf("insufficient scope for %s, requires %s", p.Info.FieldName, strings.Join(insufficient.required, " "))
+	} else if err != nil {
+		return nil, err
+	}
+	return loaders, nil
+}
+
+// then returns a thunk which transforms the value of a loader's thunk. Errors are reported to the client without
+// upstream details.
+func then(ctx context.Context, thunk func() (interface{}, error), transform func(value interface{}) interface{}) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		value, err := thunk()
+		if err != nil {
+			return nil, graphQLError(ctx, err)
+		}
+		return transform(value), nil
+	}
+}
+
+func graphQLError(ctx context.Context, err error) error {
+	var open *walhallapi.CircuitOpenError
+	switch {
+	case errors.Is(err, walhallapi.ErrNotFound):
+		return errors.New("not found")
+	case errors.As(err, &open):
+		return errors.New("Walhall is unavailable")
+	case errors.Is(err, errBudgetExceeded):
+		return errors.New("query needs too many calls to Walhall")
+	}
+	logging.FromContext(ctx).Error("graphql", "error", err)
+	return errors.New("call to Walhall failed")
+}
+
+// The values resolved for GraphQL objects. Fields are resolved by name unless the schema gives a resolver.
+type (
+	graphQLOrg struct {
+		Name, UUID string
+	}
+	graphQLApp struct {
+		Org, Name, UUID string
+	}
+	graphQLEnv struct {
+		Org, App, Name, UUID string
+		env                  walhallapi.Environment
+	}
+	graphQLModuleVersionInEnv struct {
+		ID            int
+		UUID, Version string
+		Module        walhallapi.Module
+		envUUID       string
+	}
+)
+
+// sortedNames returns the sorted keys of a map from names to UUIDs, such as those returned by ListOrgs and ListApps.
+func sortedNames(m map[string]string) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func toEnvs(org, app string, envs []walhallapi.Environment) []graphQLEnv {
+	result := make([]graphQLEnv, len(envs))
+	for i, env := range envs {
+		result[i] = graphQLEnv{Org: org, App: app, Name: env.Name, UUID: env.UUID, env: env}
+	}
+	return result
+}
+
+var jsonScalar = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "JSON",
+	Description: "Any JSON value",
+	Serialize:   func(value interface{}) interface{} { return value },
+})
+
+func nonNullList(t graphql.Type) graphql.Output {
+	return graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(t)))
+}
+
+// loadedList is the type of lists loaded from Walhall. They are nullable, so a failed call only nulls the field and
+// the rest of the query is still returned.
+func loadedList(t graphql.Type) graphql.Output {
+	return graphql.NewList(graphql.NewNonNull(t))
+}
+
+var nameArgument = graphql.FieldConfigArgument{"name": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)}}
+
+// graphQLSchema describes the Walhall model as orgs, their apps and modules, the apps' environments, the module
+// versions deployed in each environment and their configurations.
+var graphQLSchema = func() graphql.Schema {
+	moduleVersion := graphql.NewObject(graphql.ObjectConfig{
+		Name: "ModuleVersion",
+		Fields: graphql.Fields{
+			"id":      &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"uuid":    &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"version": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		},
+	})
+	module := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Module",
+		Fields: graphql.Fields{
+			"name":     &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"uuid":     &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"repo":     &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"image":    &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"versions": &graphql.Field{Type: nonNullList(moduleVersion)},
+		},
+	})
+	config := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Config",
+		Fields: graphql.Fields{
+			"id":            &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"uuid":          &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"name":          &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"type":          &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"status":        &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"specification": &graphql.Field{Type: jsonScalar},
+			"createdAt":     &graphql.Field{Type: graphql.String},
+			"editedAt":      &graphql.Field{Type: graphql.String},
+		},
+	})
+	moduleVersionInEnv := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "ModuleVersionInEnv",
+		Description: "A module version deployed in an environment",
+		Fields: graphql.Fields{
+			"id":      &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"uuid":    &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"version": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"module":  &graphql.Field{Type: graphql.NewNonNull(module)},
+			"configs": &graphql.Field{
+				Type: loadedList(config),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					loaders, err := authorizeField(p, "listConfigs")
+					if err != nil {
+						return nil, err
+					}
+					mv := p.Source.(graphQLModuleVersionInEnv)
+					thunk := loaders.configs.Load(configsKey{envUUID: mv.envUUID, moduleVersionID: mv.ID})
+					return then(p.Context, thunk, func(value interface{}) interface{} { return value }), nil
+				},
+			},
+		},
+	})
+	env := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Env",
+		Fields: graphql.Fields{
+			"name": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"uuid": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"moduleVersions": &graphql.Field{
+				Type: nonNullList(moduleVersionInEnv),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					env := p.Source.(graphQLEnv).env
+					versions := make([]graphQLModuleVersionInEnv, len(env.ModuleVersions))
+					for i, mv := range env.ModuleVersions {
+						versions[i] = graphQLModuleVersionInEnv{
+							ID: mv.ID, UUID: mv.UUID, Version: mv.Version, Module: mv.Module, envUUID: env.UUID,
+						}
+					}
+					return versions, nil
+				},
+			},
+		},
+	})
+	app := graphql.NewObject(graphql.ObjectConfig{
+		Name: "App",
+		Fields: graphql.Fields{
+			"name": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"uuid": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"envs": &graphql.Field{
+				Type: loadedList(env),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					loaders, err := authorizeField(p, "listEnvs")
+					if err != nil {
+						return nil, err
+					}
+					app := p.Source.(graphQLApp)
+					thunk := loaders.envs.Load(appKey{org: app.Org, app: app.Name})
+					return then(p.Context, thunk, func(value interface{}) interface{} {
+						return toEnvs(app.Org, app.Name, value.([]walhallapi.Environment))
+					}), nil
+				},
+			},
+			"env": &graphql.Field{
+				Type: env,
+				Args: nameArgument,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					loaders, err := authorizeField(p, "listEnvs")
+					if err != nil {
+						return nil, err
+					}
+					app := p.Source.(graphQLApp)
+					name := p.Args["name"].(string)
+					thunk := loaders.envs.Load(appKey{org: app.Org, app: app.Name})
+					return then(p.Context, thunk, func(value interface{}) interface{} {
+						for _, env := range toEnvs(app.Org, app.Name, value.([]walhallapi.Environment)) {
+							if env.Name == name {
+								return env
+							}
+						}
+						return nil
+					}), nil
+				},
+			},
+		},
+	})
+	org := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Org",
+		Fields: graphql.Fields{
+			"name": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"uuid": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"apps": &graphql.Field{
+				Type: loadedList(app),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					loaders, err := authorizeField(p, "listApps")
+					if err != nil {
+						return nil, err
+					}
+					org := p.Source.(graphQLOrg)
+					return then(p.Context, loaders.apps.Load(org.Name), func(value interface{}) interface{} {
+						apps := value.(map[string]string)
+						result := make([]graphQLApp, 0, len(apps))
+						for _, name := range sortedNames(apps) {
+							result = append(result, graphQLApp{Org: org.Name, Name: name, UUID: apps[name]})
+						}
+						return result
+					}), nil
+				},
+			},
+			"app": &graphql.Field{
+				Type: app,
+				Args: nameArgument,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					loaders, err := authorizeField(p, "listApps")
+					if err != nil {
+						return nil, err
+					}
+					org := p.Source.(graphQLOrg)
+					name := p.Args["name"].(string)
+					return then(p.Context, loaders.apps.Load(org.Name), func(value interface{}) interface{} {
+						if uuid, ok := value.(map[string]string)[name]; ok {
+							return graphQLApp{Org: org.Name, Name: name, UUID: uuid}
+						}
+						return nil
+					}), nil
+				},
+			},
+			"modules": &graphql.Field{
+				Type: loadedList(module),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					loaders, err := authorizeField(p, "listModules")
+					if err != nil {
+						return nil, err
+					}
+					org := p.Source.(graphQLOrg)
+					thunk := loaders.modules.Load(org.Name)
+					return then(p.Context, thunk, func(value interface{}) interface{} { return value }), nil
+				},
+			},
+		},
+	})
+
+	orgsOf := func(value interface{}) []graphQLOrg {
+		orgs := value.(map[string]string)
+		result := make([]graphQLOrg, 0, len(orgs))
+		for _, name := range sortedNames(orgs) {
+			result = append(result, graphQLOrg{Name: name, UUID: orgs[name]})
+		}
+		return result
+	}
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"orgs": &graphql.Field{
+				Type:        loadedList(org),
+				Description: "The orgs the user is a member of",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					loaders, err := authorizeField(p, "listOrgs")
+					if err != nil {
+						return nil, err
+					}
+					return then(p.Context, loaders.orgs.Load(struct{}{}), func(value interface{}) interface{} {
+						return orgsOf(value)
+					}), nil
+				},
+			},
+			"org": &graphql.Field{
+				Type: org,
+				Args: nameArgument,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					loaders, err := authorizeField(p, "listOrgs")
+					if err != nil {
+						return nil, err
+					}
+					name := p.Args["name"].(string)
+					return then(p.Context, loaders.orgs.Load(struct{}{}), func(value interface{}) interface{} {
+						for _, org := range orgsOf(value) {
+							if org.Name == name {
+								return org
+							}
+						}
+						return nil
+					}), nil
+				},
+			},
+		},
+	})
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: query})
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}()
+
+// graphQLRequest is the body of a POST to /graphql.
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// graphQL returns a handler which executes GraphQL queries over the Walhall model. All resolvers of a request share
+// loaders, so each distinct call to Walhall is made once per request and the calls for the objects on one level of
+// the query are made together, in parallel. Queries nested deeper than the maximum depth are rejected before they are
+// executed, and queries which need more calls to Walhall than the budget of a request are rejected once it is used up.
+func (s *server) graphQL() func(w http.ResponseWriter, r *http.Request) {
+	maxDepth := s.graphQLMaxDepth
+	if maxDepth < 1 {
+		maxDepth = defaultGraphQLMaxDepth
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		walhall, err := s.walhall(r)
+		if err != nil {
+			unauthorized(w, err, `"Unable to parse JWT"`)
+			return
+		}
+		var request graphQLRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `"Invalid GraphQL request"`)
+			return
+		}
+
+		loaders := s.newGraphQLLoaders(r.Context(), walhall)
+		result := executeGraphQLRequest(context.WithValue(r.Context(), loadersKey, loaders), request, maxDepth)
+		if loaders.budget.Exceeded() {
+			result = &graphql.Result{Errors: []gqlerrors.FormattedError{gqlerrors.NewFormattedError(
+				fmt.Sprintf("query needs more than %d calls to Walhall", loaders.budget.max))}}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		// A query which could not be executed at all, e.g. because it is invalid, has no data
+		if result.Data == nil && result.HasErrors() {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		encoder := json.NewEncoder(w)
+		err = encoder.Encode(result)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("encode response", "error", err)
+			return
+		}
+	}
+}
+
+// executeGraphQLRequest parses, validates and executes a request like graphql.Do, but rejects valid queries nested
+// deeper than maxDepth.
+func executeGraphQLRequest(ctx context.Context, request graphQLRequest, maxDepth int) *graphql.Result {
+	document, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{
+		Body: []byte(request.Query),
+		Name: "GraphQL request",
+	})})
+	if err != nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(err)}
+	}
+	if validation := graphql.ValidateDocument(&graphQLSchema, document, nil); !validation.IsValid {
+		return &graphql.Result{Errors: validation.Errors}
+	}
+	if depth := queryDepth(document); depth > maxDepth {
+		return &graphql.Result{Errors: []gqlerrors.FormattedError{gqlerrors.NewFormattedError(
+			fmt.Sprintf("query is nested %d levels deep, at most %d are allowed", depth, maxDepth))}}
+	}
+	return graphql.Execute(graphql.ExecuteParams{
+		Schema:        graphQLSchema,
+		AST:           document,
+		OperationName: request.OperationName,
+		Args:          request.Variables,
+		Context:       ctx,
+	})
+}
+
+// queryDepth returns how deeply the fields of the deepest operation in a valid document are nested, counting the
+// fields of fragments where they are spread.
+func queryDepth(document *ast.Document) int {
+	fragments := make(map[string]*ast.SelectionSet)
+	for _, definition := range document.Definitions {
+		if fragment, ok := definition.(*ast.FragmentDefinition); ok {
+			fragments[fragment.Name.Value] = fragment.SelectionSet
+		}
+	}
+	var depth func(set *ast.SelectionSet) int
+	depth = func(set *ast.SelectionSet) int {
+		if set == nil {
+			return 0
+		}
+		deepest := 0
+		for _, selection := range set.Selections {
+			var d int
+			switch selection := selection.(type) {
+			case *ast.Field:
+				d = 1 + depth(selection.SelectionSet)
+			case *ast.InlineFragment:
+				d = depth(selection.SelectionSet)
+			case *ast.FragmentSpread:
+				// Validation rejects cycles of fragments
+				d = depth(fragments[selection.Name.Value])
+			}
+			if d > deepest {
+				deepest = d
+			}
+		}
+		return deepest
+	}
+	deepest := 0
+	for _, definition := range document.Definitions {
+		if operation, ok := definition.(*ast.OperationDefinition); ok {
+			if d := depth(operation.SelectionSet); d > deepest {
+				deepest = d
+			}
+		}
+	}
+	return deepest
+}
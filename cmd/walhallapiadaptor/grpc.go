@@ -0,0 +1,548 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"humanitec.io/walhallapiadaptor/internal/auth"
+	"humanitec.io/walhallapiadaptor/internal/logging"
+	"humanitec.io/walhallapiadaptor/internal/walhallapi"
+	"humanitec.io/walhallapiadaptor/walhallpb"
+)
+
+// newGRPCServer returns a gRPC server for the Walhall service. Calls are traced, instrumented, authenticated, rate
+// limited and authorized like REST requests to the route of the same name.
+func (s *server) newGRPCServer() *grpc.Server {
+	srv := grpc.NewServer(grpc.ChainUnaryInterceptor(s.logCalls, s.traceCall, s.instrumentCall, s.authenticateCall,
+		s.rateLimitCall, s.authorizeCall))
+	walhallpb.RegisterWalhallServer(srv, &grpcService{server: s})
+	return srv
+}
+
+// grpcRoute returns the route name of a gRPC method: the method name with a lower case first letter, e.g.
+// "/walhall.v1.Walhall/ListModules" is "listModules" like the REST route.
+func grpcRoute(fullMethod string) string {
+	name := fullMethod[strings.LastIndexByte(fullMethod, '/')+1:]
+	first, size := utf8.DecodeRuneInString(name)
+	return string(unicode.ToLower(first)) + name[size:]
+}
+
+// orgOf returns the org a request is about, or "" for calls outside an org.
+func orgOf(req interface{}) string {
+	if r, ok := req.(interface{ GetOrg() string }); ok {
+		return r.GetOrg()
+	}
+	return ""
+}
+
+func peerAddr(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		return p.Addr.String()
+	}
+	return ""
+}
+
+// logCalls is an interceptor which tags each call with a request ID, like logRequests, and logs its outcome.
+func (s *server) logCalls(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	requestIDKey := strings.ToLower(walhallapi.RequestIDHeader)
+	var requestID string
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(requestIDKey)) > 0 {
+		requestID = md.Get(requestIDKey)[0]
+	}
+	if !validRequestID.MatchString(requestID) {
+		requestID = newRequestID()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDKey, requestID))
+	ctx = logging.WithRequestID(ctx, requestID)
+
+	resp, err := handler(ctx, req)
+
+	code := status.Code(err)
+	logger := logging.FromContext(ctx)
+	level := logger.Info
+	if serverFailure(code) {
+		level = logger.Error
+	}
+	level("call",
+		"method", info.FullMethod,
+		"code", code.String(),
+		"duration_ms", time.Since(start),
+		"remote_addr", peerAddr(ctx))
+	return resp, err
+}
+
+// serverFailure reports whether a call ended with code because the adaptor or Walhall failed, like a 5xx response.
+func serverFailure(code codes.Code) bool {
+	switch code {
+	case codes.Internal, codes.Unknown, codes.Unavailable:
+		return true
+	}
+	return false
+}
+
+// authenticateCall is an interceptor which accepts the same credentials as authenticate, taken from the call's
+// metadata instead of headers, and passes the JWT through to Walhall.
+func (s *server) authenticateCall(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	header := make(http.Header)
+	for _, key := range []string{"authorization", auth.APIKeyHeader, "cookie"} {
+		for _, value := range md.Get(key) {
+			header.Add(key, value)
+		}
+	}
+	token, err := s.credentials.TokenFromRequest(&http.Request{Header: header})
+	if err != nil {
+		logging.FromContext(ctx).Warn("authenticate", "error", err)
+		return nil, status.Error(codes.Unauthenticated, "Missing or unsupported credentials")
+	}
+	claims, err := s.verify(token)
+	if err != nil {
+		logging.FromContext(ctx).Warn("authenticate", "error", err)
+		return nil, status.Error(codes.Unauthenticated, "Invalid JWT")
+	}
+	walhall, err := s.newWalhall(ctx, "JWT "+token)
+	if err != nil {
+		logging.FromContext(ctx).Warn("authenticate", "error", err)
+		return nil, status.Error(codes.Unauthenticated, "Unable to parse JWT")
+	}
+	ctx = auth.WithClaims(ctx, claims)
+	ctx = context.WithValue(ctx, walhallKey, walhall)
+	return handler(ctx, req)
+}
+
+// rateLimitCall is an interceptor which counts List and Get calls against the read budget and all others against the
+// write budget, sharing the buckets of REST requests.
+func (s *server) rateLimitCall(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	route := grpcRoute(info.FullMethod)
+	budget, limiter := "read", s.readLimiter
+	if !strings.HasPrefix(route, "list") && !strings.HasPrefix(route, "get") {
+		budget, limiter = "write", s.writeLimiter
+	}
+	if limiter == nil {
+		return handler(ctx, req)
+	}
+	client := s.clientAddr(peerAddr(ctx), metadata.ValueFromIncomingContext(ctx, "x-forwarded-for"))
+	result := limiter.Allow(s.rateLimitKey(ctx, client, orgOf(req)))
+	if !result.Allowed {
+		rateLimited.Inc(info.FullMethod, budget)
+		logging.FromContext(ctx).Warn("rate limit exceeded", "budget", budget, "route", info.FullMethod)
+		grpc.SetHeader(ctx, metadata.Pairs("retry-after", ceilSeconds(result.RetryAfter)))
+		return nil, status.Error(codes.ResourceExhausted, "Rate limit exceeded")
+	}
+	return handler(ctx, req)
+}
+
+// authorizeCall is an interceptor which checks access to the method's route, see checkAccess.
+func (s *server) authorizeCall(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	err := s.checkAccess(ctx, walhallFromContext(ctx), grpcRoute(info.FullMethod), orgOf(req))
+	var insufficient *insufficientScopeError
+	switch {
+	case errors.As(err, &insufficient):
+		return nil, status.Errorf(codes.PermissionDenied, "Insufficient scope, requires %s", strings.Join(insufficient.required, " "))
+	case errors.Is(err, errForbiddenOrg):
+		return nil, status.Error(codes.PermissionDenied, "Access to org forbidden")
+	case err != nil:
+		return nil, grpcError(ctx, "authorize", err)
+	}
+	return handler(ctx, req)
+}
+
+// walhallFromContext returns the Walhall client authenticateCall created for the call.
+func walhallFromContext(ctx context.Context) walhallapi.WalhallAPIer {
+	walhall, _ := ctx.Value(walhallKey).(walhallapi.WalhallAPIer)
+	return walhall
+}
+
+// grpcError converts a failed call to Walhall Core to a status: NotFound, Unavailable if the circuit breaker rejected
+// the call because Walhall Core is down, otherwise Internal without upstream details.
+func grpcError(ctx context.Context, operation string, err error) error {
+	var open *walhallapi.CircuitOpenError
+	switch {
+	case errors.Is(err, walhallapi.ErrNotFound):
+		return status.Error(codes.NotFound, "Not found")
+	case errors.As(err, &open):
+		grpc.SetHeader(ctx, metadata.Pairs("retry-after", ceilSeconds(open.RetryAfter)))
+		return status.Error(codes.Unavailable, "Walhall is unavailable")
+	}
+	logging.FromContext(ctx).Error(operation, "error", err)
+	return status.Error(codes.Internal, "Call to Walhall failed")
+}
+
+// requireFields returns an InvalidArgument error for the first of the name, value pairs whose value is empty.
+func requireFields(pairs ...string) error {
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] == "" {
+			return status.Errorf(codes.InvalidArgument, "%s is required", pairs[i])
+		}
+	}
+	return nil
+}
+
+// grpcService implements the Walhall gRPC service with the same Walhall calls and conversions as the REST routes.
+type grpcService struct {
+	walhallpb.UnimplementedWalhallServer
+	server *server
+}
+
+func (g *grpcService) ListOrgs(ctx context.Context, req *walhallpb.ListOrgsRequest) (*walhallpb.ListOrgsResponse, error) {
+	orgs, err := walhallFromContext(ctx).ListOrgs()
+	if err != nil {
+		return nil, grpcError(ctx, "list orgs", err)
+	}
+	return &walhallpb.ListOrgsResponse{Orgs: sortedNames(orgs)}, nil
+}
+
+func (g *grpcService) ListModules(ctx context.Context, req *walhallpb.ListModulesRequest) (*walhallpb.ListModulesResponse, error) {
+	if err := requireFields("org", req.Org); err != nil {
+		return nil, err
+	}
+	walhallModules, err := walhallFromContext(ctx).ListModules(req.Org)
+	if err != nil {
+		return nil, grpcError(ctx, "list modules", err)
+	}
+	var response walhallpb.ListModulesResponse
+	for _, module := range g.server.modules(req.Org, walhallModules) {
+		m := &walhallpb.Module{Name: module.Name, Source: module.Source}
+		for _, build := range module.Builds {
+			m.Builds = append(m.Builds, moduleBuildMessage(build))
+		}
+		if module.Latest != nil {
+			m.Latest = moduleBuildMessage(*module.Latest)
+		}
+		response.Modules = append(response.Modules, m)
+	}
+	return &response, nil
+}
+
+func moduleBuildMessage(build ModuleBuild) *walhallpb.ModuleBuild {
+	return &walhallpb.ModuleBuild{Image: build.Image, Commit: build.Commit, Branch: build.Branch, Tags: build.Tags}
+}
+
+func (g *grpcService) RefreshModules(ctx context.Context, req *walhallpb.RefreshModulesRequest) (*walhallpb.RefreshModulesResponse, error) {
+	if err := requireFields("org", req.Org); err != nil {
+		return nil, err
+	}
+	syncStatus, err := walhallFromContext(ctx).RefreshModules(req.Org)
+	if err != nil {
+		return nil, grpcError(ctx, "refresh modules", err)
+	}
+	return &walhallpb.RefreshModulesResponse{Status: syncStatus}, nil
+}
+
+func (g *grpcService) GetRefreshModulesStatus(ctx context.Context, req *walhallpb.GetRefreshModulesStatusRequest) (*walhallpb.RefreshModulesResponse, error) {
+	if err := requireFields("org", req.Org); err != nil {
+		return nil, err
+	}
+	syncStatus, err := walhallFromContext(ctx).GetRefreshModulesStatus(req.Org)
+	if err != nil {
+		return nil, grpcError(ctx, "get refresh modules status", err)
+	}
+	return &walhallpb.RefreshModulesResponse{Status: syncStatus}, nil
+}
+
+func (g *grpcService) ListApps(ctx context.Context, req *walhallpb.ListAppsRequest) (*walhallpb.ListAppsResponse, error) {
+	if err := requireFields("org", req.Org); err != nil {
+		return nil, err
+	}
+	apps, err := walhallFromContext(ctx).ListApps(req.Org)
+	if err != nil {
+		return nil, grpcError(ctx, "list apps", err)
+	}
+	var response walhallpb.ListAppsResponse
+	for _, name := range sortedNames(apps) {
+		response.Apps = append(response.Apps, &walhallpb.App{Name: name, Uuid: apps[name]})
+	}
+	return &response, nil
+}
+
+func (g *grpcService) ListEnvs(ctx context.Context, req *walhallpb.ListEnvsRequest) (*walhallpb.ListEnvsResponse, error) {
+	if err := requireFields("org", req.Org, "app", req.App); err != nil {
+		return nil, err
+	}
+	envs, err := walhallFromContext(ctx).ListEnvs(req.Org, req.App)
+	if err != nil {
+		return nil, grpcError(ctx, "list envs", err)
+	}
+	var response walhallpb.ListEnvsResponse
+	for _, env := range envs {
+		pbEnv, err := toPBEnv(env, nil)
+		if err != nil {
+			return nil, grpcError(ctx, "list envs", err)
+		}
+		response.Envs = append(response.Envs, pbEnv)
+	}
+	return &response, nil
+}
+
+func (g *grpcService) GetEnv(ctx context.Context, req *walhallpb.GetEnvRequest) (*walhallpb.Env, error) {
+	if err := requireFields("org", req.Org, "app", req.App, "env", req.Env); err != nil {
+		return nil, err
+	}
+	detail, err := walhallFromContext(ctx).GetEnvDetail(req.Org, req.App, req.Env)
+	var partial *walhallapi.PartialError
+	if err != nil && !errors.As(err, &partial) {
+		return nil, grpcError(ctx, "get env", err)
+	}
+	env, err := toPBEnv(detail.Environment, detail.Configs)
+	if err != nil {
+		return nil, grpcError(ctx, "get env", err)
+	}
+	if partial != nil {
+		// The configs of the other module versions are still returned; each failed one reports why.
+		for _, failure := range partial.Failures {
+			message := status.Convert(grpcError(ctx, "get env", failure.Err)).Message()
+			env.ModuleVersions[failure.Index].ConfigsError = message
+		}
+	}
+	return env, nil
+}
+
+func (g *grpcService) ListConfigs(ctx context.Context, req *walhallpb.ListConfigsRequest) (*walhallpb.ListConfigsResponse, error) {
+	if err := requireFields("org", req.Org, "app", req.App, "env", req.Env); err != nil {
+		return nil, err
+	}
+	walhall := walhallFromContext(ctx)
+	env, err := walhall.GetEnv(req.Org, req.App, req.Env)
+	if err != nil {
+		return nil, grpcError(ctx, "list configs", err)
+	}
+	configs, err := walhall.GetConfigsForModuleVersionInEnv(env, walhallapi.ModuleVersion{ID: int(req.ModuleVersionId)})
+	if err != nil {
+		return nil, grpcError(ctx, "list configs", err)
+	}
+	pbConfigs, err := toPBConfigs(configs)
+	if err != nil {
+		return nil, grpcError(ctx, "list configs", err)
+	}
+	return &walhallpb.ListConfigsResponse{Configs: pbConfigs}, nil
+}
+
+func (g *grpcService) CreateConfig(ctx context.Context, req *walhallpb.CreateConfigRequest) (*walhallpb.Config, error) {
+	if err := requireFields("org", req.Org, "app", req.App, "env", req.Env, "type", req.Type); err != nil {
+		return nil, err
+	}
+	walhall := walhallFromContext(ctx)
+	env, err := walhall.GetEnv(req.Org, req.App, req.Env)
+	if err != nil {
+		return nil, grpcError(ctx, "create config", err)
+	}
+	config, err := walhall.CreateConfiguration(env, walhallapi.ModuleVersion{ID: int(req.ModuleVersionId)}, req.Type)
+	if err != nil {
+		return nil, grpcError(ctx, "create config", err)
+	}
+	pbConfig, err := toPBConfig(config)
+	if err != nil {
+		return nil, grpcError(ctx, "create config", err)
+	}
+	return pbConfig, nil
+}
+
+func (g *grpcService) UpdateConfig(ctx context.Context, req *walhallpb.UpdateConfigRequest) (*walhallpb.Config, error) {
+	if err := requireFields("org", req.Org, "app", req.App, "env", req.Env); err != nil {
+		return nil, err
+	}
+	if req.Config.GetId() == 0 {
+		return nil, status.Error(codes.InvalidArgument, "config.id is required")
+	}
+	walhall := walhallFromContext(ctx)
+	existing, err := envConfig(walhall, req.Org, req.App, req.Env, int(req.Config.Id))
+	if err != nil {
+		return nil, grpcError(ctx, "update config", err)
+	}
+	// The config stays where it is; it cannot be moved to another env or module version.
+	config := fromPBConfig(req.Config)
+	config.EnvUUID = existing.EnvUUID
+	config.ModuleVersionID = existing.ModuleVersionID
+	config, err = walhall.UpdateConfiguration(config)
+	if err != nil {
+		return nil, grpcError(ctx, "update config", err)
+	}
+	pbConfig, err := toPBConfig(config)
+	if err != nil {
+		return nil, grpcError(ctx, "update config", err)
+	}
+	return pbConfig, nil
+}
+
+func (g *grpcService) DeleteConfig(ctx context.Context, req *walhallpb.DeleteConfigRequest) (*walhallpb.DeleteConfigResponse, error) {
+	if err := requireFields("org", req.Org, "app", req.App, "env", req.Env); err != nil {
+		return nil, err
+	}
+	if req.Id == 0 {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+	walhall := walhallFromContext(ctx)
+	if _, err := envConfig(walhall, req.Org, req.App, req.Env, int(req.Id)); err != nil {
+		return nil, grpcError(ctx, "delete config", err)
+	}
+	if err := walhall.DeleteConfiguration(int(req.Id)); err != nil {
+		return nil, grpcError(ctx, "delete config", err)
+	}
+	return &walhallpb.DeleteConfigResponse{}, nil
+}
+
+// envConfig returns the config with the given ID if it belongs to the env named by org, app and env, so that a config
+// is only changed through an env the caller is authorized for. It fails with walhallapi.ErrNotFound if it does not.
+func envConfig(walhall walhallapi.WalhallAPIer, org, app, env string, id int) (walhallapi.Config, error) {
+	detail, err := walhall.GetEnvDetail(org, app, env)
+	var partial *walhallapi.PartialError
+	if err != nil && !errors.As(err, &partial) {
+		return walhallapi.Config{}, err
+	}
+	for _, configs := range detail.Configs {
+		for _, config := range configs {
+			if config.ID == id {
+				return config, nil
+			}
+		}
+	}
+	if partial != nil {
+		// The config may belong to a module version whose configs could not be fetched.
+		return walhallapi.Config{}, err
+	}
+	return walhallapi.Config{}, fmt.Errorf("config %d: %w", id, walhallapi.ErrNotFound)
+}
+
+func (g *grpcService) Deploy(ctx context.Context, req *walhallpb.DeployRequest) (*walhallpb.DeployResponse, error) {
+	if err := requireFields("org", req.Org, "app", req.App, "env", req.Env); err != nil {
+		return nil, err
+	}
+	if err := checkModuleRefs(req.Modules); err != nil {
+		return nil, err
+	}
+	walhall := walhallFromContext(ctx)
+	env, err := walhall.GetEnv(req.Org, req.App, req.Env)
+	if err != nil {
+		return nil, grpcError(ctx, "deploy", err)
+	}
+	if len(req.Modules) > 0 {
+		if env, err = g.server.resolver(walhall, req.Org).SetModuleVersions(env, req.Modules); err != nil {
+			return nil, grpcError(ctx, "deploy", err)
+		}
+	}
+	if err := walhall.DeployToEnvironment(env); err != nil {
+		return nil, grpcError(ctx, "deploy", err)
+	}
+	return &walhallpb.DeployResponse{}, nil
+}
+
+func (g *grpcService) SetModuleVersions(ctx context.Context, req *walhallpb.SetModuleVersionsRequest) (*walhallpb.Env, error) {
+	if err := requireFields("org", req.Org, "app", req.App, "env", req.Env); err != nil {
+		return nil, err
+	}
+	if len(req.Modules) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "modules is required")
+	}
+	if err := checkModuleRefs(req.Modules); err != nil {
+		return nil, err
+	}
+	walhall := walhallFromContext(ctx)
+	env, err := walhall.GetEnv(req.Org, req.App, req.Env)
+	if err != nil {
+		return nil, grpcError(ctx, "set module versions", err)
+	}
+	env, err = g.server.resolver(walhall, req.Org).SetModuleVersions(env, req.Modules)
+	if err != nil {
+		return nil, grpcError(ctx, "set module versions", err)
+	}
+	pbEnv, err := toPBEnv(env, nil)
+	if err != nil {
+		return nil, grpcError(ctx, "set module versions", err)
+	}
+	return pbEnv, nil
+}
+
+// resolver returns a Resolver for the modules of org which orders their tags with the server's tag policy.
+func (s *server) resolver(walhall walhallapi.WalhallAPIer, org string) *walhallapi.Resolver {
+	resolver := walhallapi.NewResolver(walhall, org)
+	resolver.SetTagPolicy(s.tagPolicy)
+	return resolver
+}
+
+// checkModuleRefs returns an InvalidArgument error for the first malformed module reference.
+func checkModuleRefs(refs []string) error {
+	for _, ref := range refs {
+		if _, _, err := walhallapi.ParseModuleRef(ref); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	return nil
+}
+
+// toPBEnv converts an environment and, if given, the configurations of its module versions keyed by their IDs.
+func toPBEnv(env walhallapi.Environment, configs map[int][]walhallapi.Config) (*walhallpb.Env, error) {
+	pbEnv := &walhallpb.Env{Name: env.Name, Uuid: env.UUID}
+	for _, mv := range env.ModuleVersions {
+		pbConfigs, err := toPBConfigs(configs[mv.ID])
+		if err != nil {
+			return nil, err
+		}
+		pbEnv.ModuleVersions = append(pbEnv.ModuleVersions, &walhallpb.EnvModuleVersion{
+			Id:      int64(mv.ID),
+			Uuid:    mv.UUID,
+			Version: mv.Version,
+			Module:  mv.Module.Name,
+			Configs: pbConfigs,
+		})
+	}
+	return pbEnv, nil
+}
+
+func toPBConfigs(configs []walhallapi.Config) ([]*walhallpb.Config, error) {
+	var pbConfigs []*walhallpb.Config
+	for _, config := range configs {
+		pbConfig, err := toPBConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		pbConfigs = append(pbConfigs, pbConfig)
+	}
+	return pbConfigs, nil
+}
+
+func toPBConfig(config walhallapi.Config) (*walhallpb.Config, error) {
+	spec, err := structpb.NewStruct(config.Spec)
+	if err != nil {
+		return nil, err
+	}
+	return &walhallpb.Config{
+		Id:              int64(config.ID),
+		Uuid:            config.UUID,
+		Name:            config.Name,
+		Type:            config.Type,
+		Status:          config.Status,
+		Specification:   spec,
+		EnvUuid:         config.EnvUUID,
+		ModuleVersionId: int64(config.ModuleVersionID),
+		CreatedAt:       config.CreatedAt,
+		EditedAt:        config.EditedAt,
+	}, nil
+}
+
+func fromPBConfig(config *walhallpb.Config) walhallapi.Config {
+	return walhallapi.Config{
+		ID:              int(config.Id),
+		UUID:            config.Uuid,
+		Name:            config.Name,
+		Spec:            config.Specification.AsMap(),
+		Type:            config.Type,
+		Status:          config.Status,
+		EnvUUID:         config.EnvUuid,
+		ModuleVersionID: int(config.ModuleVersionId),
+		CreatedAt:       config.CreatedAt,
+		EditedAt:        config.EditedAt,
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"humanitec.io/walhallapiadaptor/internal/walhallapi"
+)
+
+const (
+	// readinessCacheTTL is how long a readiness result is reused, so that frequent probes don't hammer Walhall.
+	readinessCacheTTL = 10 * time.Second
+	// readinessTimeout bounds how long the reachability check waits for Walhall.
+	readinessTimeout = 5 * time.Second
+)
+
+// checkResult is the outcome of a single dependency check.
+type checkResult struct {
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+// readinessReport is the body of a /readyz response.
+type readinessReport struct {
+	Status    string                 `json:"status"`
+	Checks    map[string]checkResult `json:"checks"`
+	CheckedAt time.Time              `json:"checked_at"`
+}
+
+type dependencyCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// readinessChecker runs the dependency checks and caches the report.
+type readinessChecker struct {
+	checks []dependencyCheck
+	ttl    time.Duration
+
+	mu           sync.Mutex
+	report       *readinessReport
+	shuttingDown bool
+}
+
+// MarkShuttingDown makes all subsequent reports fail, so that no new traffic is routed to the adaptor while it
+// drains.
+func (c *readinessChecker) MarkShuttingDown() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.shuttingDown = true
+}
+
+func (s *server) newReadinessChecker() *readinessChecker {
+	return &readinessChecker{
+		ttl: readinessCacheTTL,
+		checks: []dependencyCheck{
+			{name: "walhall_api_prefix", check: func(ctx context.Context) error {
+				return validateAPIPrefix(s.walhallAPIPrefix)
+			}},
+			{name: "walhall_api_reachable", check: func(ctx context.Context) error {
+				return checkReachable(ctx, s.doer, s.walhallAPIPrefix)
+			}},
+			{name: "registry", check: func(ctx context.Context) error {
+				if s.registryName == "" {
+					return errors.New("WALHALL_REGISTRY is not set")
+				}
+				return nil
+			}},
+		},
+	}
+}
+
+func validateAPIPrefix(prefix string) error {
+	if prefix == "" {
+		return errors.New("WALHALL_API_PREFIX is not set")
+	}
+	u, err := url.Parse(prefix)
+	if err != nil {
+		return fmt.Errorf("WALHALL_API_PREFIX is not a valid URL: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("WALHALL_API_PREFIX must be an absolute http(s) URL, got %q", prefix)
+	}
+	return nil
+}
+
+// checkReachable treats any response below 500 as reachable: without a user's JWT we cannot expect a 200.
+func checkReachable(ctx context.Context, doer walhallapi.Doer, prefix string) error {
+	if err := validateAPIPrefix(prefix); err != nil {
+		return err
+	}
+	if doer == nil {
+		doer = http.DefaultClient
+	}
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, prefix, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := doer.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("walhall responded with %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Report returns the cached report if it is fresh enough, otherwise it runs all checks concurrently.
+func (c *readinessChecker) Report(ctx context.Context) readinessReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.shuttingDown {
+		return readinessReport{Status: "shutting_down", Checks: map[string]checkResult{}, CheckedAt: time.Now().UTC()}
+	}
+	if c.report != nil && time.Since(c.report.CheckedAt) < c.ttl {
+		return *c.report
+	}
+
+	report := readinessReport{Status: "ok", Checks: make(map[string]checkResult), CheckedAt: time.Now().UTC()}
+	results := make([]checkResult, len(c.checks))
+	var wg sync.WaitGroup
+	for i, dependency := range c.checks {
+		wg.Add(1)
+		go func(i int, dependency dependencyCheck) {
+			defer wg.Done()
+			start := time.Now()
+			err := dependency.check(ctx)
+			results[i] = checkResult{Status: "ok", DurationMS: float64(time.Since(start)) / float64(time.Millisecond)}
+			if err != nil {
+				results[i].Status = "fail"
+				results[i].Error = err.Error()
+			}
+		}(i, dependency)
+	}
+	wg.Wait()
+	for i, dependency := range c.checks {
+		report.Checks[dependency.name] = results[i]
+		if results[i].Status != "ok" {
+			report.Status = "fail"
+		}
+	}
+	c.report = &report
+	return report
+}
+
+// healthz returns a handler for liveness probes: if the process can serve HTTP, it is alive.
+func (s *server) healthz() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status":"ok"}`)
+	}
+}
+
+// readyz returns a handler for readiness probes which reports on each dependency, responding with 503 if any of
+// them fail.
+func (s *server) readyz() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := s.readiness.Report(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		if report.Status != "ok" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	}
+}
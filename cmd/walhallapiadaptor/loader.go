@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"humanitec.io/walhallapiadaptor/internal/walhallapi"
+)
+
+// batchLoader loads values by key for the duration of one request. Keys requested with Load are queued and the first
+// time any of their values is needed, all queued keys are loaded at once, in parallel. Each key is loaded at most
+// once, so repeated and sibling lookups in a nested GraphQL query do not repeat upstream calls.
+type batchLoader struct {
+	ctx         context.Context
+	parallelism int
+	budget      *callBudget
+	load        func(key interface{}) (interface{}, error)
+
+	mu      sync.Mutex
+	pending []interface{}
+	queued  map[interface{}]bool
+	results map[interface{}]loadResult
+}
+
+type loadResult struct {
+	value interface{}
+	err   error
+}
+
+// callBudget bounds the calls all loaders of one request make together, so that a small query cannot make an
+// unbounded number of calls to Walhall.
+type callBudget struct {
+	mu       sync.Mutex
+	max      int
+	used     int
+	exceeded bool
+}
+
+// errBudgetExceeded is the error of every key which is not loaded because the budget is used up.
+var errBudgetExceeded = errors.New("call budget exceeded")
+
+// take reserves n calls. It fails without reserving any if fewer than n are left.
+func (b *callBudget) take(n int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.used+n > b.max {
+		b.exceeded = true
+		return errBudgetExceeded
+	}
+	b.used += n
+	return nil
+}
+
+// Exceeded reports whether any call was refused.
+func (b *callBudget) Exceeded() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.exceeded
+}
+
+// newBatchLoader returns a loader which calls load for each key, at most parallelism at a time, and charges each call
+// to budget. Keys must be comparable.
+func newBatchLoader(ctx context.Context, parallelism int, budget *callBudget, load func(key interface{}) (interface{}, error)) *batchLoader {
+	return &batchLoader{
+		ctx:         ctx,
+		parallelism: parallelism,
+		budget:      budget,
+		load:        load,
+		queued:      make(map[interface{}]bool),
+		results:     make(map[interface{}]loadResult),
+	}
+}
+
+// Load queues key to be loaded with the next batch and returns a thunk which returns its value, loading the batch if
+// that has not happened yet.
+func (l *batchLoader) Load(key interface{}) func() (interface{}, error) {
+	l.mu.Lock()
+	if _, done := l.results[key]; !done && !l.queued[key] {
+		l.pending = append(l.pending, key)
+		l.queued[key] = true
+	}
+	l.mu.Unlock()
+
+	return func() (interface{}, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if _, done := l.results[key]; !done {
+			l.dispatch()
+		}
+		result := l.results[key]
+		return result.value, result.err
+	}
+}
+
+// dispatch loads all queued keys. l.mu must be held.
+func (l *batchLoader) dispatch() {
+	keys := l.pending
+	l.pending = nil
+	if err := l.budget.take(len(keys)); err != nil {
+		for _, key := range keys {
+			delete(l.queued, key)
+			l.results[key] = loadResult{err: err}
+		}
+		return
+	}
+	results := make([]loadResult, len(keys))
+	err := walhallapi.FanOut(l.ctx, len(keys), l.parallelism, func(ctx context.Context, i int) error {
+		results[i].value, results[i].err = l.load(keys[i])
+		return results[i].err
+	})
+	// Keys which were not loaded because the request was cancelled only appear in the PartialError
+	var partial *walhallapi.PartialError
+	if errors.As(err, &partial) {
+		for _, failure := range partial.Failures {
+			results[failure.Index].err = failure.Err
+		}
+	}
+	for i, key := range keys {
+		delete(l.queued, key)
+		l.results[key] = results[i]
+	}
+}
@@ -1,40 +1,194 @@
 package main
 
 import (
-	"log"
+	"context"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
+	"strconv"
+	"time"
 
-	"github.com/gorilla/handlers"
+	"google.golang.org/grpc"
+	"humanitec.io/walhallapiadaptor/internal/auth"
+	"humanitec.io/walhallapiadaptor/internal/config"
+	"humanitec.io/walhallapiadaptor/internal/logging"
+	"humanitec.io/walhallapiadaptor/internal/ratelimit"
+	"humanitec.io/walhallapiadaptor/internal/tracing"
 	"humanitec.io/walhallapiadaptor/internal/walhallapi"
 )
 
 type server struct {
-	router       http.Handler
-	newWalhall   func(jwt string) (walhallapi.WalhallAPIer, error)
-	registryName string
+	router           http.Handler
+	newWalhall       func(ctx context.Context, jwt string) (walhallapi.WalhallAPIer, error)
+	walhallAPIPrefix string
+	doer             walhallapi.Doer
+	registryName     string
+	tagPolicy        walhallapi.TagPolicy
+	verifier         *auth.Verifier
+	credentials      auth.Credentials
+	policy           *auth.Policy
+	readiness        *readinessChecker
+	readLimiter      *ratelimit.Limiter
+	writeLimiter     *ratelimit.Limiter
+	trustedProxies   []netip.Prefix
+	cors             func(http.Handler) http.Handler
+	parallelism      int
+	graphQLMaxDepth  int
+	graphQLMaxCalls  int
+	grpcServer       *grpc.Server
+	grpcListener     net.Listener
 }
 
 func main() {
 	var s server
 
-	walhallAPIPrefix := os.Getenv("WALHALL_API_PREFIX")
-	var reusableClient http.Client
+	cfg, err := config.LoadFromOS()
+	if err != nil {
+		fatal("Loading configuration", "error", err)
+	}
+	level, _ := logging.ParseLevel(cfg.LogLevel)
+	logging.SetDefault(logging.New(os.Stdout, level))
+	logger := logging.Default()
+	logger.Info("Effective configuration", "config", cfg.Redacted())
+
+	s.walhallAPIPrefix = cfg.WalhallAPIPrefix
+	// The client's timeout bounds each call to Walhall, including reading the response body
+	reusableClient := http.Client{Timeout: time.Duration(cfg.UpstreamTimeout)}
+	s.doer = &reusableClient
+	if cfg.CircuitBreakerThreshold > 0 {
+		s.doer = walhallapi.NewCircuitBreakerDoer(s.doer, walhallapi.BreakerPolicy{
+			FailureThreshold: cfg.CircuitBreakerThreshold,
+			Cooldown:         time.Duration(cfg.CircuitBreakerCooldown),
+		})
+	}
+	if cfg.UpstreamMaxRetries > 0 {
+		// Retries go through the circuit breaker, so that they stop as soon as it opens
+		policy := walhallapi.DefaultRetryPolicy
+		policy.MaxRetries = cfg.UpstreamMaxRetries
+		s.doer = walhallapi.NewRetryingDoer(s.doer, policy)
+	}
+
+	coalescer := walhallapi.NewCoalescer()
+	s.newWalhall = func(ctx context.Context, jwt string) (walhallapi.WalhallAPIer, error) {
+		walhall, err := walhallapi.NewWithContext(ctx, s.walhallAPIPrefix, jwt, s.doer)
+		if err != nil {
+			return nil, err
+		}
+		// Only verified claims can be trusted to decide which users may share a response
+		walhall.Coalesce(coalescer, s.verifier != nil)
+		walhall.SetParallelism(cfg.UpstreamParallelism)
+		return walhall, nil
+	}
 
-	s.newWalhall = func(jwt string) (walhallapi.WalhallAPIer, error) {
-		return walhallapi.New(walhallAPIPrefix, jwt, &reusableClient)
+	s.parallelism = cfg.UpstreamParallelism
+	s.graphQLMaxDepth = cfg.GraphQL.MaxDepth
+	s.graphQLMaxCalls = cfg.GraphQL.MaxCalls
+	s.registryName = cfg.WalhallRegistry
+	s.tagPolicy, _ = walhallapi.ParseTagPolicy(cfg.ModuleTagPolicy)
+
+	s.credentials.CookieName = cfg.Auth.CookieName
+	s.credentials.AllowedOrigins = cfg.CORS.AllowedOrigins
+	if cfg.Auth.APIKeysFile != "" {
+		apiKeys, err := auth.LoadAPIKeys(cfg.Auth.APIKeysFile)
+		if err != nil {
+			fatal("Loading API keys", "error", err)
+		}
+		s.credentials.SetAPIKeys(apiKeys)
+		logger.Info("Loaded API keys", "count", len(apiKeys))
+	}
+
+	if cfg.Auth.PolicyFile != "" {
+		policy, err := auth.LoadPolicy(cfg.Auth.PolicyFile)
+		if err != nil {
+			fatal("Loading authorization policy", "error", err)
+		}
+		s.policy = policy
+		logger.Info("Authorization policy loaded", "file", cfg.Auth.PolicyFile)
 	}
 
-	s.registryName = os.Getenv("WALHALL_REGISTRY")
+	if cfg.Auth.VerificationEnabled() {
+		verifier, err := auth.NewVerifier(auth.VerifierConfig{
+			HMACSecrets:    cfg.Auth.HMACSecrets,
+			PublicKeyFiles: cfg.Auth.PublicKeyFiles,
+			JWKSFile:       cfg.Auth.JWKSFile,
+			JWKSURL:        cfg.Auth.JWKSURL,
+			Issuer:         cfg.Auth.Issuer,
+			Doer:           &reusableClient,
+		})
+		if err != nil {
+			fatal("Setting up JWT verification", "error", err)
+		}
+		s.verifier = verifier
+		logger.Info("JWT verification enabled")
+	} else {
+		logger.Warn("JWT verification disabled: tokens are passed through unverified")
+	}
 
-	log.Println("Setting up Routes")
+	if limit := ratelimit.Limit(cfg.RateLimit.Read); limit.Enabled() {
+		s.readLimiter = ratelimit.New(limit)
+	}
+	if limit := ratelimit.Limit(cfg.RateLimit.Write); limit.Enabled() {
+		s.writeLimiter = ratelimit.New(limit)
+	}
+	s.trustedProxies, _ = cfg.RateLimit.TrustedProxyPrefixes()
+
+	if cfg.CORS.Enabled() {
+		s.cors = newCORS(cfg.CORS)
+		logger.Info("CORS enabled", "origins", cfg.CORS.AllowedOrigins)
+	}
+
+	resource := tracing.Resource{ServiceName: "walhallapiadaptor"}
+	var exporter tracing.Exporter
+	if endpoint := cfg.Tracing.OTLPEndpoint; endpoint != "" {
+		exporter = tracing.NewHTTPExporter(endpoint, &reusableClient, resource)
+		logger.Info("Exporting traces to collector", "endpoint", endpoint)
+	} else if traceFile := cfg.Tracing.File; traceFile != "" {
+		fileExporter, err := tracing.NewFileExporter(traceFile, resource)
+		if err != nil {
+			fatal("Setting up tracing", "error", err)
+		}
+		exporter = fileExporter
+		logger.Info("Writing traces to file", "file", traceFile)
+	}
+	if exporter != nil {
+		tracing.Default().SetProcessor(tracing.NewBatchProcessor(exporter, 512, 5*time.Second, func(err error) {
+			logger.Warn("Exporting traces", "error", err)
+		}))
+	}
+
+	logger.Info("Setting up Routes")
 	s.setupRoutes()
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	srv := &http.Server{
+		Addr:              ":" + strconv.Itoa(cfg.Port),
+		Handler:           s.router,
+		ReadHeaderTimeout: time.Duration(cfg.Server.ReadHeaderTimeout),
+		ReadTimeout:       time.Duration(cfg.Server.ReadTimeout),
+		WriteTimeout:      time.Duration(cfg.Server.WriteTimeout),
+		IdleTimeout:       time.Duration(cfg.Server.IdleTimeout),
 	}
 
-	log.Printf("Listening on Port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, handlers.LoggingHandler(os.Stdout, s.router)))
+	listener, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		fatal("Listening", "error", err)
+	}
+	logger.Info("Listening", "port", cfg.Port)
+	if cfg.GRPCPort != 0 {
+		s.grpcServer = s.newGRPCServer()
+		s.grpcListener, err = net.Listen("tcp", ":"+strconv.Itoa(cfg.GRPCPort))
+		if err != nil {
+			fatal("Listening for gRPC", "error", err)
+		}
+		logger.Info("Listening for gRPC", "port", cfg.GRPCPort)
+	}
+	if err := s.serve(srv, listener, shutdownSignals(), time.Duration(cfg.Server.ShutdownDelay), time.Duration(cfg.Server.ShutdownTimeout)); err != nil {
+		fatal("Serving", "error", err)
+	}
+}
+
+// fatal logs an error and exits.
+func fatal(msg string, keyvals ...interface{}) {
+	logging.Default().Error(msg, keyvals...)
+	os.Exit(1)
 }
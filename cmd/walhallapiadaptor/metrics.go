@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"humanitec.io/walhallapiadaptor/internal/metrics"
+)
+
+var (
+	httpRequests = metrics.Default.NewCounterVec("walhallapiadaptor_http_requests_total",
+		"Requests handled by the adaptor by route template, method and status code.",
+		"route", "method", "status")
+	httpDuration = metrics.Default.NewHistogramVec("walhallapiadaptor_http_request_duration_seconds",
+		"Time taken to handle requests by route template and method.",
+		metrics.DefaultBuckets, "route", "method")
+	httpInFlight = metrics.Default.NewGauge("walhallapiadaptor_http_requests_in_flight",
+		"Requests currently being handled.")
+
+	grpcCalls = metrics.Default.NewCounterVec("walhallapiadaptor_grpc_calls_total",
+		"gRPC calls handled by the adaptor by method and status code.",
+		"method", "code")
+	grpcDuration = metrics.Default.NewHistogramVec("walhallapiadaptor_grpc_call_duration_seconds",
+		"Time taken to handle gRPC calls by method.",
+		metrics.DefaultBuckets, "method")
+	grpcInFlight = metrics.Default.NewGauge("walhallapiadaptor_grpc_calls_in_flight",
+		"gRPC calls currently being handled.")
+)
+
+// instrument returns a middleware which records the count, latency and status of requests per route. It must be
+// used as router middleware so that the matched route template is known.
+func (s *server) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeLabel(r, "unknown")
+		httpInFlight.Inc()
+		defer httpInFlight.Dec()
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		httpDuration.Observe(time.Since(start).Seconds(), route, r.Method)
+		httpRequests.Inc(route, r.Method, strconv.Itoa(recorder.status))
+	})
+}
+
+// instrumentCall is an interceptor which records the count, latency and status code of gRPC calls per method, like
+// instrument.
+func (s *server) instrumentCall(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	grpcInFlight.Inc()
+	defer grpcInFlight.Dec()
+
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	grpcDuration.Observe(time.Since(start).Seconds(), info.FullMethod)
+	grpcCalls.Inc(info.FullMethod, status.Code(err).String())
+	return resp, err
+}
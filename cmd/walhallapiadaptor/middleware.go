@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"humanitec.io/walhallapiadaptor/internal/auth"
+	"humanitec.io/walhallapiadaptor/internal/logging"
+	"humanitec.io/walhallapiadaptor/internal/openapi"
+	"humanitec.io/walhallapiadaptor/internal/walhallapi"
+)
+
+type contextKey int
+
+const (
+	walhallKey contextKey = iota
+	loadersKey
+)
+
+// validRequestID limits which client supplied request IDs are propagated, so they are safe to log and forward.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// statusRecorder captures the status code and size of a response for logging.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// logRequests returns a middleware which assigns each request an ID (reusing a valid X-Request-ID from the client),
+// echoes it in the response, makes a logger tagged with it available via the request context and logs the outcome
+// of the request.
+func (s *server) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requestID := r.Header.Get(walhallapi.RequestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(walhallapi.RequestIDHeader, requestID)
+		ctx := logging.WithRequestID(r.Context(), requestID)
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		logger := logging.FromContext(ctx)
+		level := logger.Info
+		if recorder.status >= http.StatusInternalServerError {
+			level = logger.Error
+		}
+		level("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"bytes", recorder.bytes,
+			"duration_ms", time.Since(start),
+			"remote_addr", r.RemoteAddr,
+			"user_agent", r.UserAgent())
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// authRealm is the realm advertised in WWW-Authenticate challenges.
+const authRealm = "walhallapiadaptor"
+
+// authenticate returns a middleware which normalizes the credentials on a request to the "JWT <token>" form Walhall
+// expects and rejects requests whose JWT fails verification, before any call is made to Walhall. If no verifier is
+// configured, the JWT is passed through unchecked.
+// The token's claims and a Walhall client for the request are stored in the request context.
+func (s *server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := s.credentials.TokenFromRequest(r)
+		if errors.Is(err, auth.ErrCrossSiteRequest) {
+			logging.FromContext(r.Context()).Warn("authenticate", "error", err, "origin", r.Header.Get("Origin"))
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `"Cross-site request"`)
+			return
+		} else if err != nil {
+			logging.FromContext(r.Context()).Warn("authenticate", "error", err)
+			unauthorized(w, err, `"Missing or unsupported credentials"`)
+			return
+		}
+		claims, err := s.verify(token)
+		if err != nil {
+			logging.FromContext(r.Context()).Warn("authenticate", "error", err)
+			unauthorized(w, err, `"Invalid JWT"`)
+			return
+		}
+		r.Header.Set("authorization", "JWT "+token)
+		walhall, err := s.newWalhall(r.Context(), r.Header.Get("authorization"))
+		if err != nil {
+			logging.FromContext(r.Context()).Warn("authenticate", "error", err)
+			unauthorized(w, err, `"Unable to parse JWT"`)
+			return
+		}
+		ctx := auth.WithClaims(r.Context(), claims)
+		ctx = context.WithValue(ctx, walhallKey, walhall)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// verify returns the claims of a token, which must pass verification if a verifier is configured.
+func (s *server) verify(token string) (walhallapi.WalhallClaims, error) {
+	if s.verifier != nil {
+		return s.verifier.Verify(token)
+	}
+	return auth.ParseUnverified(token)
+}
+
+// unauthorized writes a 401 response with a challenge for the schemes the adaptor accepts.
+func unauthorized(w http.ResponseWriter, err error, message string) {
+	w.Header().Set("WWW-Authenticate", auth.Challenge(authRealm, err))
+	w.WriteHeader(http.StatusUnauthorized)
+	fmt.Fprint(w, message)
+}
+
+// upstreamFailed responds to a failed call to Walhall Core: with 503 and Retry-After if the circuit breaker rejected
+// the call because Walhall Core is down, otherwise with 500.
+func upstreamFailed(w http.ResponseWriter, err error) {
+	var open *walhallapi.CircuitOpenError
+	if errors.As(err, &open) {
+		w.Header().Set("Retry-After", ceilSeconds(open.RetryAfter))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, `"Walhall is unavailable"`)
+		return
+	}
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+// insufficientScopeError is returned by checkAccess if the token lacks scopes the policy requires.
+type insufficientScopeError struct {
+	required []string
+}
+
+func (e *insufficientScopeError) Error() string {
+	return "insufficient scope, requires " + strings.Join(e.required, " ")
+}
+
+// errForbiddenOrg is returned by checkAccess if the user is not a member of the org.
+var errForbiddenOrg = errors.New("access to org forbidden")
+
+// checkAccess enforces the scopes the policy requires for the named route and, if configured, that org is one of the
+// token's organization_uuids. org is empty for routes outside an org. Both the REST routes and the gRPC methods are
+// authorized by it.
+func (s *server) checkAccess(ctx context.Context, walhall walhallapi.WalhallAPIer, route, org string) error {
+	if s.policy == nil {
+		return nil
+	}
+	if err := s.checkScopes(ctx, route); err != nil {
+		return err
+	}
+
+	if s.policy.OrgMembership && org != "" {
+		orgs, err := walhall.ListOrgs()
+		if err != nil {
+			return err
+		}
+		claims, _ := auth.ClaimsFromContext(ctx)
+		orgUUID, ok := orgs[org]
+		if !ok || !auth.InOrg(claims, orgUUID) {
+			logging.FromContext(ctx).Warn("authorize: not a member of org", "user", claims.Username, "org", org)
+			return errForbiddenOrg
+		}
+	}
+	return nil
+}
+
+// checkScopes enforces the scopes the policy requires for the named route, see checkAccess.
+func (s *server) checkScopes(ctx context.Context, route string) error {
+	if s.policy == nil {
+		return nil
+	}
+	claims, _ := auth.ClaimsFromContext(ctx)
+	required := s.policy.RequiredScopes(route)
+	if missing := auth.MissingScopes(claims, required); len(missing) > 0 {
+		logging.FromContext(ctx).Warn("authorize: missing scopes",
+			"user", claims.Username, "route", route, "missing_scopes", missing)
+		return &insufficientScopeError{required: required}
+	}
+	return nil
+}
+
+// memberOrgs returns the orgs, by name, which the policy allows the token to access: those among its
+// organization_uuids if org membership is enforced, otherwise all of them.
+func (s *server) memberOrgs(ctx context.Context, orgs map[string]string) map[string]string {
+	if s.policy == nil || !s.policy.OrgMembership {
+		return orgs
+	}
+	claims, _ := auth.ClaimsFromContext(ctx)
+	members := make(map[string]string, len(orgs))
+	for name, uuid := range orgs {
+		if auth.InOrg(claims, uuid) {
+			members[name] = uuid
+		}
+	}
+	return members
+}
+
+// authorize returns a middleware which checks access to the matched route, see checkAccess. It must run after
+// authenticate.
+func (s *server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.policy == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var routeName string
+		if route := mux.CurrentRoute(r); route != nil {
+			routeName = route.GetName()
+		}
+		walhall, err := s.walhall(r)
+		if err != nil {
+			unauthorized(w, err, `"Unable to parse JWT"`)
+			return
+		}
+
+		err = s.checkAccess(r.Context(), walhall, routeName, mux.Vars(r)["orgId"])
+		var insufficient *insufficientScopeError
+		switch {
+		case errors.As(err, &insufficient):
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s", error="insufficient_scope", scope="%s"`,
+				authRealm, strings.Join(insufficient.required, " ")))
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `"Insufficient scope"`)
+			return
+		case errors.Is(err, errForbiddenOrg):
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `"Access to org forbidden"`)
+			return
+		case err != nil:
+			logging.FromContext(r.Context()).Error("authorize", "error", err)
+			upstreamFailed(w, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validate returns a middleware which rejects requests whose parameters or body do not match the operation in
+// apiSpec for the matched route, listing every invalid field.
+func (s *server) validate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var op *openapi.Operation
+		if route := mux.CurrentRoute(r); route != nil {
+			if path, err := route.GetPathTemplate(); err == nil {
+				op = apiSpec.Operation(r.Method, path)
+			}
+		}
+		if op == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		err := op.Validate(r, mux.Vars(r))
+		var invalid *openapi.ValidationError
+		if errors.As(err, &invalid) {
+			logging.FromContext(r.Context()).Info("validate", "error", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(struct {
+				Message string               `json:"message"`
+				Errors  []openapi.FieldError `json:"errors"`
+			}{"Invalid request", invalid.Errors})
+			return
+		}
+		if err != nil {
+			logging.FromContext(r.Context()).Warn("validate", "error", err)
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `"Unable to read request"`)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// walhall returns the Walhall client created for the request by authenticate, creating one if necessary.
+func (s *server) walhall(r *http.Request) (walhallapi.WalhallAPIer, error) {
+	if walhall, ok := r.Context().Value(walhallKey).(walhallapi.WalhallAPIer); ok {
+		return walhall, nil
+	}
+	return s.newWalhall(r.Context(), r.Header.Get("authorization"))
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"net/http"
+
+	"humanitec.io/walhallapiadaptor/internal/openapi"
+)
+
+// openAPISpec describes the adaptor's API. It must be updated together with routes - TestOpenAPICoversRoutes
+// fails if a route is missing.
+const openAPISpec = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "Walhall API Adaptor",
+    "description": "Exposes organizations and modules from Walhall Core. v1 is deprecated: its responses carry a Deprecation header and a Link to the same path in v2. Paths without a version prefix are served as v1.",
+    "version": "2.0.0"
+  },
+  "security": [{"jwt": []}, {"apiKey": []}],
+  "paths": {
+    "/v1/orgs": {
+      "get": {
+        "operationId": "listOrgsV1",
+        "deprecated": true,
+        "summary": "List the orgs the user is a member of",
+        "responses": {
+          "200": {
+            "description": "Org names",
+            "content": {"application/json": {"schema": {"type": "array", "items": {"type": "string"}}}}
+          },
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"},
+          "500": {"$ref": "#/components/responses/InternalError"},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      }
+    },
+    "/v1/orgs/{orgId}/modules": {
+      "parameters": [{"$ref": "#/components/parameters/orgId"}],
+      "get": {
+        "operationId": "listModulesV1",
+        "deprecated": true,
+        "summary": "List the modules available in an org",
+        "responses": {
+          "200": {
+            "description": "Modules with their builds",
+            "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/ModuleV1"}}}}
+          },
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"},
+          "500": {"$ref": "#/components/responses/InternalError"},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      }
+    },
+    "/v1/orgs/{orgId}/modules/refresh": {
+      "parameters": [{"$ref": "#/components/parameters/orgId"}],
+      "post": {
+        "operationId": "refreshModulesV1",
+        "deprecated": true,
+        "summary": "Start a sync of the org's modules from GitHub (temporary)",
+        "responses": {
+          "200": {"$ref": "#/components/responses/SyncStatus"},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"},
+          "500": {"$ref": "#/components/responses/InternalError"},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      },
+      "get": {
+        "operationId": "getRefreshModulesStatusV1",
+        "deprecated": true,
+        "summary": "Get the status of the last sync of the org's modules (temporary)",
+        "responses": {
+          "200": {"$ref": "#/components/responses/SyncStatus"},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"},
+          "500": {"$ref": "#/components/responses/InternalError"},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      }
+    },
+    "/v2/orgs": {
+      "get": {
+        "operationId": "listOrgs",
+        "summary": "List the orgs the user is a member of",
+        "responses": {
+          "200": {
+            "description": "Org names",
+            "content": {"application/json": {"schema": {"type": "array", "items": {"type": "string"}}}}
+          },
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"},
+          "500": {"$ref": "#/components/responses/InternalError"},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      }
+    },
+    "/v2/orgs/{orgId}/modules": {
+      "parameters": [{"$ref": "#/components/parameters/orgId"}],
+      "get": {
+        "operationId": "listModules",
+        "summary": "List the modules available in an org",
+        "responses": {
+          "200": {
+            "description": "Modules with their builds",
+            "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Module"}}}}
+          },
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"},
+          "500": {"$ref": "#/components/responses/InternalError"},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      }
+    },
+    "/v2/orgs/{orgId}/modules/refresh": {
+      "parameters": [{"$ref": "#/components/parameters/orgId"}],
+      "post": {
+        "operationId": "refreshModules",
+        "summary": "Start a sync of the org's modules from GitHub (temporary)",
+        "responses": {
+          "200": {"$ref": "#/components/responses/SyncStatus"},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"},
+          "500": {"$ref": "#/components/responses/InternalError"},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      },
+      "get": {
+        "operationId": "getRefreshModulesStatus",
+        "summary": "Get the status of the last sync of the org's modules (temporary)",
+        "responses": {
+          "200": {"$ref": "#/components/responses/SyncStatus"},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"},
+          "500": {"$ref": "#/components/responses/InternalError"},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      }
+    },
+    "/v1/orgs/{orgId}/modules/{moduleId}/builds/latest": {
+      "parameters": [{"$ref": "#/components/parameters/orgId"}, {"$ref": "#/components/parameters/moduleId"}],
+      "get": {
+        "operationId": "getLatestModuleBuildV1",
+        "deprecated": true,
+        "summary": "Get the build of a module's latest version",
+        "description": "The latest version is the newest release, or the newest prerelease if there are no releases. Tags which are not semantic versions are picked according to the module_tag_policy setting.",
+        "responses": {
+          "200": {
+            "description": "The latest build",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ModuleBuild"}}}
+          },
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"},
+          "500": {"$ref": "#/components/responses/InternalError"},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      }
+    },
+    "/v2/orgs/{orgId}/modules/{moduleId}/builds/latest": {
+      "parameters": [{"$ref": "#/components/parameters/orgId"}, {"$ref": "#/components/parameters/moduleId"}],
+      "get": {
+        "operationId": "getLatestModuleBuild",
+        "summary": "Get the build of a module's latest version",
+        "description": "The latest version is the newest release, or the newest prerelease if there are no releases. Tags which are not semantic versions are picked according to the module_tag_policy setting.",
+        "responses": {
+          "200": {
+            "description": "The latest build",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ModuleBuild"}}}
+          },
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"},
+          "500": {"$ref": "#/components/responses/InternalError"},
+          "503": {"$ref": "#/components/responses/Unavailable"}
+        }
+      }
+    },
+    "/v2/graphql": {
+      "post": {
+        "operationId": "graphql",
+        "summary": "Query orgs, apps, envs, module versions and configs with GraphQL",
+        "description": "Nested fields are loaded with one call to Walhall per distinct object, made in parallel for each level of the query. Only queries are supported.",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/GraphQLRequest"}}}
+        },
+        "responses": {
+          "200": {"$ref": "#/components/responses/GraphQLResult"},
+          "400": {"$ref": "#/components/responses/GraphQLResult"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"}
+        }
+      }
+    },
+    "/metrics": {
+      "get": {
+        "operationId": "metrics",
+        "summary": "Metrics in the Prometheus text format",
+        "security": [],
+        "responses": {"200": {"description": "Metrics", "content": {"text/plain": {"schema": {"type": "string"}}}}}
+      }
+    },
+    "/healthz": {
+      "get": {
+        "operationId": "healthz",
+        "summary": "Liveness probe",
+        "security": [],
+        "responses": {"200": {"description": "The process is serving"}}
+      }
+    },
+    "/readyz": {
+      "get": {
+        "operationId": "readyz",
+        "summary": "Readiness probe, checking the configuration and that Walhall Core is reachable",
+        "security": [],
+        "responses": {
+          "200": {"$ref": "#/components/responses/Readiness"},
+          "503": {"$ref": "#/components/responses/Readiness"}
+        }
+      }
+    },
+    "/openapi.json": {
+      "get": {
+        "operationId": "openAPI",
+        "summary": "This document",
+        "security": [],
+        "responses": {"200": {"description": "OpenAPI document", "content": {"application/json": {}}}}
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "jwt": {
+        "type": "apiKey",
+        "in": "header",
+        "name": "Authorization",
+        "description": "A Walhall token as ` + "`JWT <token>` or `Bearer <token>`" + `."
+      },
+      "apiKey": {"type": "apiKey", "in": "header", "name": "X-API-Key"}
+    },
+    "parameters": {
+      "orgId": {"name": "orgId", "in": "path", "required": true, "description": "Name of the org", "schema": {"$ref": "#/components/schemas/Name"}},
+      "moduleId": {"name": "moduleId", "in": "path", "required": true, "description": "Name of the module", "schema": {"$ref": "#/components/schemas/Name"}},
+      "appName": {"name": "appName", "in": "path", "required": true, "description": "Name of the app", "schema": {"$ref": "#/components/schemas/Name"}},
+      "envName": {"name": "envName", "in": "path", "required": true, "description": "Name of the environment", "schema": {"$ref": "#/components/schemas/EnvName"}}
+    },
+    "schemas": {
+      "GraphQLRequest": {
+        "type": "object",
+        "required": ["query"],
+        "properties": {
+          "query": {"type": "string", "minLength": 1},
+          "operationName": {"type": "string", "nullable": true},
+          "variables": {"type": "object", "nullable": true}
+        }
+      },
+      "Module": {
+        "type": "object",
+        "required": ["name", "source", "builds"],
+        "properties": {
+          "name": {"type": "string"},
+          "source": {"type": "string", "example": "Github"},
+          "builds": {"type": "array", "description": "Ordered from newest to oldest version", "items": {"$ref": "#/components/schemas/ModuleBuild"}},
+          "latest": {"allOf": [{"$ref": "#/components/schemas/ModuleBuild"}], "nullable": true, "description": "Build of the latest version, see getLatestModuleBuild"}
+        }
+      },
+      "ModuleV1": {
+        "type": "object",
+        "required": ["id", "source", "builds"],
+        "properties": {
+          "id": {"type": "string", "description": "Name of the module"},
+          "source": {"type": "string", "example": "Github"},
+          "builds": {"type": "array", "description": "Ordered from newest to oldest version", "items": {"$ref": "#/components/schemas/ModuleBuild"}}
+        }
+      },
+      "ModuleBuild": {
+        "type": "object",
+        "required": ["image", "commit", "branch", "tags"],
+        "properties": {
+          "image": {"type": "string", "example": "registry.walhall.io/my-org/module-one:1.0.0"},
+          "commit": {"type": "string"},
+          "branch": {"type": "string"},
+          "tags": {"type": "array", "items": {"type": "string"}}
+        }
+      },
+      "Name": {"type": "string", "pattern": "^[A-Za-z0-9][A-Za-z0-9._-]*$", "maxLength": 64},
+      "EnvName": {"type": "string", "pattern": "^[A-Za-z0-9][A-Za-z0-9 ._-]*$", "maxLength": 64},
+      "Message": {"type": "string"},
+      "ValidationError": {
+        "type": "object",
+        "properties": {
+          "message": {"type": "string"},
+          "errors": {"type": "array", "items": {
+            "type": "object",
+            "properties": {
+              "in": {"type": "string", "enum": ["path", "query", "header", "body"]},
+              "field": {"type": "string", "description": "Parameter name or path within the body, e.g. modules[0].name"},
+              "message": {"type": "string"}
+            }
+          }}
+        }
+      }
+    },
+    "responses": {
+      "GraphQLResult": {
+        "description": "The result of a GraphQL query. Errors resolving some fields are reported alongside the data; a query which cannot be executed has errors only.",
+        "content": {"application/json": {"schema": {"type": "object", "properties": {"data": {"type": "object", "nullable": true}, "errors": {"type": "array", "items": {"type": "object"}}}}}}
+      },
+      "SyncStatus": {
+        "description": "Status of the sync",
+        "content": {"application/json": {"schema": {"type": "string"}}}
+      },
+      "Readiness": {
+        "description": "Result of each readiness check",
+        "content": {"application/json": {"schema": {
+          "type": "object",
+          "properties": {
+            "status": {"type": "string", "enum": ["ok", "fail", "shutting_down"]},
+            "checks": {"type": "object", "additionalProperties": {
+              "type": "object",
+              "properties": {
+                "status": {"type": "string", "enum": ["ok", "fail"]},
+                "error": {"type": "string"},
+                "duration_ms": {"type": "number"}
+              }
+            }},
+            "checked_at": {"type": "string", "format": "date-time"}
+          }
+        }}}
+      },
+      "BadRequest": {
+        "description": "The request does not match this document.",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ValidationError"}}}
+      },
+      "Unauthorized": {
+        "description": "Missing or invalid credentials. See the WWW-Authenticate header.",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Message"}}}
+      },
+      "Forbidden": {
+        "description": "The token lacks a required scope or the user is not a member of the org.",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Message"}}}
+      },
+      "TooManyRequests": {
+        "description": "The user's rate limit for the org is exhausted.",
+        "headers": {"Retry-After": {"schema": {"type": "integer"}, "description": "Seconds until a request is allowed"}},
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Message"}}}
+      },
+      "NotFound": {
+        "description": "The org has no such module, or it has no version which qualifies.",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Message"}}}
+      },
+      "InternalError": {"description": "Walhall Core returned an error."},
+      "Unavailable": {
+        "description": "Walhall Core is down.",
+        "headers": {"Retry-After": {"schema": {"type": "integer"}, "description": "Seconds until Walhall Core is tried again"}},
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Message"}}}
+      }
+    }
+  }
+}
+`
+
+// apiSpec is openAPISpec parsed for validating requests.
+var apiSpec = openapi.MustParse([]byte(openAPISpec))
+
+// openAPI returns a handler which serves the OpenAPI document.
+func (s *server) openAPI() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(openAPISpec))
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"humanitec.io/walhallapiadaptor/internal/auth"
+	"humanitec.io/walhallapiadaptor/internal/logging"
+	"humanitec.io/walhallapiadaptor/internal/metrics"
+)
+
+var rateLimited = metrics.Default.NewCounterVec("walhallapiadaptor_http_rate_limited_total",
+	"Requests rejected with 429 by route template and budget (\"read\" or \"write\").",
+	"route", "budget")
+
+// Rate limit response headers.
+const (
+	rateLimitLimitHeader     = "X-RateLimit-Limit"
+	rateLimitRemainingHeader = "X-RateLimit-Remaining"
+	rateLimitResetHeader     = "X-RateLimit-Reset"
+)
+
+// readOnlyRoutes are routes which use POST without changing anything, so they count against the read budget.
+var readOnlyRoutes = map[string]bool{"graphql": true}
+
+// rateLimit returns a middleware which limits how often each user may call the API for each org, with separate
+// budgets for reads and for mutating requests. It must run after authenticate, which provides the user.
+func (s *server) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		budget, limiter := "read", s.readLimiter
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if current := mux.CurrentRoute(r); current == nil || !readOnlyRoutes[current.GetName()] {
+				budget, limiter = "write", s.writeLimiter
+			}
+		}
+		if limiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client := s.clientAddr(r.RemoteAddr, r.Header.Values("X-Forwarded-For"))
+		result := limiter.Allow(s.rateLimitKey(r.Context(), client, mux.Vars(r)["orgId"]))
+		w.Header().Set(rateLimitLimitHeader, strconv.Itoa(result.Limit))
+		w.Header().Set(rateLimitRemainingHeader, strconv.Itoa(result.Remaining))
+		w.Header().Set(rateLimitResetHeader, ceilSeconds(result.Reset))
+		if !result.Allowed {
+			route := routeLabel(r, "unknown")
+			rateLimited.Inc(route, budget)
+			logging.FromContext(r.Context()).Warn("rate limit exceeded", "budget", budget, "route", route)
+			w.Header().Set("Retry-After", ceilSeconds(result.RetryAfter))
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `"Rate limit exceeded"`)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitKey identifies the user and the org. Without a verifier anyone can mint a token for a new user, so the
+// client address is used instead, as it is for tokens without a user. Behind a proxy which is not trusted, all
+// clients share the proxy's address and so its budget.
+func (s *server) rateLimitKey(ctx context.Context, client, org string) string {
+	var user string
+	if claims, ok := auth.ClaimsFromContext(ctx); ok && s.verifier != nil {
+		user = claims.UserUUID
+		if user == "" {
+			user = claims.Username
+		}
+	}
+	if user == "" {
+		user = client
+	}
+	return user + "\xff" + org
+}
+
+// clientAddr returns the address of the client of a request from its remote address. If that is a trusted proxy,
+// the hops the proxies appended to forwardedFor (the X-Forwarded-For headers) are followed from the right to the first
+// address which is not a trusted proxy. Hops further left were added by the client and cannot be trusted.
+func (s *server) clientAddr(remoteAddr string, forwardedFor []string) string {
+	client, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		client = remoteAddr
+	}
+	var hops []string
+	for _, header := range forwardedFor {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0 && s.trustedProxy(client); i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break
+		}
+		client = hop
+	}
+	return client
+}
+
+func (s *server) trustedProxy(addr string) bool {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return false
+	}
+	for _, proxy := range s.trustedProxies {
+		if proxy.Contains(ip.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -1,15 +1,57 @@
 package main
 
-import "github.com/gorilla/mux"
+import (
+	"github.com/gorilla/mux"
+	"humanitec.io/walhallapiadaptor/internal/metrics"
+)
+
+// apiVersion is a version of the API, served under the path prefix "/v<version>".
+type apiVersion int
+
+const (
+	v1 apiVersion = 1
+	v2 apiVersion = 2
+
+	// latestVersion is the version deprecated versions point clients to.
+	latestVersion = v2
+)
 
 func (s *server) setupRoutes() {
+	s.readiness = s.newReadinessChecker()
+	handler := s.unversioned(s.routes())
+	if s.cors != nil {
+		handler = s.cors(handler)
+	}
+	s.router = s.logRequests(handler)
+}
+
+// routes registers every route. Each one must be described in openAPISpec.
+func (s *server) routes() *mux.Router {
 	r := mux.NewRouter()
-	r.Methods("GET").Path("/orgs").HandlerFunc(s.listOrgs())
-	r.Methods("GET").Path("/orgs/{orgId}/modules").HandlerFunc(s.listModules())
-	r.Methods("POST").Path("/orgs/{orgId}/modules/refresh").HandlerFunc(s.refreshModules())
-	r.Methods("GET").Path("/orgs/{orgId}/modules/refresh").HandlerFunc(s.getRefreshModulesStatus())
-	//r.Methods("GET").Path("/orgs/modules/{moduleName}").HandlerFunc(s.getModule())
-	//r.Methods("GET").Path("/orgs/modules/{moduleName}/build").HandlerFunc(s.listModuleBuilds())
-	//r.Methods("GET").Path("/orgs/modules/{moduleName}/build/").HandlerFunc(s.getModuleBuild())
-	s.router = r
+	// Operational endpoints are not authenticated
+	r.Methods("GET").Path("/metrics").Handler(metrics.Default.Handler()).Name("metrics")
+	r.Methods("GET").Path("/healthz").HandlerFunc(s.healthz()).Name("healthz")
+	r.Methods("GET").Path("/readyz").HandlerFunc(s.readyz()).Name("readyz")
+	r.Methods("GET").Path("/openapi.json").HandlerFunc(s.openAPI()).Name("openAPI")
+
+	// Routes keep their names across versions, so policies and rate limits apply to all versions alike
+	for _, version := range []apiVersion{v1, v2} {
+		api := r.PathPrefix(version.prefix()).Subrouter()
+		if version < latestVersion {
+			api.Use(s.deprecated(version))
+		}
+		api.Use(s.trace, s.instrument, s.authenticate, s.rateLimit, s.validate, s.authorize)
+		api.Methods("GET").Path("/orgs").HandlerFunc(s.listOrgs()).Name("listOrgs")
+		api.Methods("GET").Path("/orgs/{orgId}/modules").HandlerFunc(s.listModules(version)).Name("listModules")
+		api.Methods("POST").Path("/orgs/{orgId}/modules/refresh").HandlerFunc(s.refreshModules()).Name("refreshModules")
+		api.Methods("GET").Path("/orgs/{orgId}/modules/refresh").HandlerFunc(s.getRefreshModulesStatus()).Name("getRefreshModulesStatus")
+		api.Methods("GET").Path("/orgs/{orgId}/modules/{moduleId}/builds/latest").HandlerFunc(s.getLatestModuleBuild()).Name("getLatestModuleBuild")
+		if version >= v2 {
+			api.Methods("POST").Path("/graphql").HandlerFunc(s.graphQL()).Name("graphql")
+		}
+		//api.Methods("GET").Path("/orgs/modules/{moduleName}").HandlerFunc(s.getModule())
+		//api.Methods("GET").Path("/orgs/modules/{moduleName}/build").HandlerFunc(s.listModuleBuilds())
+		//api.Methods("GET").Path("/orgs/modules/{moduleName}/build/").HandlerFunc(s.getModuleBuild())
+	}
+	return r
 }
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"humanitec.io/walhallapiadaptor/internal/logging"
+	"humanitec.io/walhallapiadaptor/internal/tracing"
+)
+
+// shutdownSignals returns a channel which receives SIGINT and SIGTERM.
+func shutdownSignals() <-chan os.Signal {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	return signals
+}
+
+// serve runs srv on the listener, and the gRPC server if there is one, until either fails or a signal is received. On
+// a signal, readiness probes start failing while the listeners keep accepting requests for shutdownDelay, so that load
+// balancers notice and stop routing to the adaptor. Then the listeners are closed and in-flight requests (e.g.
+// deploys) and background work such as trace export are given until shutdownTimeout to finish. A second signal ends
+// the delay early.
+func (s *server) serve(srv *http.Server, listener net.Listener, signals <-chan os.Signal, shutdownDelay, shutdownTimeout time.Duration) error {
+	logger := logging.Default()
+
+	servers := 1
+	serveErr := make(chan error, 2)
+	go func() {
+		serveErr <- srv.Serve(listener)
+	}()
+	if s.grpcServer != nil {
+		servers++
+		go func() {
+			serveErr <- s.grpcServer.Serve(s.grpcListener)
+		}()
+	}
+
+	select {
+	case err := <-serveErr:
+		// Neither API is left serving without the other
+		srv.Close()
+		if s.grpcServer != nil {
+			s.grpcServer.Stop()
+		}
+		return err
+	case sig := <-signals:
+		logger.Info("Shutting down", "signal", sig.String(), "delay_ms", shutdownDelay, "timeout_ms", shutdownTimeout)
+	}
+
+	if s.readiness != nil {
+		s.readiness.MarkShuttingDown()
+	}
+	if shutdownDelay > 0 {
+		delay := time.NewTimer(shutdownDelay)
+		select {
+		case <-delay.C:
+		case <-signals:
+			delay.Stop()
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	var shutdownErr error
+	if err := srv.Shutdown(ctx); err != nil {
+		shutdownErr = fmt.Errorf("draining requests: %w", err)
+	}
+	if s.grpcServer != nil {
+		if err := drainGRPC(ctx, s.grpcServer); err != nil && shutdownErr == nil {
+			shutdownErr = fmt.Errorf("draining gRPC calls: %w", err)
+		}
+	}
+	if err := tracing.Default().Shutdown(ctx); err != nil && shutdownErr == nil {
+		shutdownErr = fmt.Errorf("flushing traces: %w", err)
+	}
+	for i := 0; i < servers; i++ {
+		if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) && shutdownErr == nil {
+			shutdownErr = err
+		}
+	}
+	if shutdownErr == nil {
+		logger.Info("Shutdown complete")
+	}
+	return shutdownErr
+}
+
+// drainGRPC stops srv once its in-flight calls have finished, or cancels them when ctx is done.
+func drainGRPC(ctx context.Context, srv *grpc.Server) error {
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		srv.Stop()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"humanitec.io/walhallapiadaptor/internal/logging"
+	"humanitec.io/walhallapiadaptor/internal/tracing"
+)
+
+// trace returns a middleware which wraps each request in a server span, continuing the trace from an incoming
+// traceparent header if there is one. The trace ID is added to the request's logger.
+func (s *server) trace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeLabel(r, r.URL.Path)
+		ctx := tracing.Extract(r.Context(), r.Header)
+		ctx, span := tracing.Start(ctx, r.Method+" "+route, tracing.KindServer)
+		defer span.End()
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.route", route)
+		span.SetAttribute("http.target", r.URL.RequestURI())
+		ctx = logging.NewContext(ctx, logging.FromContext(ctx).With("trace_id", span.SpanContext().TraceID.String()))
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		span.SetAttribute("http.status_code", recorder.status)
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetStatus(tracing.StatusError, http.StatusText(recorder.status))
+		}
+	})
+}
+
+// traceCall is an interceptor which wraps each gRPC call in a server span like trace, continuing the trace from
+// incoming traceparent metadata.
+func (s *server) traceCall(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	header := make(http.Header)
+	for _, value := range metadata.ValueFromIncomingContext(ctx, tracing.TraceparentHeader) {
+		header.Add(tracing.TraceparentHeader, value)
+	}
+	ctx = tracing.Extract(ctx, header)
+	service, method := splitFullMethod(info.FullMethod)
+	ctx, span := tracing.Start(ctx, service+"/"+method, tracing.KindServer)
+	defer span.End()
+	span.SetAttribute("rpc.system", "grpc")
+	span.SetAttribute("rpc.service", service)
+	span.SetAttribute("rpc.method", method)
+	ctx = logging.NewContext(ctx, logging.FromContext(ctx).With("trace_id", span.SpanContext().TraceID.String()))
+
+	resp, err := handler(ctx, req)
+	code := status.Code(err)
+	span.SetAttribute("rpc.grpc.status_code", int(code))
+	if serverFailure(code) {
+		span.SetStatus(tracing.StatusError, code.String())
+	}
+	return resp, err
+}
+
+// splitFullMethod splits a gRPC method name like "/walhall.v1.Walhall/ListModules" into the service and the method.
+func splitFullMethod(fullMethod string) (service, method string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	if i := strings.LastIndexByte(fullMethod, '/'); i >= 0 {
+		return fullMethod[:i], fullMethod[i+1:]
+	}
+	return "", fullMethod
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// prefix returns the path prefix the version is served under.
+func (v apiVersion) prefix() string {
+	return fmt.Sprintf("/v%d", v)
+}
+
+// unversionedPrefix is the start of the paths which were served before the API was versioned.
+const unversionedPrefix = "/orgs"
+
+// unversionedKey marks the context of a request which was made without a version prefix.
+type unversionedKey struct{}
+
+// unversioned returns a middleware which serves requests to API paths without a version prefix as v1, which is what
+// they were before the API was versioned.
+func (s *server) unversioned(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != unversionedPrefix && !strings.HasPrefix(r.URL.Path, unversionedPrefix+"/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r = r.Clone(context.WithValue(r.Context(), unversionedKey{}, true))
+		r.URL.Path = v1.prefix() + r.URL.Path
+		if r.URL.RawPath != "" {
+			r.URL.RawPath = v1.prefix() + r.URL.RawPath
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// routeLabel returns the template of the route matched by r for metrics and traces, or fallback if there is none.
+// Requests without a version prefix keep the template they had before the API was versioned, e.g. /orgs rather than
+// /v1/orgs, so that existing dashboards and alerts still match them.
+func routeLabel(r *http.Request, fallback string) string {
+	current := mux.CurrentRoute(r)
+	if current == nil {
+		return fallback
+	}
+	template, err := current.GetPathTemplate()
+	if err != nil {
+		return fallback
+	}
+	if unversioned, _ := r.Context().Value(unversionedKey{}).(bool); unversioned {
+		return strings.TrimPrefix(template, v1.prefix())
+	}
+	return template
+}
+
+// deprecated returns a middleware which marks the responses of a deprecated version as such and links to the same
+// path in the latest version.
+func (s *server) deprecated(version apiVersion) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			successor := latestVersion.prefix() + strings.TrimPrefix(r.URL.EscapedPath(), version.prefix())
+			w.Header().Set("Deprecation", "true")
+			w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, successor))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
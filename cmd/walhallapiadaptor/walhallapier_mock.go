@@ -152,6 +152,21 @@ func (mr *MockWalhallAPIerMockRecorder) GetEnv(orgName, appName, envName interfa
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetEnv", reflect.TypeOf((*MockWalhallAPIer)(nil).GetEnv), orgName, appName, envName)
 }
 
+// GetEnvDetail mocks base method
+func (m *MockWalhallAPIer) GetEnvDetail(orgName, appName, envName string) (walhallapi.EnvironmentDetail, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetEnvDetail", orgName, appName, envName)
+	ret0, _ := ret[0].(walhallapi.EnvironmentDetail)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetEnvDetail indicates an expected call of GetEnvDetail
+func (mr *MockWalhallAPIerMockRecorder) GetEnvDetail(orgName, appName, envName interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetEnvDetail", reflect.TypeOf((*MockWalhallAPIer)(nil).GetEnvDetail), orgName, appName, envName)
+}
+
 // PatchEnv mocks base method
 func (m *MockWalhallAPIer) PatchEnv(env walhallapi.Environment, moduleVersions []int) (walhallapi.Environment, error) {
 	m.ctrl.T.Helper()
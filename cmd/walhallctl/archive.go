@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"humanitec.io/walhallapiadaptor/internal/manifest"
+)
+
+// renameFlag collects OLD=NEW pairs.
+type renameFlag map[string]string
+
+func (f renameFlag) String() string {
+	pairs := make([]string, 0, len(f))
+	for _, old := range sortedNames(f) {
+		pairs = append(pairs, old+"="+f[old])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (f renameFlag) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("%q is not OLD=NEW", value)
+	}
+	f[parts[0]] = parts[1]
+	return nil
+}
+
+func (c *cli) export(args []string) error {
+	archive, err := manifest.Export(c.ctx, c.walhall, args[0], args[1], c.options)
+	if err != nil {
+		return err
+	}
+	return archive.Write(c.out)
+}
+
+func (c *cli) importFlags(flags *flag.FlagSet) {
+	c.renames.Envs = make(renameFlag)
+	c.renames.Modules = make(renameFlag)
+	flags.StringVar(&c.renames.Org, "org", "", "organization to import into instead of the exported one")
+	flags.StringVar(&c.renames.App, "app", "", "existing application to import into instead of the exported one")
+	flags.Var(renameFlag(c.renames.Envs), "env", "rename environment OLD to NEW, an existing environment, as OLD=NEW (repeatable)")
+	flags.Var(renameFlag(c.renames.Modules), "module", "rename module OLD to NEW, as OLD=NEW (repeatable)")
+}
+
+func (c *cli) importArchive(args []string) error {
+	file, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	archive, err := manifest.ReadArchive(file)
+	if err != nil {
+		return err
+	}
+	archive = archive.Rename(c.renames)
+
+	plans, err := manifest.Import(c.ctx, c.walhall, archive, c.options)
+	if err != nil {
+		return err
+	}
+	changes := 0
+	for _, plan := range plans {
+		changes += len(plan.Changes)
+	}
+	if c.format == formatJSON {
+		err = c.render(plans, table{})
+	} else {
+		for _, plan := range plans {
+			if err = plan.Write(c.out); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil || changes == 0 {
+		return err
+	}
+	if !c.yes && !c.confirm(fmt.Sprintf("Apply %d changes to %s/%s?", changes, archive.Org, archive.App)) {
+		return errCancelled
+	}
+	for _, plan := range plans {
+		if err := plan.Apply(c.walhall); err != nil {
+			return fmt.Errorf("%s: %w", plan.Env, err)
+		}
+	}
+	fmt.Fprintf(c.errOut, "Applied %d changes\n", changes)
+	return nil
+}
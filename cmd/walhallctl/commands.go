@@ -0,0 +1,278 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"humanitec.io/walhallapiadaptor/internal/walhallapi"
+)
+
+// command is a walhallctl subcommand.
+type command struct {
+	args []string
+	// more describes further arguments, which are allowed if set
+	more    string
+	summary string
+	run     func(c *cli, args []string) error
+	// flags defines the command's own flags, which come before its arguments
+	flags func(c *cli, flags *flag.FlagSet)
+}
+
+var commands = map[string]command{
+	"orgs": {
+		summary: "list organizations",
+		run:     (*cli).orgs,
+	},
+	"apps": {
+		args:    []string{"ORG"},
+		summary: "list applications in an organization",
+		run:     (*cli).apps,
+	},
+	"modules": {
+		args:    []string{"ORG"},
+		summary: "list modules and their builds",
+		run:     (*cli).modules,
+	},
+	"envs": {
+		args:    []string{"ORG", "APP"},
+		summary: "list environments of an application",
+		run:     (*cli).envs,
+	},
+	"configs": {
+		args:    []string{"ORG", "APP", "ENV"},
+		summary: "show the configurations in an environment",
+		run:     (*cli).configs,
+	},
+	"refresh": {
+		args:    []string{"ORG"},
+		summary: "start refreshing the modules of an organization",
+		run:     (*cli).refresh,
+	},
+	"refresh-status": {
+		args:    []string{"ORG"},
+		summary: "show the status of the last module refresh",
+		run:     (*cli).refreshStatus,
+	},
+	"deploy": {
+		args:    []string{"ORG", "APP", "ENV"},
+		more:    "[MODULE@RANGE...]",
+		summary: "deploy an environment, after setting the given module versions",
+		run:     (*cli).deploy,
+	},
+	"set": {
+		args:    []string{"ORG", "APP", "ENV", "MODULE@RANGE"},
+		more:    "[MODULE@RANGE...]",
+		summary: "set module versions in an environment, e.g. auth@^1.2 or auth@latest",
+		run:     (*cli).set,
+	},
+	"plan": {
+		args:    []string{"MANIFEST"},
+		summary: "show the changes which make an environment match a manifest",
+		run:     (*cli).plan,
+	},
+	"apply": {
+		args:    []string{"MANIFEST"},
+		summary: "make an environment match a manifest",
+		run:     (*cli).apply,
+	},
+	"export": {
+		args:    []string{"ORG", "APP"},
+		summary: "write the environments of an application to a JSON archive on stdout",
+		run:     (*cli).export,
+	},
+	"import": {
+		args:    []string{"ARCHIVE"},
+		summary: "make the existing environments of an application match an exported archive",
+		run:     (*cli).importArchive,
+		flags:   (*cli).importFlags,
+	},
+}
+
+// sortedNames returns the keys of a name to UUID map in order.
+func sortedNames(uuids map[string]string) []string {
+	names := make([]string, 0, len(uuids))
+	for name := range uuids {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// namedUUID is the JSON form of an entry of a name to UUID map.
+type namedUUID struct {
+	Name string `json:"name"`
+	UUID string `json:"uuid"`
+}
+
+func (c *cli) printNamed(uuids map[string]string) error {
+	t := table{header: []string{"NAME", "UUID"}}
+	list := []namedUUID{}
+	for _, name := range sortedNames(uuids) {
+		list = append(list, namedUUID{name, uuids[name]})
+		t.add(name, uuids[name])
+	}
+	return c.render(list, t)
+}
+
+func (c *cli) orgs(args []string) error {
+	orgs, err := c.walhall.ListOrgs()
+	if err != nil {
+		return err
+	}
+	return c.printNamed(orgs)
+}
+
+func (c *cli) apps(args []string) error {
+	apps, err := c.walhall.ListApps(args[0])
+	if err != nil {
+		return err
+	}
+	return c.printNamed(apps)
+}
+
+func (c *cli) modules(args []string) error {
+	modules, err := c.walhall.ListModules(args[0])
+	if err != nil {
+		return err
+	}
+	t := table{header: []string{"MODULE", "VERSION", "ID", "IMAGE"}}
+	for _, module := range modules {
+		for _, version := range module.Versions {
+			t.add(module.Name, version.Version, strconv.Itoa(version.ID), module.Image)
+		}
+	}
+	return c.render(modules, t)
+}
+
+func (c *cli) envs(args []string) error {
+	envs, err := c.walhall.ListEnvs(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	t := table{header: []string{"NAME", "UUID", "MODULES"}}
+	for _, env := range envs {
+		t.add(env.Name, env.UUID, strconv.Itoa(len(env.ModuleVersions)))
+	}
+	return c.render(envs, t)
+}
+
+func (c *cli) configs(args []string) error {
+	detail, err := c.walhall.GetEnvDetail(args[0], args[1], args[2])
+	if err != nil {
+		return err
+	}
+	t := table{header: []string{"MODULE", "VERSION", "ID", "NAME", "TYPE", "STATUS"}}
+	for _, mv := range detail.ModuleVersions {
+		for _, config := range detail.Configs[mv.ID] {
+			t.add(mv.Module.Name, mv.Version, strconv.Itoa(config.ID), config.Name, config.Type, config.Status)
+		}
+	}
+	return c.render(detail, t)
+}
+
+// status is the result of commands which only report a status.
+type status struct {
+	Status string `json:"status"`
+}
+
+func (c *cli) printStatus(value string) error {
+	t := table{header: []string{"STATUS"}}
+	t.add(value)
+	return c.render(status{value}, t)
+}
+
+func (c *cli) refresh(args []string) error {
+	value, err := c.walhall.RefreshModules(args[0])
+	if err != nil {
+		return err
+	}
+	return c.printStatus(value)
+}
+
+func (c *cli) refreshStatus(args []string) error {
+	value, err := c.walhall.GetRefreshModulesStatus(args[0])
+	if err != nil {
+		return err
+	}
+	return c.printStatus(value)
+}
+
+func (c *cli) deploy(args []string) error {
+	env, err := c.walhall.GetEnv(args[0], args[1], args[2])
+	if err != nil {
+		return err
+	}
+	resolver := c.resolver(args[0])
+	question := fmt.Sprintf("Deploy %s/%s/%s?", args[0], args[1], args[2])
+	if refs := args[3:]; len(refs) > 0 {
+		versions, err := describeRefs(resolver, refs)
+		if err != nil {
+			return err
+		}
+		question = fmt.Sprintf("Deploy %s/%s/%s with %s?", args[0], args[1], args[2], versions)
+	}
+	if !c.yes && !c.confirm(question) {
+		return errCancelled
+	}
+	if refs := args[3:]; len(refs) > 0 {
+		if env, err = resolver.SetModuleVersions(env, refs); err != nil {
+			return err
+		}
+	}
+	if err := c.walhall.DeployToEnvironment(env); err != nil {
+		return err
+	}
+	return c.printStatus("deployed " + env.Name)
+}
+
+func (c *cli) set(args []string) error {
+	env, err := c.walhall.GetEnv(args[0], args[1], args[2])
+	if err != nil {
+		return err
+	}
+	resolver := c.resolver(args[0])
+	versions, err := describeRefs(resolver, args[3:])
+	if err != nil {
+		return err
+	}
+	if !c.yes && !c.confirm(fmt.Sprintf("Set %s in %s/%s/%s?", versions, args[0], args[1], args[2])) {
+		return errCancelled
+	}
+	env, err = resolver.SetModuleVersions(env, args[3:])
+	if err != nil {
+		return err
+	}
+	t := table{header: []string{"MODULE", "VERSION", "ID"}}
+	for _, mv := range env.ModuleVersions {
+		t.add(mv.Module.Name, mv.Version, strconv.Itoa(mv.ID))
+	}
+	return c.render(env, t)
+}
+
+// describeRefs resolves module references so that the versions they stand for can be confirmed, e.g. as
+// "auth 1.2.0, web 2.0.1". Resolving them again with the same resolver makes no further calls.
+func describeRefs(resolver *walhallapi.Resolver, refs []string) (string, error) {
+	versions := make([]string, len(refs))
+	for i, ref := range refs {
+		name, constraint, err := walhallapi.ParseModuleRef(ref)
+		if err != nil {
+			return "", err
+		}
+		mv, err := resolver.Resolve(name, constraint)
+		if err != nil {
+			return "", err
+		}
+		versions[i] = name + " " + mv.Version
+	}
+	return strings.Join(versions, ", "), nil
+}
+
+// resolver returns a Resolver for the modules of org with the configured tag policy.
+func (c *cli) resolver(org string) *walhallapi.Resolver {
+	resolver := walhallapi.NewResolver(c.walhall, org)
+	resolver.SetTagPolicy(c.options.TagPolicy)
+	return resolver
+}
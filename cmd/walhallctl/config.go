@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+	"humanitec.io/walhallapiadaptor/internal/walhallapi"
+)
+
+// ctlConfig holds what walhallctl needs to talk to Walhall.
+type ctlConfig struct {
+	APIPrefix string `yaml:"api_prefix"`
+	JWT       string `yaml:"jwt"`
+	// Parallelism is how many calls to Walhall are made at once when many are needed, e.g. for plans and exports.
+	// Zero means walhallapi.DefaultParallelism.
+	Parallelism int `yaml:"parallelism"`
+	// TagPolicy orders module versions whose tags are not semantic versions, like MODULE_TAG_POLICY for the adaptor:
+	// oldest, newest or exclude. Empty means oldest.
+	TagPolicy string `yaml:"tag_policy"`
+}
+
+// defaultConfigPath is the config file used when neither -config nor WALHALLCTL_CONFIG is set.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "walhallctl", "config.yaml")
+}
+
+// loadConfig reads the config file at path and applies WALHALL_API_PREFIX and WALHALL_JWT on top of it. If
+// required is false, a missing file is not an error.
+func loadConfig(path string, required bool, lookupEnv func(string) (string, bool)) (ctlConfig, error) {
+	var cfg ctlConfig
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		switch {
+		case err == nil:
+			if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+				return ctlConfig{}, fmt.Errorf("parse config %s: %w", path, err)
+			}
+		case required || !errors.Is(err, os.ErrNotExist):
+			return ctlConfig{}, fmt.Errorf("read config: %w", err)
+		}
+	}
+	if value, ok := lookupEnv("WALHALL_API_PREFIX"); ok && value != "" {
+		cfg.APIPrefix = value
+	}
+	if value, ok := lookupEnv("WALHALL_JWT"); ok && value != "" {
+		cfg.JWT = value
+	}
+	return cfg, nil
+}
+
+// validate checks that a Walhall client can be created from cfg.
+func (cfg ctlConfig) validate() error {
+	if cfg.APIPrefix == "" {
+		return errors.New("no Walhall API prefix: set api_prefix, WALHALL_API_PREFIX or -api-prefix")
+	}
+	if cfg.JWT == "" {
+		return errors.New("no JWT: set jwt or WALHALL_JWT")
+	}
+	if cfg.Parallelism < 0 {
+		return fmt.Errorf("parallelism must not be negative, got %d", cfg.Parallelism)
+	}
+	if _, err := walhallapi.ParseTagPolicy(cfg.TagPolicy); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+// Command walhallctl lists and changes Walhall organizations, modules, applications and environments from the
+// command line.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+
+	"humanitec.io/walhallapiadaptor/internal/manifest"
+	"humanitec.io/walhallapiadaptor/internal/walhallapi"
+)
+
+// cli is the state shared by all subcommands.
+type cli struct {
+	// ctx is cancelled on interrupt
+	ctx     context.Context
+	walhall walhallapi.WalhallAPIer
+	in      io.Reader
+	out     io.Writer
+	errOut  io.Writer
+	format  string
+	// yes skips confirmations
+	yes bool
+	// renames are applied to imported archives
+	renames manifest.Renames
+	// options are passed to plans, exports and imports, and their tag policy resolves module references
+	options manifest.Options
+}
+
+func (c *cli) render(v interface{}, t table) error {
+	return render(c.out, c.format, v, t)
+}
+
+// errUsage is returned for invalid command lines, after the usage has been printed.
+var errUsage = errors.New("invalid usage")
+
+// newWalhall creates the client used by the subcommands.
+type newWalhall func(ctx context.Context, cfg ctlConfig, timeout time.Duration) (walhallapi.WalhallAPIer, error)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	err := run(ctx, os.Args[1:], os.LookupEnv, os.Stdin, os.Stdout, os.Stderr, connect)
+	stop()
+	if errors.Is(err, errUsage) {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "walhallctl:", err)
+		os.Exit(1)
+	}
+}
+
+// connect creates a Walhall client which retries failed reads and whose calls stop once ctx is done.
+func connect(ctx context.Context, cfg ctlConfig, timeout time.Duration) (walhallapi.WalhallAPIer, error) {
+	doer := walhallapi.NewRetryingDoer(&http.Client{Timeout: timeout}, walhallapi.DefaultRetryPolicy)
+	walhall, err := walhallapi.NewWithContext(ctx, cfg.APIPrefix, cfg.JWT, doer)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Parallelism > 0 {
+		walhall.SetParallelism(cfg.Parallelism)
+	}
+	return walhall, nil
+}
+
+// run executes the command line args (excluding the program name).
+func run(ctx context.Context, args []string, lookupEnv func(string) (string, bool), stdin io.Reader,
+	stdout, stderr io.Writer, connect newWalhall) error {
+	flags := flag.NewFlagSet("walhallctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configFile := flags.String("config", "", "path to a YAML config file with api_prefix and jwt")
+	apiPrefix := flags.String("api-prefix", "", "base URL of the Walhall Core API")
+	format := flags.String("o", formatTable, "output format, table or json")
+	timeout := flags.Duration("timeout", 30*time.Second, "timeout of each call to Walhall")
+	parallelism := flags.Int("parallelism", 0,
+		"how many calls to Walhall to make at once when fetching many configurations (default parallelism or 4)")
+	tagPolicy := flags.String("tag-policy", "",
+		"where tags which are not semantic versions go: oldest, newest or exclude (default tag_policy or oldest)")
+	yes := flags.Bool("yes", false, "apply changes without asking for confirmation")
+	flags.Usage = func() { usage(flags) }
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return errUsage
+	}
+	if *format != formatTable && *format != formatJSON {
+		fmt.Fprintf(stderr, "unknown output format %q\n", *format)
+		return errUsage
+	}
+
+	if flags.NArg() == 0 {
+		usage(flags)
+		return errUsage
+	}
+	name, cmdArgs := flags.Arg(0), flags.Args()[1:]
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n", name)
+		usage(flags)
+		return errUsage
+	}
+	c := &cli{ctx: ctx, in: stdin, out: stdout, errOut: stderr, format: *format, yes: *yes}
+	if cmd.flags != nil {
+		cmdFlags := flag.NewFlagSet(name, flag.ContinueOnError)
+		cmdFlags.SetOutput(stderr)
+		cmd.flags(c, cmdFlags)
+		cmdFlags.Usage = func() {
+			fmt.Fprintf(stderr, "usage: walhallctl [flags] %s [%s flags] %s\n", name, name, strings.Join(cmd.args, " "))
+			cmdFlags.PrintDefaults()
+		}
+		if err := cmdFlags.Parse(cmdArgs); err != nil {
+			if err == flag.ErrHelp {
+				return nil
+			}
+			return errUsage
+		}
+		cmdArgs = cmdFlags.Args()
+	}
+	if len(cmdArgs) < len(cmd.args) || (len(cmdArgs) > len(cmd.args) && cmd.more == "") {
+		fmt.Fprintf(stderr, "usage: walhallctl [flags] %s\n", strings.Join(cmd.usage(name), " "))
+		return errUsage
+	}
+
+	path, required := *configFile, true
+	if path == "" {
+		path, required = lookupEnv("WALHALLCTL_CONFIG")
+	}
+	if path == "" {
+		path, required = defaultConfigPath(), false
+	}
+	cfg, err := loadConfig(path, required, lookupEnv)
+	if err != nil {
+		return err
+	}
+	if *apiPrefix != "" {
+		cfg.APIPrefix = *apiPrefix
+	}
+	if *parallelism != 0 {
+		cfg.Parallelism = *parallelism
+	}
+	if *tagPolicy != "" {
+		cfg.TagPolicy = *tagPolicy
+	}
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	policy, _ := walhallapi.ParseTagPolicy(cfg.TagPolicy)
+	c.options = manifest.Options{Parallelism: cfg.Parallelism, TagPolicy: policy}
+	if c.walhall, err = connect(ctx, cfg, *timeout); err != nil {
+		return err
+	}
+	return cmd.run(c, cmdArgs)
+}
+
+// usage returns the words of the command's synopsis.
+func (cmd command) usage(name string) []string {
+	words := []string{name}
+	if cmd.flags != nil {
+		words = append(words, "["+name+" flags]")
+	}
+	words = append(words, cmd.args...)
+	if cmd.more != "" {
+		words = append(words, cmd.more)
+	}
+	return words
+}
+
+func usage(flags *flag.FlagSet) {
+	w := flags.Output()
+	fmt.Fprintln(w, "usage: walhallctl [flags] COMMAND [ARGS]")
+	fmt.Fprintln(w, "\ncommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	tw := newTabWriter(w)
+	for _, name := range names {
+		cmd := commands[name]
+		fmt.Fprintf(tw, "  %s\t%s\n", strings.Join(cmd.usage(name), " "), cmd.summary)
+	}
+	tw.Flush()
+	fmt.Fprintln(w, "\nflags:")
+	flags.PrintDefaults()
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"humanitec.io/walhallapiadaptor/internal/walhallapi"
+)
+
+// fakeWalhall implements the calls the tests make. Others panic.
+type fakeWalhall struct {
+	walhallapi.WalhallAPIer
+	orgs     map[string]string
+	modules  []walhallapi.Module
+	env      walhallapi.Environment
+	deployed []string
+	patched  [][]int
+}
+
+func (f *fakeWalhall) ListOrgs() (map[string]string, error) {
+	return f.orgs, nil
+}
+
+func (f *fakeWalhall) ListModules(orgName string) ([]walhallapi.Module, error) {
+	if orgName != "my-org" {
+		return nil, walhallapi.ErrNotFound
+	}
+	return f.modules, nil
+}
+
+func (f *fakeWalhall) GetEnv(orgName, appName, envName string) (walhallapi.Environment, error) {
+	return f.env, nil
+}
+
+func (f *fakeWalhall) DeployToEnvironment(env walhallapi.Environment) error {
+	f.deployed = append(f.deployed, env.UUID)
+	return nil
+}
+
+func (f *fakeWalhall) GetConfigsForModuleVersionInEnv(env walhallapi.Environment, mv walhallapi.ModuleVersion) ([]walhallapi.Config, error) {
+	return nil, nil
+}
+
+func (f *fakeWalhall) PatchEnv(env walhallapi.Environment, moduleVersions []int) (walhallapi.Environment, error) {
+	f.patched = append(f.patched, moduleVersions)
+	return env, nil
+}
+
+func (f *fakeWalhall) ListEnvs(orgName, appName string) ([]walhallapi.Environment, error) {
+	return []walhallapi.Environment{f.env}, nil
+}
+
+func noEnv(string) (string, bool) { return "", false }
+
+func envOf(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+}
+
+// runCLI runs walhallctl against walhall with a configured API prefix and JWT.
+func runCLI(t *testing.T, walhall walhallapi.WalhallAPIer, args ...string) (string, string, error) {
+	return runCLIWithInput(t, walhall, "", args...)
+}
+
+// runCLIWithInput is like runCLI with stdin reading from stdin.
+func runCLIWithInput(t *testing.T, walhall walhallapi.WalhallAPIer, stdin string, args ...string) (string, string, error) {
+	var stdout, stderr bytes.Buffer
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(configFile, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	lookupEnv := envOf(map[string]string{
+		"WALHALLCTL_CONFIG":  configFile,
+		"WALHALL_API_PREFIX": "https://walhall.example.com/api",
+		"WALHALL_JWT":        "token",
+	})
+	connect := func(ctx context.Context, cfg ctlConfig, timeout time.Duration) (walhallapi.WalhallAPIer, error) {
+		return walhall, nil
+	}
+	err := run(context.Background(), args, lookupEnv, strings.NewReader(stdin), &stdout, &stderr, connect)
+	return stdout.String(), stderr.String(), err
+}
+
+func TestOrgsTable(t *testing.T) {
+	is := is.New(t)
+	walhall := &fakeWalhall{orgs: map[string]string{"b-org": "uuid-b", "a-org": "uuid-a"}}
+
+	stdout, _, err := runCLI(t, walhall, "orgs")
+	is.NoErr(err)
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	is.Equal(len(lines), 3)
+	is.Equal(strings.Fields(lines[0]), []string{"NAME", "UUID"})
+	is.Equal(strings.Fields(lines[1]), []string{"a-org", "uuid-a"})
+	is.Equal(strings.Fields(lines[2]), []string{"b-org", "uuid-b"})
+}
+
+func TestModulesJSON(t *testing.T) {
+	is := is.New(t)
+	walhall := &fakeWalhall{modules: []walhallapi.Module{{
+		Name:     "auth",
+		Versions: []walhallapi.ModuleVersion{{ID: 3, Version: "1.0.0"}},
+	}}}
+
+	stdout, _, err := runCLI(t, walhall, "-o", "json", "modules", "my-org")
+	is.NoErr(err)
+	var modules []walhallapi.Module
+	is.NoErr(json.Unmarshal([]byte(stdout), &modules))
+	is.Equal(modules, walhall.modules)
+}
+
+func TestDeploy(t *testing.T) {
+	is := is.New(t)
+	walhall := &fakeWalhall{env: walhallapi.Environment{UUID: "env-uuid", Name: "dev"}}
+
+	stdout, _, err := runCLI(t, walhall, "-yes", "deploy", "my-org", "my-app", "dev")
+	is.NoErr(err)
+	is.Equal(walhall.deployed, []string{"env-uuid"})
+	is.True(strings.Contains(stdout, "deployed dev"))
+}
+
+func TestDeployAndSetConfirm(t *testing.T) {
+	is := is.New(t)
+	walhall := &fakeWalhall{
+		env: walhallapi.Environment{UUID: "env-uuid", Name: "dev"},
+		modules: []walhallapi.Module{{Name: "auth", Versions: []walhallapi.ModuleVersion{
+			{ID: 4, Version: "1.2.0"}, {ID: 3, Version: "1.0.0"},
+		}}},
+	}
+
+	// Nothing is changed unless the resolved versions are confirmed
+	_, stderr, err := runCLIWithInput(t, walhall, "n\n", "set", "my-org", "my-app", "dev", "auth@^1.0")
+	is.Equal(err, errCancelled)
+	is.True(strings.Contains(stderr, "Set auth 1.2.0 in my-org/my-app/dev? [y/N]"))
+	_, stderr, err = runCLI(t, walhall, "deploy", "my-org", "my-app", "dev", "auth@1.0.0")
+	is.Equal(err, errCancelled)
+	is.True(strings.Contains(stderr, "Deploy my-org/my-app/dev with auth 1.0.0? [y/N]"))
+	_, stderr, err = runCLIWithInput(t, walhall, "no\n", "deploy", "my-org", "my-app", "dev")
+	is.Equal(err, errCancelled)
+	is.True(strings.Contains(stderr, "Deploy my-org/my-app/dev? [y/N]"))
+	is.Equal(len(walhall.patched), 0)
+	is.Equal(len(walhall.deployed), 0)
+
+	_, _, err = runCLIWithInput(t, walhall, "y\n", "set", "my-org", "my-app", "dev", "auth@^1.0")
+	is.NoErr(err)
+	_, _, err = runCLIWithInput(t, walhall, "yes\n", "deploy", "my-org", "my-app", "dev", "auth@1.0.0")
+	is.NoErr(err)
+	is.Equal(walhall.patched, [][]int{{4}, {3}})
+	is.Equal(walhall.deployed, []string{"env-uuid"})
+}
+
+func TestSetAndDeploy(t *testing.T) {
+	is := is.New(t)
+	walhall := &fakeWalhall{
+		env: walhallapi.Environment{UUID: "env-uuid", Name: "dev"},
+		modules: []walhallapi.Module{{Name: "auth", Versions: []walhallapi.ModuleVersion{
+			{ID: 5, Version: "NEW_TAG"}, {ID: 4, Version: "1.2.0"}, {ID: 3, Version: "1.0.0"},
+		}}},
+	}
+
+	_, _, err := runCLI(t, walhall, "-yes", "set", "my-org", "my-app", "dev", "auth@^1.0")
+	is.NoErr(err)
+	_, _, err = runCLI(t, walhall, "-yes", "deploy", "my-org", "my-app", "dev", "auth@NEW_TAG")
+	is.NoErr(err)
+	is.Equal(walhall.patched, [][]int{{4}, {5}})
+	is.Equal(walhall.deployed, []string{"env-uuid"})
+
+	// latest is resolved with the tag policy
+	walhall.patched = nil
+	_, _, err = runCLI(t, walhall, "-yes", "set", "my-org", "my-app", "dev", "auth@latest")
+	is.NoErr(err)
+	_, _, err = runCLI(t, walhall, "-yes", "-tag-policy", "newest", "set", "my-org", "my-app", "dev", "auth@latest")
+	is.NoErr(err)
+	is.Equal(walhall.patched, [][]int{{4}, {5}})
+	_, _, err = runCLI(t, walhall, "-yes", "-tag-policy", "first", "set", "my-org", "my-app", "dev", "auth@latest")
+	is.True(err != nil)
+
+	_, _, err = runCLI(t, walhall, "-yes", "set", "my-org", "my-app", "dev", "auth@^2")
+	is.True(errors.Is(err, walhallapi.ErrNotFound))
+	_, _, err = runCLI(t, walhall, "-yes", "set", "my-org", "my-app", "dev")
+	is.Equal(err, errUsage)
+}
+
+func TestPlanAndApply(t *testing.T) {
+	is := is.New(t)
+	walhall := &fakeWalhall{
+		env:     walhallapi.Environment{UUID: "env-uuid", Name: "dev"},
+		modules: []walhallapi.Module{{Name: "auth", Versions: []walhallapi.ModuleVersion{{ID: 3, Version: "1.0.0"}}}},
+	}
+	path := filepath.Join(t.TempDir(), "dev.yaml")
+	manifest := "org: my-org\napp: my-app\nenv: dev\nmodules:\n  - name: auth\n    version: 1.0.0\n"
+	is.NoErr(ioutil.WriteFile(path, []byte(manifest), 0600))
+
+	stdout, _, err := runCLI(t, walhall, "plan", path)
+	is.NoErr(err)
+	is.Equal(stdout, "my-org/my-app/dev:\n  + module auth 1.0.0\n")
+	is.Equal(len(walhall.patched), 0)
+
+	_, stderr, err := runCLIWithInput(t, walhall, "n\n", "apply", path)
+	is.Equal(err, errCancelled)
+	is.True(strings.Contains(stderr, "Apply 1 changes to my-org/my-app/dev? [y/N]"))
+	is.Equal(len(walhall.patched), 0)
+
+	_, _, err = runCLIWithInput(t, walhall, "y\n", "apply", path)
+	is.NoErr(err)
+	is.Equal(walhall.patched, [][]int{{3}})
+
+	_, _, err = runCLI(t, walhall, "-yes", "apply", path)
+	is.NoErr(err)
+	is.Equal(len(walhall.patched), 2)
+}
+
+func TestExportAndImport(t *testing.T) {
+	is := is.New(t)
+	walhall := &fakeWalhall{
+		env:     walhallapi.Environment{UUID: "env-uuid", Name: "dev"},
+		modules: []walhallapi.Module{{Name: "auth", Versions: []walhallapi.ModuleVersion{{ID: 3, Version: "1.0.0"}}}},
+	}
+
+	stdout, _, err := runCLI(t, walhall, "export", "my-org", "my-app")
+	is.NoErr(err)
+	is.True(strings.Contains(stdout, `"env": "dev"`))
+
+	path := filepath.Join(t.TempDir(), "my-app.json")
+	archive := `{"version": 1, "org": "old-org", "app": "old-app", "environments": [
+		{"org": "old-org", "app": "old-app", "env": "qa", "modules": [{"name": "authentication", "version": "1.0.0"}]}
+	]}`
+	is.NoErr(ioutil.WriteFile(path, []byte(archive), 0600))
+	stdout, _, err = runCLI(t, walhall, "-yes", "import",
+		"-org", "my-org", "-app", "my-app", "-env", "qa=dev", "-module", "authentication=auth", path)
+	is.NoErr(err)
+	is.Equal(stdout, "my-org/my-app/dev:\n  + module auth 1.0.0\n")
+	is.Equal(walhall.patched, [][]int{{3}})
+
+	_, _, err = runCLI(t, walhall, "import", "-env", "qa", path)
+	is.Equal(err, errUsage)
+}
+
+func TestCommandErrors(t *testing.T) {
+	is := is.New(t)
+	walhall := &fakeWalhall{}
+
+	_, stderr, err := runCLI(t, walhall, "unknown")
+	is.Equal(err, errUsage)
+	is.True(strings.Contains(stderr, `unknown command "unknown"`))
+
+	_, stderr, err = runCLI(t, walhall, "envs", "my-org")
+	is.Equal(err, errUsage)
+	is.True(strings.Contains(stderr, "envs ORG APP"))
+
+	_, _, err = runCLI(t, walhall, "-o", "yaml", "orgs")
+	is.Equal(err, errUsage)
+
+	_, _, err = runCLI(t, walhall, "modules", "other-org")
+	is.Equal(err, walhallapi.ErrNotFound)
+
+	_, stderr, err = runCLI(t, walhall, "-parallelism", "-1", "orgs")
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "parallelism"))
+}
+
+func TestLoadConfig(t *testing.T) {
+	is := is.New(t)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	is.NoErr(ioutil.WriteFile(path, []byte("api_prefix: https://file.example.com\njwt: file-token\n"), 0600))
+
+	cfg, err := loadConfig(path, true, noEnv)
+	is.NoErr(err)
+	is.Equal(cfg, ctlConfig{APIPrefix: "https://file.example.com", JWT: "file-token"})
+
+	// The environment overrides the file
+	cfg, err = loadConfig(path, true, envOf(map[string]string{"WALHALL_JWT": "env-token"}))
+	is.NoErr(err)
+	is.Equal(cfg.JWT, "env-token")
+
+	missing := filepath.Join(t.TempDir(), "missing.yaml")
+	_, err = loadConfig(missing, true, noEnv)
+	is.True(err != nil)
+	cfg, err = loadConfig(missing, false, noEnv)
+	is.NoErr(err)
+	is.True(cfg.validate() != nil)
+
+	is.NoErr(ioutil.WriteFile(path, []byte("api_prefix: https://file.example.com\njwt: file-token\nparallelism: 8\n"), 0600))
+	cfg, err = loadConfig(path, true, noEnv)
+	is.NoErr(err)
+	is.Equal(cfg.Parallelism, 8)
+	cfg.Parallelism = -1
+	is.True(cfg.validate() != nil)
+
+	is.NoErr(ioutil.WriteFile(path, []byte("token: typo\n"), 0600))
+	_, err = loadConfig(path, true, noEnv)
+	is.True(err != nil)
+}
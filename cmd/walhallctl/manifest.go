@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strings"
+
+	"humanitec.io/walhallapiadaptor/internal/manifest"
+)
+
+// errCancelled is returned if the user does not confirm a change.
+var errCancelled = errors.New("cancelled")
+
+func (c *cli) loadPlan(path string) (*manifest.Plan, error) {
+	m, err := manifest.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return manifest.NewPlan(c.ctx, c.walhall, m, c.options)
+}
+
+func (c *cli) printPlan(plan *manifest.Plan) error {
+	if c.format == formatJSON {
+		return c.render(plan, table{})
+	}
+	return plan.Write(c.out)
+}
+
+func (c *cli) plan(args []string) error {
+	plan, err := c.loadPlan(args[0])
+	if err != nil {
+		return err
+	}
+	return c.printPlan(plan)
+}
+
+func (c *cli) apply(args []string) error {
+	plan, err := c.loadPlan(args[0])
+	if err != nil {
+		return err
+	}
+	if err := c.printPlan(plan); err != nil {
+		return err
+	}
+	if plan.Empty() {
+		return nil
+	}
+	if !c.yes && !c.confirm(fmt.Sprintf("Apply %d changes to %s/%s/%s?", len(plan.Changes), plan.Org, plan.App, plan.Env)) {
+		return errCancelled
+	}
+	if err := plan.Apply(c.walhall); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.errOut, "Applied %d changes\n", len(plan.Changes))
+	return nil
+}
+
+// confirm asks a yes or no question on stderr and reads the answer from stdin.
+func (c *cli) confirm(question string) bool {
+	fmt.Fprintf(c.errOut, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(c.in).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"humanitec.io/walhallapiadaptor/internal/walhallapi"
+)
+
+type contextKey int
+
+const claimsKey contextKey = iota
+
+// WithClaims returns a copy of ctx carrying the claims of the authenticated token.
+func WithClaims(ctx context.Context, claims walhallapi.WalhallClaims) context.Context {
+	return context.WithValue(ctx, claimsKey, claims)
+}
+
+// ClaimsFromContext returns the claims stored by WithClaims.
+func ClaimsFromContext(ctx context.Context) (walhallapi.WalhallClaims, bool) {
+	claims, ok := ctx.Value(claimsKey).(walhallapi.WalhallClaims)
+	return claims, ok
+}
+
+// ParseUnverified extracts the claims from a token without checking its signature. It must only be used when the
+// token is verified elsewhere (e.g. by Walhall itself).
+func ParseUnverified(token string) (walhallapi.WalhallClaims, error) {
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	var claims walhallapi.WalhallClaims
+	if _, _, err := parser.ParseUnverified(stripScheme(token), &claims); err != nil {
+		return walhallapi.WalhallClaims{}, fmt.Errorf("parse claims: %w", err)
+	}
+	return claims, nil
+}
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrNoCredentials is returned by TokenFromRequest when the request carries no recognisable credentials.
+var ErrNoCredentials = errors.New("no credentials supplied")
+
+// ErrCrossSiteRequest is returned by TokenFromRequest for a request authenticated with a cookie which changes
+// something and may have been sent by a page on another site (cross-site request forgery).
+var ErrCrossSiteRequest = errors.New("cookie credentials on a possibly cross-site request")
+
+// RequestedWithHeader is a header requests authenticated with a cookie may carry to show they were sent by a script.
+// Pages on other sites cannot send it without a CORS preflight.
+const RequestedWithHeader = "X-Requested-With"
+
+// APIKeyHeader is the header service accounts may use to present an API key.
+const APIKeyHeader = "X-API-Key"
+
+// Credentials extracts a JWT from the different ways clients can authenticate:
+//   - "Authorization: JWT <token>" (the Walhall scheme)
+//   - "Authorization: Bearer <token>"
+//   - "Authorization: ApiKey <key>" or "X-API-Key: <key>", mapped to a service account JWT
+//   - a cookie holding the token, if CookieName is set
+//
+// A bare token in the Authorization header is also accepted for backwards compatibility.
+// The zero value only accepts the Authorization header schemes.
+//
+// Browsers send cookies with requests from any site, so a cookie only authenticates requests with unsafe methods (any
+// but GET, HEAD and OPTIONS) if they carry an Origin header of the request's own host or of AllowedOrigins, or the
+// RequestedWithHeader.
+type Credentials struct {
+	CookieName string
+	// AllowedOrigins are the origins of other sites, such as "https://console.walhall.io", which may send requests
+	// authenticated with a cookie.
+	AllowedOrigins []string
+	// apiKeys maps the SHA-256 of an API key to the JWT it stands for, so that the keys themselves are not kept in
+	// memory.
+	apiKeys map[[sha256.Size]byte]string
+}
+
+// SetAPIKeys replaces the API key to JWT mapping.
+func (c *Credentials) SetAPIKeys(keys map[string]string) {
+	c.apiKeys = make(map[[sha256.Size]byte]string, len(keys))
+	for key, token := range keys {
+		c.apiKeys[sha256.Sum256([]byte(key))] = stripScheme(token)
+	}
+}
+
+// LoadAPIKeys reads a JSON object mapping API keys to the service account JWTs they stand for.
+func LoadAPIKeys(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read api keys: %w", err)
+	}
+	var keys map[string]string
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("parse api keys %s: %w", path, err)
+	}
+	return keys, nil
+}
+
+// TokenFromRequest returns the raw JWT (without any scheme) the request authenticates with.
+func (c *Credentials) TokenFromRequest(r *http.Request) (string, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, value := splitAuthorization(header)
+		switch strings.ToLower(scheme) {
+		case "jwt", "bearer":
+			if value == "" {
+				return "", ErrNoCredentials
+			}
+			return value, nil
+		case "apikey":
+			return c.tokenForAPIKey(value)
+		default:
+			if value == "" && strings.Count(scheme, ".") == 2 {
+				// A bare token without any scheme
+				return scheme, nil
+			}
+			return "", fmt.Errorf("unsupported authorization scheme %q", scheme)
+		}
+	}
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return c.tokenForAPIKey(key)
+	}
+	if c.CookieName != "" {
+		if cookie, err := r.Cookie(c.CookieName); err == nil && cookie.Value != "" {
+			if !c.sameSite(r) {
+				return "", ErrCrossSiteRequest
+			}
+			return stripScheme(cookie.Value), nil
+		}
+	}
+	return "", ErrNoCredentials
+}
+
+// sameSite reports whether a request may be authenticated with a cookie.
+func (c *Credentials) sameSite(r *http.Request) bool {
+	switch r.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	if r.Header.Get(RequestedWithHeader) != "" {
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	for _, allowed := range c.AllowedOrigins {
+		// "*" would allow any site, so it is not honored here
+		if origin == allowed {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && u.Host == r.Host
+}
+
+func (c *Credentials) tokenForAPIKey(key string) (string, error) {
+	token, ok := c.apiKeys[sha256.Sum256([]byte(key))]
+	if !ok {
+		return "", errors.New("unknown api key")
+	}
+	return token, nil
+}
+
+func splitAuthorization(header string) (scheme, value string) {
+	header = strings.TrimSpace(header)
+	i := strings.IndexByte(header, ' ')
+	if i < 0 {
+		return header, ""
+	}
+	return header[:i], strings.TrimSpace(header[i+1:])
+}
+
+// Challenge is the WWW-Authenticate header value listing the schemes the adaptor accepts.
+func Challenge(realm string, err error) string {
+	params := fmt.Sprintf(`realm="%s"`, realm)
+	if err != nil && !errors.Is(err, ErrNoCredentials) {
+		params += `, error="invalid_token"`
+	}
+	return fmt.Sprintf("Bearer %s, JWT %s", params, params)
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+const testToken = "eyJhbGciOiJIUzI1NiJ9.e30.c2lnbmF0dXJl"
+
+func TestTokenFromRequest(t *testing.T) {
+	is := is.New(t)
+	c := Credentials{CookieName: "walhall_jwt"}
+	c.SetAPIKeys(map[string]string{"service-account-key": "JWT " + testToken})
+
+	for _, header := range []string{"JWT " + testToken, "Bearer " + testToken, "bearer " + testToken, testToken, "ApiKey service-account-key"} {
+		req := httptest.NewRequest(http.MethodGet, "/orgs", nil)
+		req.Header.Set("Authorization", header)
+		token, err := c.TokenFromRequest(req)
+		is.NoErr(err)
+		is.Equal(token, testToken)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/orgs", nil)
+	req.Header.Set(APIKeyHeader, "service-account-key")
+	token, err := c.TokenFromRequest(req)
+	is.NoErr(err)
+	is.Equal(token, testToken)
+
+	req = httptest.NewRequest(http.MethodGet, "/orgs", nil)
+	req.AddCookie(&http.Cookie{Name: "walhall_jwt", Value: testToken})
+	token, err = c.TokenFromRequest(req)
+	is.NoErr(err)
+	is.Equal(token, testToken)
+}
+
+func TestTokenFromRequestFailures(t *testing.T) {
+	is := is.New(t)
+	var c Credentials
+
+	req := httptest.NewRequest(http.MethodGet, "/orgs", nil)
+	_, err := c.TokenFromRequest(req)
+	is.True(errors.Is(err, ErrNoCredentials))
+
+	req.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+	_, err = c.TokenFromRequest(req)
+	is.True(err != nil)
+
+	req.Header.Set("Authorization", "ApiKey unknown")
+	_, err = c.TokenFromRequest(req)
+	is.True(err != nil)
+
+	// Cookies are ignored unless configured
+	req = httptest.NewRequest(http.MethodGet, "/orgs", nil)
+	req.AddCookie(&http.Cookie{Name: "walhall_jwt", Value: testToken})
+	_, err = c.TokenFromRequest(req)
+	is.True(errors.Is(err, ErrNoCredentials))
+}
+
+func TestCookieCrossSiteRequests(t *testing.T) {
+	is := is.New(t)
+	c := Credentials{CookieName: "walhall_jwt", AllowedOrigins: []string{"https://console.walhall.io"}}
+	request := func(method string, header http.Header) (string, error) {
+		req := httptest.NewRequest(method, "https://adaptor.walhall.io/orgs/org-one/modules/refresh", nil)
+		req.AddCookie(&http.Cookie{Name: "walhall_jwt", Value: testToken})
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		return c.TokenFromRequest(req)
+	}
+
+	// Safe methods may be sent from anywhere
+	token, err := request(http.MethodGet, http.Header{"Origin": {"https://evil.example.com"}})
+	is.NoErr(err)
+	is.Equal(token, testToken)
+
+	// Unsafe methods need an allowed or the same origin, or a header only scripts can send
+	for _, header := range []http.Header{
+		{"Origin": {"https://console.walhall.io"}},
+		{"Origin": {"https://adaptor.walhall.io"}},
+		{RequestedWithHeader: {"XMLHttpRequest"}},
+	} {
+		token, err = request(http.MethodPost, header)
+		is.NoErr(err)
+		is.Equal(token, testToken)
+	}
+	for _, header := range []http.Header{
+		{},
+		{"Origin": {"https://evil.example.com"}},
+		{"Origin": {"null"}},
+	} {
+		_, err = request(http.MethodPost, header)
+		is.True(errors.Is(err, ErrCrossSiteRequest))
+	}
+
+	// Other credentials do not depend on the origin, as browsers do not send them by themselves
+	req := httptest.NewRequest(http.MethodDelete, "/orgs", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	req.Header.Set("Authorization", "JWT "+testToken)
+	token, err = c.TokenFromRequest(req)
+	is.NoErr(err)
+	is.Equal(token, testToken)
+}
@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"humanitec.io/walhallapiadaptor/internal/walhallapi"
+)
+
+// jwksMinRefreshInterval stops tokens with made up "kid"s from hammering the JWKS endpoint.
+const jwksMinRefreshInterval = time.Minute
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	// Symmetric
+	K string `json:"k"`
+}
+
+// parseJWKS parses a JSON Web Key Set (RFC 7517). Keys not intended for signatures are skipped.
+func parseJWKS(data []byte) ([]verificationKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parse jwks: %w", err)
+	}
+	var keys []verificationKey
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("parse jwks key %q: %w", jwk.Kid, err)
+		}
+		keys = append(keys, verificationKey{kid: jwk.Kid, key: key})
+	}
+	return keys, nil
+}
+
+func (jwk jsonWebKey) publicKey() (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "oct":
+		return base64.RawURLEncoding.DecodeString(jwk.K)
+	}
+	return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// remoteJWKS holds the most recently fetched key set from a JWKS URL.
+type remoteJWKS struct {
+	url  string
+	doer walhallapi.Doer
+
+	mu          sync.RWMutex
+	keys        []verificationKey
+	lastRefresh time.Time
+}
+
+func newRemoteJWKS(url string, doer walhallapi.Doer) *remoteJWKS {
+	if doer == nil {
+		doer = http.DefaultClient
+	}
+	return &remoteJWKS{url: url, doer: doer}
+}
+
+func (r *remoteJWKS) current() []verificationKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.keys
+}
+
+func (r *remoteJWKS) refreshAllowed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return time.Since(r.lastRefresh) >= jwksMinRefreshInterval
+}
+
+func (r *remoteJWKS) refresh() error {
+	r.mu.Lock()
+	r.lastRefresh = time.Now()
+	r.mu.Unlock()
+
+	req, err := http.NewRequest(http.MethodGet, r.url, nil)
+	if err != nil {
+		return fmt.Errorf("fetch jwks: %w", err)
+	}
+	resp, err := r.doer.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetch jwks: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch jwks: expected 200, got %d", resp.StatusCode)
+	}
+	var raw json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return fmt.Errorf("fetch jwks: %w", err)
+	}
+	keys, err := parseJWKS(raw)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.keys = keys
+	r.mu.Unlock()
+	return nil
+}
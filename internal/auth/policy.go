@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"humanitec.io/walhallapiadaptor/internal/walhallapi"
+)
+
+// Policy describes which scopes a token needs for each route, keyed by route name.
+//
+// Example:
+//
+//	{
+//	  "org_membership": true,
+//	  "default_scopes": ["read"],
+//	  "routes": {
+//	    "refreshModules": ["write"]
+//	  }
+//	}
+type Policy struct {
+	// OrgMembership requires the org in the path to be one of the token's organization_uuids.
+	OrgMembership bool `json:"org_membership"`
+	// DefaultScopes are required for routes not listed in Routes.
+	DefaultScopes []string `json:"default_scopes"`
+	// Routes maps a route name to the scopes required to call it. An empty list allows any valid token.
+	Routes map[string][]string `json:"routes"`
+}
+
+// LoadPolicy reads a JSON policy file.
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read policy: %w", err)
+	}
+	var policy Policy
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&policy); err != nil {
+		return nil, fmt.Errorf("parse policy %s: %w", path, err)
+	}
+	return &policy, nil
+}
+
+// RequiredScopes returns the scopes needed to call the named route.
+func (p *Policy) RequiredScopes(route string) []string {
+	if scopes, ok := p.Routes[route]; ok {
+		return scopes
+	}
+	return p.DefaultScopes
+}
+
+// MissingScopes returns the required scopes which are not granted by the claims' space separated scope.
+func MissingScopes(claims walhallapi.WalhallClaims, required []string) []string {
+	granted := make(map[string]bool)
+	for _, scope := range strings.Fields(claims.Scope) {
+		granted[scope] = true
+	}
+	var missing []string
+	for _, scope := range required {
+		if !granted[scope] {
+			missing = append(missing, scope)
+		}
+	}
+	return missing
+}
+
+// InOrg reports whether the claims grant access to the org with the given UUID.
+func InOrg(claims walhallapi.WalhallClaims, orgUUID string) bool {
+	for _, uuid := range claims.OrgUUIDs {
+		if uuid == orgUUID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/matryer/is"
+	"humanitec.io/walhallapiadaptor/internal/walhallapi"
+)
+
+func TestLoadPolicy(t *testing.T) {
+	is := is.New(t)
+	dir, err := ioutil.TempDir("", "policy")
+	is.NoErr(err)
+	defer os.RemoveAll(dir)
+
+	policy, err := LoadPolicy(writeTemp(t, dir, "policy.json", []byte(`{
+  "org_membership": true,
+  "default_scopes": ["read"],
+  "routes": {"refreshModules": ["write"], "listOrgs": []}
+}`)))
+	is.NoErr(err)
+	is.True(policy.OrgMembership)
+	is.Equal(policy.RequiredScopes("listModules"), []string{"read"})
+	is.Equal(policy.RequiredScopes("refreshModules"), []string{"write"})
+	is.Equal(len(policy.RequiredScopes("listOrgs")), 0)
+
+	_, err = LoadPolicy(writeTemp(t, dir, "typo.json", []byte(`{"default_scope": ["read"]}`)))
+	is.True(err != nil) // unknown fields are rejected
+}
+
+func TestMissingScopes(t *testing.T) {
+	is := is.New(t)
+	claims := walhallapi.WalhallClaims{Scope: "read write", OrgUUIDs: []string{"a79d9e99-476d-4e29-a5f2-60102a5fff29"}}
+
+	is.Equal(len(MissingScopes(claims, []string{"read", "write"})), 0)
+	is.Equal(MissingScopes(claims, []string{"read", "deploy"}), []string{"deploy"})
+	is.True(InOrg(claims, "a79d9e99-476d-4e29-a5f2-60102a5fff29"))
+	is.True(!InOrg(claims, "f33f013e-e532-4b27-958e-50220a18a2bd"))
+}
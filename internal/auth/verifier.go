@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"humanitec.io/walhallapiadaptor/internal/walhallapi"
+)
+
+// ErrInvalidToken is returned (wrapped) by Verify for any token that should not be trusted.
+var ErrInvalidToken = errors.New("invalid token")
+
+// VerifierConfig describes which keys are trusted to sign JWTs and which claims must be present.
+type VerifierConfig struct {
+	// HMACSecrets are shared secrets accepted for HS256/HS384/HS512 tokens.
+	HMACSecrets []string
+	// PublicKeyFiles are paths to PEM encoded RSA or ECDSA public keys.
+	PublicKeyFiles []string
+	// JWKSFile is the path to a JSON Web Key Set.
+	JWKSFile string
+	// JWKSURL is fetched on startup and refetched when a token has an unknown "kid".
+	JWKSURL string
+	// Issuer, if set, must match the "iss" claim.
+	Issuer string
+	// Doer is used to fetch JWKSURL. Defaults to http.DefaultClient.
+	Doer walhallapi.Doer
+}
+
+// Verifier checks the signature and standard claims of JWTs before they are passed upstream.
+type Verifier struct {
+	issuer string
+	keys   []verificationKey
+	jwks   *remoteJWKS
+}
+
+type verificationKey struct {
+	kid string
+	key interface{}
+}
+
+// NewVerifier loads all keys described by the config. It fails if no keys are configured.
+func NewVerifier(cfg VerifierConfig) (*Verifier, error) {
+	v := Verifier{issuer: cfg.Issuer}
+	for _, secret := range cfg.HMACSecrets {
+		if secret == "" {
+			continue
+		}
+		v.keys = append(v.keys, verificationKey{key: []byte(secret)})
+	}
+	for _, path := range cfg.PublicKeyFiles {
+		key, err := loadPublicKey(path)
+		if err != nil {
+			return nil, err
+		}
+		v.keys = append(v.keys, verificationKey{key: key})
+	}
+	if cfg.JWKSFile != "" {
+		data, err := ioutil.ReadFile(cfg.JWKSFile)
+		if err != nil {
+			return nil, fmt.Errorf("read jwks file: %w", err)
+		}
+		keys, err := parseJWKS(data)
+		if err != nil {
+			return nil, fmt.Errorf("jwks file %s: %w", cfg.JWKSFile, err)
+		}
+		v.keys = append(v.keys, keys...)
+	}
+	if cfg.JWKSURL != "" {
+		v.jwks = newRemoteJWKS(cfg.JWKSURL, cfg.Doer)
+		if err := v.jwks.refresh(); err != nil {
+			return nil, err
+		}
+	}
+	if len(v.keys) == 0 && v.jwks == nil {
+		return nil, errors.New("new verifier: no verification keys configured")
+	}
+	return &v, nil
+}
+
+func loadPublicKey(path string) (interface{}, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read public key: %w", err)
+	}
+	if key, err := jwt.ParseRSAPublicKeyFromPEM(data); err == nil {
+		return key, nil
+	}
+	if key, err := jwt.ParseECPublicKeyFromPEM(data); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("public key %s: not a PEM encoded RSA or ECDSA public key", path)
+}
+
+// Verify checks the signature, expiry, not-before and issuer of a JWT and returns its claims.
+// A leading "JWT " or "Bearer " scheme is ignored.
+func (v *Verifier) Verify(token string) (walhallapi.WalhallClaims, error) {
+	token = stripScheme(token)
+	var claims walhallapi.WalhallClaims
+	err := v.verifySignature(token, &claims)
+	if errors.Is(err, errUnknownKey) && v.jwks != nil && v.jwks.refreshAllowed() {
+		// The key set may have been rotated since we last fetched it.
+		if refreshErr := v.jwks.refresh(); refreshErr == nil {
+			err = v.verifySignature(token, &claims)
+		}
+	}
+	if err != nil {
+		return walhallapi.WalhallClaims{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	now := time.Now().Unix()
+	if !claims.VerifyExpiresAt(now, true) {
+		return walhallapi.WalhallClaims{}, fmt.Errorf("%w: token is expired or has no expiry", ErrInvalidToken)
+	}
+	if !claims.VerifyNotBefore(now, false) {
+		return walhallapi.WalhallClaims{}, fmt.Errorf("%w: token is not valid yet", ErrInvalidToken)
+	}
+	if v.issuer != "" && !claims.VerifyIssuer(v.issuer, true) {
+		return walhallapi.WalhallClaims{}, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidToken, claims.Issuer)
+	}
+	return claims, nil
+}
+
+// errUnknownKey is returned when no configured key could have signed the token.
+var errUnknownKey = errors.New("no matching verification key")
+
+// verifySignature tries every key compatible with the token's algorithm (and "kid", if present).
+func (v *Verifier) verifySignature(token string, claims *walhallapi.WalhallClaims) error {
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	unverified, _, err := parser.ParseUnverified(token, &walhallapi.WalhallClaims{})
+	if err != nil {
+		return err
+	}
+	kid, _ := unverified.Header["kid"].(string)
+
+	candidates := v.candidateKeys(unverified.Method, kid)
+	if len(candidates) == 0 {
+		return errUnknownKey
+	}
+	var lastErr error
+	for _, key := range candidates {
+		var parsed walhallapi.WalhallClaims
+		_, err := parser.ParseWithClaims(token, &parsed, func(*jwt.Token) (interface{}, error) {
+			return key, nil
+		})
+		if err == nil {
+			*claims = parsed
+			return nil
+		}
+		lastErr = err
+	}
+	return lastErr
+}
+
+func (v *Verifier) candidateKeys(method jwt.SigningMethod, kid string) []interface{} {
+	keys := v.keys
+	if v.jwks != nil {
+		keys = append(append([]verificationKey{}, keys...), v.jwks.current()...)
+	}
+
+	var candidates []interface{}
+	for _, k := range keys {
+		if kid != "" && k.kid != "" && k.kid != kid {
+			continue
+		}
+		if keyMatchesMethod(k.key, method) {
+			candidates = append(candidates, k.key)
+		}
+	}
+	return candidates
+}
+
+func keyMatchesMethod(key interface{}, method jwt.SigningMethod) bool {
+	switch method.(type) {
+	case *jwt.SigningMethodHMAC:
+		_, ok := key.([]byte)
+		return ok
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		_, ok := key.(*rsa.PublicKey)
+		return ok
+	case *jwt.SigningMethodECDSA:
+		_, ok := key.(*ecdsa.PublicKey)
+		return ok
+	}
+	return false
+}
+
+func stripScheme(token string) string {
+	for _, scheme := range []string{"JWT ", "Bearer "} {
+		if len(token) >= len(scheme) && strings.EqualFold(token[:len(scheme)], scheme) {
+			return strings.TrimSpace(token[len(scheme):])
+		}
+	}
+	return strings.TrimSpace(token)
+}
@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/matryer/is"
+	"humanitec.io/walhallapiadaptor/internal/testutil"
+	"humanitec.io/walhallapiadaptor/internal/walhallapi"
+)
+
+func validClaims() walhallapi.WalhallClaims {
+	return walhallapi.WalhallClaims{
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    "walhall",
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			IssuedAt:  time.Now().Unix(),
+		},
+		UserUUID: "0b618579-f546-4338-9ece-a1c981f90c80",
+		Username: "chrishumanitec",
+		Scope:    "read write",
+	}
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, claims walhallapi.WalhallClaims, key interface{}) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	return signed
+}
+
+func writeTemp(t *testing.T, dir, name string, data []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestVerifyHMAC(t *testing.T) {
+	is := is.New(t)
+	v, err := NewVerifier(VerifierConfig{HMACSecrets: []string{"old-secret", "new-secret"}, Issuer: "walhall"})
+	is.NoErr(err)
+
+	claims, err := v.Verify("JWT " + sign(t, jwt.SigningMethodHS256, "", validClaims(), []byte("new-secret")))
+	is.NoErr(err)
+	is.Equal(claims.Username, "chrishumanitec")
+
+	_, err = v.Verify("JWT " + sign(t, jwt.SigningMethodHS256, "", validClaims(), []byte("forged")))
+	is.True(errors.Is(err, ErrInvalidToken))
+}
+
+func TestVerifyStandardClaims(t *testing.T) {
+	is := is.New(t)
+	v, err := NewVerifier(VerifierConfig{HMACSecrets: []string{"secret"}, Issuer: "walhall"})
+	is.NoErr(err)
+	key := []byte("secret")
+
+	expired := validClaims()
+	expired.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+	_, err = v.Verify(sign(t, jwt.SigningMethodHS256, "", expired, key))
+	is.True(errors.Is(err, ErrInvalidToken))
+
+	noExpiry := validClaims()
+	noExpiry.ExpiresAt = 0
+	_, err = v.Verify(sign(t, jwt.SigningMethodHS256, "", noExpiry, key))
+	is.True(errors.Is(err, ErrInvalidToken))
+
+	notYet := validClaims()
+	notYet.NotBefore = time.Now().Add(time.Hour).Unix()
+	_, err = v.Verify(sign(t, jwt.SigningMethodHS256, "", notYet, key))
+	is.True(errors.Is(err, ErrInvalidToken))
+
+	wrongIssuer := validClaims()
+	wrongIssuer.Issuer = "someone-else"
+	_, err = v.Verify(sign(t, jwt.SigningMethodHS256, "", wrongIssuer, key))
+	is.True(errors.Is(err, ErrInvalidToken))
+}
+
+func TestVerifyPublicKeyFiles(t *testing.T) {
+	is := is.New(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	is.NoErr(err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	is.NoErr(err)
+
+	dir, err := ioutil.TempDir("", "verifier")
+	is.NoErr(err)
+	defer os.RemoveAll(dir)
+
+	var files []string
+	for name, pub := range map[string]interface{}{"rsa.pem": &rsaKey.PublicKey, "ec.pem": &ecKey.PublicKey} {
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		is.NoErr(err)
+		files = append(files, writeTemp(t, dir, name, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})))
+	}
+	v, err := NewVerifier(VerifierConfig{PublicKeyFiles: files})
+	is.NoErr(err)
+
+	_, err = v.Verify(sign(t, jwt.SigningMethodRS256, "", validClaims(), rsaKey))
+	is.NoErr(err)
+	_, err = v.Verify(sign(t, jwt.SigningMethodES256, "", validClaims(), ecKey))
+	is.NoErr(err)
+
+	// An HMAC token must not be verified using the public key as the secret.
+	_, err = v.Verify(sign(t, jwt.SigningMethodHS256, "", validClaims(), []byte("secret")))
+	is.True(errors.Is(err, ErrInvalidToken))
+}
+
+func jwksFor(kid string, key *rsa.PublicKey) []byte {
+	jwks, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": kid,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	})
+	return jwks
+}
+
+func TestVerifyJWKS(t *testing.T) {
+	is := is.New(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	is.NoErr(err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	is.NoErr(err)
+
+	dir, err := ioutil.TempDir("", "verifier")
+	is.NoErr(err)
+	defer os.RemoveAll(dir)
+
+	v, err := NewVerifier(VerifierConfig{JWKSFile: writeTemp(t, dir, "jwks.json", jwksFor("key-1", &rsaKey.PublicKey))})
+	is.NoErr(err)
+	_, err = v.Verify(sign(t, jwt.SigningMethodRS256, "key-1", validClaims(), rsaKey))
+	is.NoErr(err)
+	_, err = v.Verify(sign(t, jwt.SigningMethodRS256, "key-1", validClaims(), otherKey))
+	is.True(errors.Is(err, ErrInvalidToken))
+
+	fakeDoer := testutil.NewFakeDoer(t)
+	fakeDoer.HandleRequest(http.MethodGet, "/.well-known/jwks.json", http.StatusOK, jwksFor("key-2", &otherKey.PublicKey), t)
+	v, err = NewVerifier(VerifierConfig{JWKSURL: "https://auth.example.com/.well-known/jwks.json", Doer: fakeDoer})
+	is.NoErr(err)
+	_, err = v.Verify(sign(t, jwt.SigningMethodRS256, "key-2", validClaims(), otherKey))
+	is.NoErr(err)
+}
+
+func TestNewVerifierRequiresKeys(t *testing.T) {
+	is := is.New(t)
+	_, err := NewVerifier(VerifierConfig{Issuer: "walhall"})
+	is.True(err != nil)
+}
@@ -0,0 +1,554 @@
+// Package config loads the adaptor's settings from (in increasing order of precedence) built-in defaults, a YAML or
+// JSON file, environment variables and command line flags, and validates the result.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+	"humanitec.io/walhallapiadaptor/internal/logging"
+	"humanitec.io/walhallapiadaptor/internal/walhallapi"
+)
+
+// Duration is a time.Duration which is written as a Go duration string (e.g. "30s") in config files.
+type Duration time.Duration
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	return d.set(s)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	return d.set(s)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// MarshalYAML implements yaml.Marshaler.
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+func (d *Duration) set(s string) error {
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Config holds every setting of the adaptor.
+type Config struct {
+	// WalhallAPIPrefix is the base URL of the Walhall Core API, without a trailing slash.
+	WalhallAPIPrefix string `yaml:"walhall_api_prefix" json:"walhall_api_prefix"`
+	// WalhallRegistry is the DNS name of the default registry for Walhall.
+	WalhallRegistry string `yaml:"walhall_registry" json:"walhall_registry"`
+	Port            int    `yaml:"port" json:"port"`
+	LogLevel        string `yaml:"log_level" json:"log_level"`
+	// GRPCPort is the port the gRPC API is served on. It is disabled if 0.
+	GRPCPort int `yaml:"grpc_port" json:"grpc_port"`
+	// UpstreamTimeout bounds each call to Walhall Core.
+	UpstreamTimeout Duration `yaml:"upstream_timeout" json:"upstream_timeout"`
+	// UpstreamMaxRetries is how often reads are retried after a timeout or 5xx response.
+	UpstreamMaxRetries int `yaml:"upstream_max_retries" json:"upstream_max_retries"`
+	// UpstreamParallelism is how many calls to Walhall Core one request may make at once, e.g. to fetch the
+	// configurations of every module in an environment.
+	UpstreamParallelism int `yaml:"upstream_parallelism" json:"upstream_parallelism"`
+	// CircuitBreakerThreshold is the number of consecutive failed calls after which Walhall Core is considered down.
+	// 0 disables the circuit breaker.
+	CircuitBreakerThreshold int `yaml:"circuit_breaker_threshold" json:"circuit_breaker_threshold"`
+	// CircuitBreakerCooldown is how long calls fail fast once Walhall Core is considered down.
+	CircuitBreakerCooldown Duration `yaml:"circuit_breaker_cooldown" json:"circuit_breaker_cooldown"`
+	// ModuleTagPolicy orders module versions whose tags are not semantic versions: oldest, newest or exclude (see
+	// walhallapi.TagPolicy).
+	ModuleTagPolicy string `yaml:"module_tag_policy" json:"module_tag_policy"`
+
+	Server    ServerConfig    `yaml:"server" json:"server"`
+	Auth      AuthConfig      `yaml:"auth" json:"auth"`
+	Tracing   TracingConfig   `yaml:"tracing" json:"tracing"`
+	RateLimit RateLimitConfig `yaml:"rate_limit" json:"rate_limit"`
+	CORS      CORSConfig      `yaml:"cors" json:"cors"`
+	GraphQL   GraphQLConfig   `yaml:"graphql" json:"graphql"`
+}
+
+// ServerConfig holds the HTTP server timeouts.
+type ServerConfig struct {
+	ReadHeaderTimeout Duration `yaml:"read_header_timeout" json:"read_header_timeout"`
+	ReadTimeout       Duration `yaml:"read_timeout" json:"read_timeout"`
+	WriteTimeout      Duration `yaml:"write_timeout" json:"write_timeout"`
+	IdleTimeout       Duration `yaml:"idle_timeout" json:"idle_timeout"`
+	// ShutdownDelay is how long readiness probes fail before the listeners are closed on shutdown.
+	ShutdownDelay   Duration `yaml:"shutdown_delay" json:"shutdown_delay"`
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" json:"shutdown_timeout"`
+}
+
+// AuthConfig holds the authentication and authorization settings.
+type AuthConfig struct {
+	HMACSecrets    []string `yaml:"hmac_secrets" json:"hmac_secrets"`
+	PublicKeyFiles []string `yaml:"public_key_files" json:"public_key_files"`
+	JWKSFile       string   `yaml:"jwks_file" json:"jwks_file"`
+	JWKSURL        string   `yaml:"jwks_url" json:"jwks_url"`
+	Issuer         string   `yaml:"issuer" json:"issuer"`
+	CookieName     string   `yaml:"cookie_name" json:"cookie_name"`
+	APIKeysFile    string   `yaml:"api_keys_file" json:"api_keys_file"`
+	PolicyFile     string   `yaml:"policy_file" json:"policy_file"`
+}
+
+// VerificationEnabled reports whether any keys to verify JWTs with are configured.
+func (a AuthConfig) VerificationEnabled() bool {
+	return len(a.HMACSecrets) > 0 || len(a.PublicKeyFiles) > 0 || a.JWKSFile != "" || a.JWKSURL != ""
+}
+
+// TracingConfig selects where trace spans are exported to.
+type TracingConfig struct {
+	OTLPEndpoint string `yaml:"otlp_endpoint" json:"otlp_endpoint"`
+	File         string `yaml:"file" json:"file"`
+}
+
+// RateLimitConfig holds the budgets each user has per org, for reads (GET, HEAD and OPTIONS) and for other requests.
+type RateLimitConfig struct {
+	Read  RateLimit `yaml:"read" json:"read"`
+	Write RateLimit `yaml:"write" json:"write"`
+	// TrustedProxies are the addresses or CIDR ranges of load balancers and proxies in front of the adaptor. Clients
+	// identified by their address are identified by the X-Forwarded-For header these add instead.
+	TrustedProxies []string `yaml:"trusted_proxies" json:"trusted_proxies"`
+}
+
+// TrustedProxyPrefixes parses TrustedProxies. A single address is a range of one.
+func (r RateLimitConfig) TrustedProxyPrefixes() ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(r.TrustedProxies))
+	for _, proxy := range r.TrustedProxies {
+		if addr, err := netip.ParseAddr(proxy); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("%q is neither an address nor a CIDR range", proxy)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// RateLimit allows Burst requests at once, refilled at PerMinute requests per minute. A PerMinute of 0 disables it.
+type RateLimit struct {
+	PerMinute float64 `yaml:"per_minute" json:"per_minute"`
+	Burst     int     `yaml:"burst" json:"burst"`
+}
+
+// CORSConfig controls which browser origins may call the API. CORS is disabled unless AllowedOrigins is set.
+type CORSConfig struct {
+	// AllowedOrigins are origins such as "https://console.walhall.io", or "*" for any origin.
+	AllowedOrigins []string `yaml:"allowed_origins" json:"allowed_origins"`
+	AllowedMethods []string `yaml:"allowed_methods" json:"allowed_methods"`
+	AllowedHeaders []string `yaml:"allowed_headers" json:"allowed_headers"`
+	// ExposedHeaders are the response headers scripts may read.
+	ExposedHeaders []string `yaml:"exposed_headers" json:"exposed_headers"`
+	// AllowCredentials lets browsers send cookies, which is needed for cookie authentication.
+	AllowCredentials bool `yaml:"allow_credentials" json:"allow_credentials"`
+	// MaxAge is how long browsers may cache the result of a preflight request, at most 10 minutes.
+	MaxAge Duration `yaml:"max_age" json:"max_age"`
+}
+
+// GraphQLConfig bounds the work one GraphQL request may cause.
+type GraphQLConfig struct {
+	// MaxDepth is how deeply the fields of a query may be nested.
+	MaxDepth int `yaml:"max_depth" json:"max_depth"`
+	// MaxCalls is how many calls to Walhall Core one query may make.
+	MaxCalls int `yaml:"max_calls" json:"max_calls"`
+}
+
+// Enabled reports whether any origin is allowed.
+func (c CORSConfig) Enabled() bool {
+	return len(c.AllowedOrigins) > 0
+}
+
+// maxCORSMaxAge is the longest preflight cache browsers honor.
+const maxCORSMaxAge = Duration(10 * time.Minute)
+
+// Default returns the settings used when nothing else is configured.
+func Default() Config {
+	return Config{
+		Port:            8080,
+		LogLevel:        "info",
+		UpstreamTimeout: Duration(30 * time.Second),
+
+		UpstreamMaxRetries:      2,
+		UpstreamParallelism:     4,
+		CircuitBreakerThreshold: 5,
+		CircuitBreakerCooldown:  Duration(30 * time.Second),
+		ModuleTagPolicy:         string(walhallapi.TagsOldest),
+		Server: ServerConfig{
+			ReadHeaderTimeout: Duration(10 * time.Second),
+			ReadTimeout:       Duration(30 * time.Second),
+			WriteTimeout:      Duration(60 * time.Second),
+			IdleTimeout:       Duration(120 * time.Second),
+			ShutdownDelay:     Duration(5 * time.Second),
+			ShutdownTimeout:   Duration(30 * time.Second),
+		},
+		RateLimit: RateLimitConfig{
+			Read:  RateLimit{PerMinute: 600, Burst: 60},
+			Write: RateLimit{PerMinute: 6, Burst: 3},
+		},
+		CORS: CORSConfig{
+			AllowedMethods: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"},
+			AllowedHeaders: []string{"Authorization", "Content-Type", "X-API-Key", "X-Request-ID", "Traceparent",
+				"X-Requested-With"},
+			ExposedHeaders: []string{"X-Request-ID", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset",
+				"Retry-After", "WWW-Authenticate", "Deprecation", "Link"},
+			MaxAge: Duration(10 * time.Minute),
+		},
+		GraphQL: GraphQLConfig{MaxDepth: 15, MaxCalls: 200},
+	}
+}
+
+// LoadFile merges the settings in a YAML or JSON file (chosen by extension) into c.
+func (c *Config) LoadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		decoder := json.NewDecoder(strings.NewReader(string(data)))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(c)
+	} else {
+		err = yaml.UnmarshalStrict(data, c)
+	}
+	if err != nil {
+		return fmt.Errorf("parse config %s: %w", path, err)
+	}
+	return nil
+}
+
+// envVar binds an environment variable to a setting.
+type envVar struct {
+	name string
+	set  func(c *Config, value string) error
+}
+
+func stringVar(field func(c *Config) *string) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}
+}
+
+func listVar(field func(c *Config) *[]string) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		*field(c) = splitList(value)
+		return nil
+	}
+}
+
+func boolVar(field func(c *Config) *bool) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		*field(c) = b
+		return err
+	}
+}
+
+func intVar(field func(c *Config) *int) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		i, err := strconv.Atoi(value)
+		*field(c) = i
+		return err
+	}
+}
+
+func floatVar(field func(c *Config) *float64) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		f, err := strconv.ParseFloat(value, 64)
+		*field(c) = f
+		return err
+	}
+}
+
+func durationVar(field func(c *Config) *Duration) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		return field(c).set(value)
+	}
+}
+
+var envVars = []envVar{
+	{"WALHALL_API_PREFIX", stringVar(func(c *Config) *string { return &c.WalhallAPIPrefix })},
+	{"WALHALL_REGISTRY", stringVar(func(c *Config) *string { return &c.WalhallRegistry })},
+	{"PORT", intVar(func(c *Config) *int { return &c.Port })},
+	{"GRPC_PORT", intVar(func(c *Config) *int { return &c.GRPCPort })},
+	{"LOG_LEVEL", stringVar(func(c *Config) *string { return &c.LogLevel })},
+	{"UPSTREAM_TIMEOUT", durationVar(func(c *Config) *Duration { return &c.UpstreamTimeout })},
+	{"UPSTREAM_MAX_RETRIES", intVar(func(c *Config) *int { return &c.UpstreamMaxRetries })},
+	{"UPSTREAM_PARALLELISM", intVar(func(c *Config) *int { return &c.UpstreamParallelism })},
+	{"CIRCUIT_BREAKER_THRESHOLD", intVar(func(c *Config) *int { return &c.CircuitBreakerThreshold })},
+	{"CIRCUIT_BREAKER_COOLDOWN", durationVar(func(c *Config) *Duration { return &c.CircuitBreakerCooldown })},
+	{"MODULE_TAG_POLICY", stringVar(func(c *Config) *string { return &c.ModuleTagPolicy })},
+	{"SERVER_READ_HEADER_TIMEOUT", durationVar(func(c *Config) *Duration { return &c.Server.ReadHeaderTimeout })},
+	{"SERVER_READ_TIMEOUT", durationVar(func(c *Config) *Duration { return &c.Server.ReadTimeout })},
+	{"SERVER_WRITE_TIMEOUT", durationVar(func(c *Config) *Duration { return &c.Server.WriteTimeout })},
+	{"SERVER_IDLE_TIMEOUT", durationVar(func(c *Config) *Duration { return &c.Server.IdleTimeout })},
+	{"SHUTDOWN_DELAY", durationVar(func(c *Config) *Duration { return &c.Server.ShutdownDelay })},
+	{"SHUTDOWN_TIMEOUT", durationVar(func(c *Config) *Duration { return &c.Server.ShutdownTimeout })},
+	{"JWT_HMAC_SECRETS", listVar(func(c *Config) *[]string { return &c.Auth.HMACSecrets })},
+	{"JWT_PUBLIC_KEY_FILES", listVar(func(c *Config) *[]string { return &c.Auth.PublicKeyFiles })},
+	{"JWT_JWKS_FILE", stringVar(func(c *Config) *string { return &c.Auth.JWKSFile })},
+	{"JWT_JWKS_URL", stringVar(func(c *Config) *string { return &c.Auth.JWKSURL })},
+	{"JWT_ISSUER", stringVar(func(c *Config) *string { return &c.Auth.Issuer })},
+	{"JWT_COOKIE_NAME", stringVar(func(c *Config) *string { return &c.Auth.CookieName })},
+	{"API_KEYS_FILE", stringVar(func(c *Config) *string { return &c.Auth.APIKeysFile })},
+	{"AUTH_POLICY_FILE", stringVar(func(c *Config) *string { return &c.Auth.PolicyFile })},
+	{"RATE_LIMIT_READ_PER_MINUTE", floatVar(func(c *Config) *float64 { return &c.RateLimit.Read.PerMinute })},
+	{"RATE_LIMIT_READ_BURST", intVar(func(c *Config) *int { return &c.RateLimit.Read.Burst })},
+	{"RATE_LIMIT_WRITE_PER_MINUTE", floatVar(func(c *Config) *float64 { return &c.RateLimit.Write.PerMinute })},
+	{"RATE_LIMIT_WRITE_BURST", intVar(func(c *Config) *int { return &c.RateLimit.Write.Burst })},
+	{"RATE_LIMIT_TRUSTED_PROXIES", listVar(func(c *Config) *[]string { return &c.RateLimit.TrustedProxies })},
+	{"CORS_ALLOWED_ORIGINS", listVar(func(c *Config) *[]string { return &c.CORS.AllowedOrigins })},
+	{"CORS_ALLOWED_METHODS", listVar(func(c *Config) *[]string { return &c.CORS.AllowedMethods })},
+	{"CORS_ALLOWED_HEADERS", listVar(func(c *Config) *[]string { return &c.CORS.AllowedHeaders })},
+	{"CORS_EXPOSED_HEADERS", listVar(func(c *Config) *[]string { return &c.CORS.ExposedHeaders })},
+	{"CORS_ALLOW_CREDENTIALS", boolVar(func(c *Config) *bool { return &c.CORS.AllowCredentials })},
+	{"CORS_MAX_AGE", durationVar(func(c *Config) *Duration { return &c.CORS.MaxAge })},
+	{"GRAPHQL_MAX_DEPTH", intVar(func(c *Config) *int { return &c.GraphQL.MaxDepth })},
+	{"GRAPHQL_MAX_CALLS", intVar(func(c *Config) *int { return &c.GraphQL.MaxCalls })},
+	{"OTEL_EXPORTER_OTLP_ENDPOINT", stringVar(func(c *Config) *string { return &c.Tracing.OTLPEndpoint })},
+	{"TRACING_FILE", stringVar(func(c *Config) *string { return &c.Tracing.File })},
+}
+
+// ApplyEnv overrides settings with any of the supported environment variables which are set.
+func (c *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	for _, v := range envVars {
+		value, ok := lookup(v.name)
+		if !ok || value == "" {
+			continue
+		}
+		if err := v.set(c, value); err != nil {
+			return fmt.Errorf("%s: %v", v.name, err)
+		}
+	}
+	return nil
+}
+
+// Load builds the configuration for the command line args (excluding the program name). The config file is
+// taken from the -config flag or the WALHALL_ADAPTOR_CONFIG environment variable.
+func Load(args []string, lookupEnv func(string) (string, bool)) (Config, error) {
+	cfg := Default()
+
+	flags := flag.NewFlagSet("walhallapiadaptor", flag.ContinueOnError)
+	configFile := flags.String("config", "", "path to a YAML or JSON config file")
+	apiPrefix := flags.String("walhall-api-prefix", "", "base URL of the Walhall Core API")
+	registry := flags.String("walhall-registry", "", "DNS name of the Walhall registry")
+	port := flags.Int("port", 0, "port to listen on")
+	logLevel := flags.String("log-level", "", "one of debug, info, warn or error")
+	if err := flags.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	path := *configFile
+	if path == "" {
+		path, _ = lookupEnv("WALHALL_ADAPTOR_CONFIG")
+	}
+	if path != "" {
+		if err := cfg.LoadFile(path); err != nil {
+			return Config{}, err
+		}
+	}
+	if err := cfg.ApplyEnv(lookupEnv); err != nil {
+		return Config{}, err
+	}
+
+	if *apiPrefix != "" {
+		cfg.WalhallAPIPrefix = *apiPrefix
+	}
+	if *registry != "" {
+		cfg.WalhallRegistry = *registry
+	}
+	if *port != 0 {
+		cfg.Port = *port
+	}
+	if *logLevel != "" {
+		cfg.LogLevel = *logLevel
+	}
+
+	cfg.Normalize()
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// LoadFromOS is Load using the process' arguments and environment.
+func LoadFromOS() (Config, error) {
+	return Load(os.Args[1:], os.LookupEnv)
+}
+
+// Normalize tidies up values which are valid in more than one form.
+func (c *Config) Normalize() {
+	// Paths are appended to the prefix, so "https://api.walhall.io/" would produce "https://api.walhall.io//api/..."
+	c.WalhallAPIPrefix = strings.TrimRight(strings.TrimSpace(c.WalhallAPIPrefix), "/")
+	c.WalhallRegistry = strings.TrimRight(strings.TrimSpace(c.WalhallRegistry), "/")
+	c.LogLevel = strings.ToLower(c.LogLevel)
+	c.ModuleTagPolicy = strings.ToLower(strings.TrimSpace(c.ModuleTagPolicy))
+	// Browsers send the origin without a trailing slash
+	for i, origin := range c.CORS.AllowedOrigins {
+		c.CORS.AllowedOrigins[i] = strings.TrimRight(origin, "/")
+	}
+}
+
+// Validate reports all invalid settings at once.
+func (c Config) Validate() error {
+	var problems []string
+	if c.WalhallAPIPrefix == "" {
+		problems = append(problems, "walhall_api_prefix is required")
+	} else if u, err := url.Parse(c.WalhallAPIPrefix); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("walhall_api_prefix must be an absolute http(s) URL, got %q", c.WalhallAPIPrefix))
+	}
+	if c.WalhallRegistry == "" {
+		problems = append(problems, "walhall_registry is required")
+	} else if strings.Contains(c.WalhallRegistry, "://") {
+		problems = append(problems, fmt.Sprintf("walhall_registry must be a DNS name without a scheme, got %q", c.WalhallRegistry))
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		problems = append(problems, fmt.Sprintf("port must be between 1 and 65535, got %d", c.Port))
+	}
+	if c.GRPCPort < 0 || c.GRPCPort > 65535 {
+		problems = append(problems, fmt.Sprintf("grpc_port must be between 1 and 65535 or 0 to disable gRPC, got %d", c.GRPCPort))
+	} else if c.GRPCPort != 0 && c.GRPCPort == c.Port {
+		problems = append(problems, fmt.Sprintf("grpc_port must differ from port, both are %d", c.Port))
+	}
+	if _, err := logging.ParseLevel(c.LogLevel); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := walhallapi.ParseTagPolicy(c.ModuleTagPolicy); err != nil {
+		problems = append(problems, "module_tag_policy: "+err.Error())
+	}
+	durations := []struct {
+		name  string
+		value Duration
+	}{
+		{"upstream_timeout", c.UpstreamTimeout},
+		{"circuit_breaker_cooldown", c.CircuitBreakerCooldown},
+		{"server.read_header_timeout", c.Server.ReadHeaderTimeout},
+		{"server.read_timeout", c.Server.ReadTimeout},
+		{"server.write_timeout", c.Server.WriteTimeout},
+		{"server.idle_timeout", c.Server.IdleTimeout},
+		{"server.shutdown_delay", c.Server.ShutdownDelay},
+		{"server.shutdown_timeout", c.Server.ShutdownTimeout},
+	}
+	for _, d := range durations {
+		if d.value < 0 {
+			problems = append(problems, fmt.Sprintf("%s must not be negative", d.name))
+		}
+	}
+	if c.UpstreamMaxRetries < 0 {
+		problems = append(problems, "upstream_max_retries must not be negative")
+	}
+	if c.UpstreamParallelism < 1 {
+		problems = append(problems, fmt.Sprintf("upstream_parallelism must be at least 1, got %d", c.UpstreamParallelism))
+	}
+	if c.CircuitBreakerThreshold < 0 {
+		problems = append(problems, "circuit_breaker_threshold must not be negative")
+	}
+	for _, limit := range []struct {
+		name string
+		RateLimit
+	}{{"rate_limit.read", c.RateLimit.Read}, {"rate_limit.write", c.RateLimit.Write}} {
+		if limit.PerMinute < 0 || limit.Burst < 0 || (limit.PerMinute > 0 && limit.Burst == 0) {
+			problems = append(problems, fmt.Sprintf("%s needs a non-negative per_minute and a positive burst", limit.name))
+		}
+	}
+	if _, err := c.RateLimit.TrustedProxyPrefixes(); err != nil {
+		problems = append(problems, "rate_limit.trusted_proxies: "+err.Error())
+	}
+	if c.GraphQL.MaxDepth < 1 {
+		problems = append(problems, fmt.Sprintf("graphql.max_depth must be at least 1, got %d", c.GraphQL.MaxDepth))
+	}
+	if c.GraphQL.MaxCalls < 1 {
+		problems = append(problems, fmt.Sprintf("graphql.max_calls must be at least 1, got %d", c.GraphQL.MaxCalls))
+	}
+	for _, origin := range c.CORS.AllowedOrigins {
+		if origin == "*" {
+			if c.CORS.AllowCredentials {
+				problems = append(problems, "cors.allowed_origins must list the origins instead of \"*\" when cors.allow_credentials is set")
+			}
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			u.Path != "" || u.RawQuery != "" || u.User != nil {
+			problems = append(problems, fmt.Sprintf("cors.allowed_origins must be \"*\" or scheme://host[:port], got %q", origin))
+		}
+	}
+	if c.CORS.MaxAge < 0 || c.CORS.MaxAge > maxCORSMaxAge {
+		problems = append(problems, fmt.Sprintf("cors.max_age must be between 0 and %s", time.Duration(maxCORSMaxAge)))
+	}
+	if c.Auth.JWKSURL != "" {
+		if u, err := url.Parse(c.Auth.JWKSURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			problems = append(problems, fmt.Sprintf("auth.jwks_url must be an http(s) URL, got %q", c.Auth.JWKSURL))
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+const redacted = "REDACTED"
+
+// Redacted returns a copy of the configuration which is safe to log.
+func (c Config) Redacted() Config {
+	if len(c.Auth.HMACSecrets) > 0 {
+		secrets := make([]string, len(c.Auth.HMACSecrets))
+		for i := range secrets {
+			secrets[i] = redacted
+		}
+		c.Auth.HMACSecrets = secrets
+	}
+	c.WalhallAPIPrefix = redactURL(c.WalhallAPIPrefix)
+	c.Auth.JWKSURL = redactURL(c.Auth.JWKSURL)
+	c.Tracing.OTLPEndpoint = redactURL(c.Tracing.OTLPEndpoint)
+	return c
+}
+
+// redactURL hides any credentials embedded in a URL.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	u.User = url.User(redacted)
+	return u.String()
+}
+
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
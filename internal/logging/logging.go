@@ -0,0 +1,174 @@
+// Package logging provides a small levelled logger which writes one JSON object per line and can be carried in a
+// context.Context so that every line logged while serving a request is tagged with its request ID.
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log line.
+type Level int
+
+// Supported levels, in increasing order of severity.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel converts a level name (e.g. from configuration) into a Level.
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", name)
+}
+
+// Logger writes JSON log lines at or above its level. Loggers derived using With share the same output.
+type Logger struct {
+	out    *output
+	level  Level
+	fields []interface{}
+}
+
+type output struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// New returns a Logger writing to w.
+func New(w io.Writer, level Level) *Logger {
+	return &Logger{out: &output{w: w}, level: level}
+}
+
+var defaultLogger = New(os.Stdout, LevelInfo)
+
+// Default returns the process wide logger.
+func Default() *Logger {
+	return defaultLogger
+}
+
+// SetDefault replaces the process wide logger.
+func SetDefault(l *Logger) {
+	defaultLogger = l
+}
+
+// With returns a Logger which adds the supplied key/value pairs to every line.
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyvals...)
+	return &Logger{out: l.out, level: l.level, fields: fields}
+}
+
+// Enabled reports whether lines at the given level are written.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+// Debug logs a message with alternating key/value pairs.
+func (l *Logger) Debug(msg string, keyvals ...interface{}) { l.log(LevelDebug, msg, keyvals) }
+
+// Info logs a message with alternating key/value pairs.
+func (l *Logger) Info(msg string, keyvals ...interface{}) { l.log(LevelInfo, msg, keyvals) }
+
+// Warn logs a message with alternating key/value pairs.
+func (l *Logger) Warn(msg string, keyvals ...interface{}) { l.log(LevelWarn, msg, keyvals) }
+
+// Error logs a message with alternating key/value pairs.
+func (l *Logger) Error(msg string, keyvals ...interface{}) { l.log(LevelError, msg, keyvals) }
+
+func (l *Logger) log(level Level, msg string, keyvals []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	line := map[string]interface{}{
+		"time":  time.Now().UTC().Format(time.RFC3339Nano),
+		"level": level.String(),
+		"msg":   msg,
+	}
+	addFields(line, l.fields)
+	addFields(line, keyvals)
+
+	data, err := json.Marshal(line)
+	if err != nil {
+		data = []byte(fmt.Sprintf(`{"level":"error","msg":"marshal log line: %v"}`, err))
+	}
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.w.Write(append(data, '\n'))
+}
+
+func addFields(line map[string]interface{}, keyvals []interface{}) {
+	for i := 0; i < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+		if i+1 == len(keyvals) {
+			line[key] = nil
+			break
+		}
+		switch value := keyvals[i+1].(type) {
+		case error:
+			line[key] = value.Error()
+		case time.Duration:
+			line[key] = float64(value) / float64(time.Millisecond)
+		default:
+			line[key] = value
+		}
+	}
+}
+
+type contextKey int
+
+const (
+	loggerKey contextKey = iota
+	requestIDKey
+)
+
+// NewContext returns a copy of ctx carrying the logger.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, l)
+}
+
+// FromContext returns the logger stored in ctx, or the default logger.
+func FromContext(ctx context.Context) *Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(loggerKey).(*Logger); ok {
+			return l
+		}
+	}
+	return Default()
+}
+
+// WithRequestID returns a copy of ctx carrying the request ID, with a logger that tags every line with it.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey, requestID)
+	return NewContext(ctx, FromContext(ctx).With("request_id", requestID))
+}
+
+// RequestID returns the request ID stored in ctx, if any.
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
@@ -0,0 +1,56 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestLoggerWritesJSON(t *testing.T) {
+	is := is.New(t)
+	var buf bytes.Buffer
+	logger := New(&buf, LevelInfo).With("component", "test")
+
+	logger.Debug("hidden")
+	logger.Info("request", "status", 200, "duration_ms", 1500*time.Microsecond, "error", errors.New("boom"))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	is.Equal(len(lines), 1) // debug lines are dropped at info level
+	var line map[string]interface{}
+	is.NoErr(json.Unmarshal([]byte(lines[0]), &line))
+	is.Equal(line["level"], "info")
+	is.Equal(line["msg"], "request")
+	is.Equal(line["component"], "test")
+	is.Equal(line["status"], float64(200))
+	is.Equal(line["duration_ms"], 1.5)
+	is.Equal(line["error"], "boom")
+}
+
+func TestRequestIDContext(t *testing.T) {
+	is := is.New(t)
+	var buf bytes.Buffer
+	ctx := NewContext(context.Background(), New(&buf, LevelDebug))
+	ctx = WithRequestID(ctx, "abc123")
+
+	is.Equal(RequestID(ctx), "abc123")
+	FromContext(ctx).Debug("upstream")
+
+	var line map[string]interface{}
+	is.NoErr(json.Unmarshal(buf.Bytes(), &line))
+	is.Equal(line["request_id"], "abc123")
+}
+
+func TestParseLevel(t *testing.T) {
+	is := is.New(t)
+	level, err := ParseLevel("WARN")
+	is.NoErr(err)
+	is.Equal(level, LevelWarn)
+	_, err = ParseLevel("verbose")
+	is.True(err != nil)
+}
@@ -0,0 +1,189 @@
+package manifest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"humanitec.io/walhallapiadaptor/internal/walhallapi"
+)
+
+// ArchiveVersion is the version of the archive format written by Export.
+const ArchiveVersion = 1
+
+// Archive is an exported application: the manifest of each of its environments.
+type Archive struct {
+	Version int        `json:"version"`
+	Org     string     `json:"org"`
+	App     string     `json:"app"`
+	Envs    []Manifest `json:"environments"`
+}
+
+// Export reads the module versions and configurations of all environments of an application. If a module version
+// has several configurations of one type, only the first is exported. The configurations are fetched until ctx is
+// done.
+func Export(ctx context.Context, walhall walhallapi.WalhallAPIer, orgName, appName string,
+	opts Options) (Archive, error) {
+	envs, err := walhall.ListEnvs(orgName, appName)
+	if err != nil {
+		return Archive{}, fmt.Errorf("list environments: %w", err)
+	}
+	archive := Archive{Version: ArchiveVersion, Org: orgName, App: appName, Envs: make([]Manifest, len(envs))}
+
+	type item struct{ env, module int }
+	var items []item
+	for i, env := range envs {
+		archive.Envs[i] = Manifest{Org: orgName, App: appName, Env: env.Name, Modules: []Module{}}
+		for j, mv := range env.ModuleVersions {
+			archive.Envs[i].Modules = append(archive.Envs[i].Modules, Module{Name: mv.Module.Name, Version: mv.Version})
+			items = append(items, item{i, j})
+		}
+	}
+	err = walhallapi.FanOut(ctx, len(items), opts.parallelism(),
+		func(ctx context.Context, i int) error {
+			env := envs[items[i].env]
+			configs, err := walhall.GetConfigsForModuleVersionInEnv(env, env.ModuleVersions[items[i].module].ModuleVersion)
+			if err != nil {
+				return err
+			}
+			module := &archive.Envs[items[i].env].Modules[items[i].module]
+			types := make(map[string]bool)
+			for _, config := range configs {
+				if !types[config.Type] {
+					types[config.Type] = true
+					module.Configs = append(module.Configs, Config{Type: config.Type, Spec: config.Spec})
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		return Archive{}, fmt.Errorf("get configs: %w", err)
+	}
+
+	sort.Slice(archive.Envs, func(i, j int) bool { return archive.Envs[i].Env < archive.Envs[j].Env })
+	for _, m := range archive.Envs {
+		sort.Slice(m.Modules, func(i, j int) bool { return m.Modules[i].Name < m.Modules[j].Name })
+		for _, module := range m.Modules {
+			sort.Slice(module.Configs, func(i, j int) bool { return module.Configs[i].Type < module.Configs[j].Type })
+		}
+	}
+	return archive, nil
+}
+
+// Write encodes a as indented JSON.
+func (a Archive) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(a)
+}
+
+// ReadArchive decodes and validates an archive written by Write.
+func ReadArchive(r io.Reader) (Archive, error) {
+	var a Archive
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&a); err != nil {
+		return Archive{}, fmt.Errorf("parse archive: %w", err)
+	}
+	if a.Version != ArchiveVersion {
+		return Archive{}, fmt.Errorf("unsupported archive version %d", a.Version)
+	}
+	for _, m := range a.Envs {
+		if m.Org != a.Org || m.App != a.App {
+			return Archive{}, fmt.Errorf("environment %s belongs to %s/%s, not %s/%s", m.Env, m.Org, m.App, a.Org, a.App)
+		}
+		if err := m.Validate(); err != nil {
+			return Archive{}, fmt.Errorf("environment %s: %w", m.Env, err)
+		}
+	}
+	return a, nil
+}
+
+// Renames maps the names in an archive to those used when importing it. Empty or unlisted names are kept.
+type Renames struct {
+	Org     string
+	App     string
+	Envs    map[string]string
+	Modules map[string]string
+}
+
+func rename(names map[string]string, name string) string {
+	if renamed, ok := names[name]; ok {
+		return renamed
+	}
+	return name
+}
+
+// Rename returns a copy of a with the names in r replaced.
+func (a Archive) Rename(r Renames) Archive {
+	if r.Org != "" {
+		a.Org = r.Org
+	}
+	if r.App != "" {
+		a.App = r.App
+	}
+	envs := make([]Manifest, len(a.Envs))
+	for i, m := range a.Envs {
+		envs[i] = Manifest{Org: a.Org, App: a.App, Env: rename(r.Envs, m.Env), Modules: make([]Module, len(m.Modules))}
+		for j, module := range m.Modules {
+			module.Name = rename(r.Modules, module.Name)
+			envs[i].Modules[j] = module
+		}
+	}
+	a.Envs = envs
+	return a
+}
+
+// Import plans the changes which make the environments of the application named in a match the archive. The
+// application and its environments must exist, since Walhall Core's API cannot create them, and the modules must be
+// available in the same versions.
+func Import(ctx context.Context, walhall walhallapi.WalhallAPIer, a Archive, opts Options) ([]*Plan, error) {
+	for _, m := range a.Envs {
+		if err := m.Validate(); err != nil {
+			return nil, fmt.Errorf("environment %s: %w", m.Env, err)
+		}
+	}
+	if err := requireEnvs(walhall, a); err != nil {
+		return nil, err
+	}
+	plans := make([]*Plan, len(a.Envs))
+	for i, m := range a.Envs {
+		plan, err := NewPlan(ctx, walhall, m, opts)
+		if err != nil {
+			return nil, err
+		}
+		plans[i] = plan
+	}
+	return plans, nil
+}
+
+// requireEnvs fails with walhallapi.ErrNotFound, naming what is missing, unless the application and all environments
+// of a exist.
+func requireEnvs(walhall walhallapi.WalhallAPIer, a Archive) error {
+	envs, err := walhall.ListEnvs(a.Org, a.App)
+	if errors.Is(err, walhallapi.ErrNotFound) {
+		return fmt.Errorf("application %s/%s must exist before importing: %w", a.Org, a.App, err)
+	}
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool, len(envs))
+	for _, env := range envs {
+		existing[env.Name] = true
+	}
+	var missing []string
+	for _, m := range a.Envs {
+		if !existing[m.Env] {
+			missing = append(missing, m.Env)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("environments %s of %s/%s must exist before importing: %w",
+			strings.Join(missing, ", "), a.Org, a.App, walhallapi.ErrNotFound)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package manifest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+	"humanitec.io/walhallapiadaptor/internal/walhallapi"
+)
+
+func TestExport(t *testing.T) {
+	is := is.New(t)
+	walhall := newFakeWalhall()
+	walhall.configs[auth100.ID] = []walhallapi.Config{
+		{ID: 10, Type: "secret", Spec: map[string]interface{}{"KEY": "value"}},
+		{ID: 11, Type: "env", Spec: map[string]interface{}{"LOG_LEVEL": "info"}},
+		{ID: 12, Type: "env", Spec: map[string]interface{}{"LOG_LEVEL": "debug"}},
+	}
+
+	archive, err := Export(context.Background(), walhall, "src-org", "src-app", Options{})
+	is.NoErr(err)
+	is.Equal(archive, Archive{Version: ArchiveVersion, Org: "src-org", App: "src-app", Envs: []Manifest{{
+		Org: "src-org", App: "src-app", Env: "dev",
+		Modules: []Module{
+			{Name: "auth", Version: "1.0.0", Configs: []Config{
+				{Type: "env", Spec: map[string]interface{}{"LOG_LEVEL": "info"}},
+				{Type: "secret", Spec: map[string]interface{}{"KEY": "value"}},
+			}},
+			{Name: "db", Version: "1.0.0"},
+		},
+	}}})
+
+	var buffer bytes.Buffer
+	is.NoErr(archive.Write(&buffer))
+	read, err := ReadArchive(&buffer)
+	is.NoErr(err)
+	is.Equal(read, archive)
+
+	_, err = ReadArchive(strings.NewReader(`{"version": 2, "org": "o", "app": "a", "environments": []}`))
+	is.True(err != nil) // unsupported version
+	_, err = ReadArchive(strings.NewReader(`{"version": 1, "org": "o", "app": "a", "environments": [{"org": "x", "app": "a", "env": "e"}]}`))
+	is.True(err != nil) // environment of another app
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = Export(ctx, walhall, "src-org", "src-app", Options{Parallelism: 1})
+	is.True(errors.Is(err, context.Canceled)) // no configurations are fetched
+}
+
+func TestImport(t *testing.T) {
+	is := is.New(t)
+	walhall := newFakeWalhall()
+	archive := Archive{Version: ArchiveVersion, Org: "src-org", App: "src-app", Envs: []Manifest{{
+		Org: "src-org", App: "src-app", Env: "qa",
+		Modules: []Module{
+			{Name: "authentication", Version: "1.1.0", Configs: []Config{{Type: "env", Spec: map[string]interface{}{"LOG_LEVEL": "info"}}}},
+			{Name: "db", Version: "1.0.0"},
+		},
+	}}}
+
+	renamed := archive.Rename(Renames{
+		Org: "my-org", App: "my-app",
+		Envs:    map[string]string{"qa": "dev"},
+		Modules: map[string]string{"authentication": "auth"},
+	})
+	is.Equal(archive.Envs[0].Modules[0].Name, "authentication") // the original is unchanged
+	is.Equal(renamed.Envs[0].Org, "my-org")
+	is.Equal(renamed.Envs[0].Env, "dev")
+
+	plans, err := Import(context.Background(), walhall, renamed, Options{})
+	is.NoErr(err)
+	is.Equal(len(plans), 1)
+	var out bytes.Buffer
+	is.NoErr(plans[0].Write(&out))
+	is.Equal(out.String(), "my-org/my-app/dev:\n  ~ module auth 1.0.0 -> 1.1.0\n  - config auth/secret\n")
+
+	_, err = Import(context.Background(), walhall, archive, Options{})
+	is.True(err != nil) // src-org does not exist
+
+	// Applications and environments cannot be created, so they must exist
+	_, err = Import(context.Background(), missingApps{walhall}, renamed, Options{})
+	is.True(errors.Is(err, walhallapi.ErrNotFound))
+	is.Equal(err.Error(), "application my-org/my-app must exist before importing: not found")
+	_, err = Import(context.Background(), walhall, archive.Rename(Renames{Org: "my-org", App: "my-app"}), Options{})
+	is.True(errors.Is(err, walhallapi.ErrNotFound))
+	is.Equal(err.Error(), "environments qa of my-org/my-app must exist before importing: not found")
+	is.Equal(len(walhall.calls), 0)
+
+	renamed = archive.Rename(Renames{Org: "my-org", App: "my-app", Modules: map[string]string{"authentication": "db"}})
+	_, err = Import(context.Background(), walhall, renamed, Options{})
+	is.True(err != nil) // renaming merged two modules
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	is := is.New(t)
+	auth12 := walhallapi.ModuleVersion{ID: 20, Version: "1.2"}
+	dbLatest := walhallapi.ModuleVersion{ID: 21, Version: "latest"}
+	walhall := taggedWalhall{fakeWalhall: newFakeWalhall(), modules: []walhallapi.Module{
+		{Name: "auth", Versions: []walhallapi.ModuleVersion{auth12, {ID: 22, Version: "1.2.5"}}},
+		{Name: "db", Versions: []walhallapi.ModuleVersion{dbLatest, {ID: 23, Version: "2.0.0"}}},
+	}}
+	walhall.env.ModuleVersions[0].ModuleVersion = auth12
+	walhall.env.ModuleVersions[1].ModuleVersion = dbLatest
+
+	// Tags which look like ranges are imported as exactly the exported builds
+	archive, err := Export(context.Background(), walhall, "my-org", "my-app", Options{})
+	is.NoErr(err)
+	plans, err := Import(context.Background(), walhall, archive, Options{})
+	is.NoErr(err)
+	is.Equal(len(plans), 1)
+	is.Equal(len(plans[0].Changes), 0)
+}
+
+// taggedWalhall is a fakeWalhall with other modules.
+type taggedWalhall struct {
+	*fakeWalhall
+	modules []walhallapi.Module
+}
+
+func (w taggedWalhall) ListModules(orgName string) ([]walhallapi.Module, error) {
+	return w.modules, nil
+}
+
+// missingApps is a Walhall without any applications.
+type missingApps struct {
+	*fakeWalhall
+}
+
+func (missingApps) ListEnvs(orgName, appName string) ([]walhallapi.Environment, error) {
+	return nil, walhallapi.ErrNotFound
+}
@@ -0,0 +1,155 @@
+// Package manifest describes the desired module versions and configurations of a Walhall environment and plans and
+// applies the changes which bring the environment in line with it.
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+	"humanitec.io/walhallapiadaptor/internal/semver"
+)
+
+// Manifest is the desired state of one environment. Modules which are not listed are removed from the environment
+// and configurations which are not listed are deleted.
+//
+// Example:
+//
+//	org: my-org
+//	app: my-app
+//	env: development
+//	modules:
+//	  - name: auth
+//	    version: 1.1.0
+//	    configs:
+//	      - type: env
+//	        spec:
+//	          LOG_LEVEL: debug
+type Manifest struct {
+	Org     string   `yaml:"org" json:"org"`
+	App     string   `yaml:"app" json:"app"`
+	Env     string   `yaml:"env" json:"env"`
+	Modules []Module `yaml:"modules" json:"modules"`
+}
+
+// Module is a module version deployed in the environment.
+type Module struct {
+	Name string `yaml:"name" json:"name"`
+	// Version is a version, or a range like ^1.2 or latest which is resolved to the newest matching version (see
+	// semver.ParseConstraint). A version tagged exactly like it is picked before the range.
+	Version string   `yaml:"version" json:"version"`
+	Configs []Config `yaml:"configs,omitempty" json:"configs,omitempty"`
+}
+
+// Config is a configuration of a module version, identified by its type.
+type Config struct {
+	Type string                 `yaml:"type" json:"type"`
+	Spec map[string]interface{} `yaml:"spec" json:"spec"`
+}
+
+// Load reads a YAML manifest file.
+func Load(path string) (Manifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("read manifest: %w", err)
+	}
+	m, err := Parse(data)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("manifest %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// Parse decodes and validates a YAML manifest.
+func Parse(data []byte) (Manifest, error) {
+	var m Manifest
+	if err := yaml.UnmarshalStrict(data, &m); err != nil {
+		return Manifest{}, err
+	}
+	for i := range m.Modules {
+		for j := range m.Modules[i].Configs {
+			spec, err := jsonValue(m.Modules[i].Configs[j].Spec)
+			if err != nil {
+				return Manifest{}, fmt.Errorf("module %s: config %s: %w",
+					m.Modules[i].Name, m.Modules[i].Configs[j].Type, err)
+			}
+			m.Modules[i].Configs[j].Spec, _ = spec.(map[string]interface{})
+		}
+	}
+	if err := m.Validate(); err != nil {
+		return Manifest{}, err
+	}
+	return m, nil
+}
+
+// Validate checks that m names an environment and lists each module and configuration type at most once.
+func (m Manifest) Validate() error {
+	if m.Org == "" || m.App == "" || m.Env == "" {
+		return errors.New("org, app and env are required")
+	}
+	modules := make(map[string]bool)
+	for _, module := range m.Modules {
+		if module.Name == "" || module.Version == "" {
+			return errors.New("modules need a name and a version")
+		}
+		if _, err := semver.ParseConstraint(module.Version); err != nil {
+			return fmt.Errorf("module %s: %w", module.Name, err)
+		}
+		if modules[module.Name] {
+			return fmt.Errorf("module %s is listed more than once", module.Name)
+		}
+		modules[module.Name] = true
+		types := make(map[string]bool)
+		for _, config := range module.Configs {
+			if config.Type == "" {
+				return fmt.Errorf("module %s: configs need a type", module.Name)
+			}
+			if types[config.Type] {
+				return fmt.Errorf("module %s: config %s is listed more than once", module.Name, config.Type)
+			}
+			types[config.Type] = true
+		}
+	}
+	return nil
+}
+
+// jsonValue converts a value decoded from YAML, whose maps may have keys of any type, into one which can be encoded
+// as JSON.
+func jsonValue(value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			name, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("key %v is not a string", key)
+			}
+			var err error
+			if converted[name], err = jsonValue(item); err != nil {
+				return nil, err
+			}
+		}
+		return converted, nil
+	case map[string]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			var err error
+			if converted[key], err = jsonValue(item); err != nil {
+				return nil, err
+			}
+		}
+		return converted, nil
+	case []interface{}:
+		converted := make([]interface{}, len(value))
+		for i, item := range value {
+			var err error
+			if converted[i], err = jsonValue(item); err != nil {
+				return nil, err
+			}
+		}
+		return converted, nil
+	default:
+		return value, nil
+	}
+}
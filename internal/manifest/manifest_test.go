@@ -0,0 +1,241 @@
+package manifest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+	"humanitec.io/walhallapiadaptor/internal/walhallapi"
+)
+
+const testManifest = `
+org: my-org
+app: my-app
+env: dev
+modules:
+  - name: auth
+    version: 1.1.0
+    configs:
+      - type: env
+        spec:
+          LOG_LEVEL: debug
+          REPLICAS: 2
+          NESTED: {a: [1, {b: c}]}
+  - name: web
+    version: 2.0.0
+    configs:
+      - type: env
+        spec:
+          PORT: 80
+`
+
+func TestParse(t *testing.T) {
+	is := is.New(t)
+	m, err := Parse([]byte(testManifest))
+	is.NoErr(err)
+	is.Equal(m.Org, "my-org")
+	is.Equal(len(m.Modules), 2)
+	spec := m.Modules[0].Configs[0].Spec
+	is.Equal(spec["LOG_LEVEL"], "debug")
+	// Nested maps can be encoded as JSON
+	is.Equal(specJSON(spec), `{"LOG_LEVEL":"debug","NESTED":{"a":[1,{"b":"c"}]},"REPLICAS":2}`)
+
+	for _, invalid := range []string{
+		"org: my-org\napp: my-app\n",
+		"org: o\napp: a\nenv: e\nmodules: [{name: auth}]\n",
+		"org: o\napp: a\nenv: e\nmodules: [{name: auth, version: '1'}, {name: auth, version: '2'}]\n",
+		"org: o\napp: a\nenv: e\nmodules: [{name: auth, version: '1', configs: [{type: env}, {type: env}]}]\n",
+		"org: o\napp: a\nenv: e\nmodule: []\n",
+		"org: o\napp: a\nenv: e\nmodules: [{name: auth, version: '1', configs: [{type: env, spec: {a: {1: x}}}]}]\n",
+	} {
+		_, err := Parse([]byte(invalid))
+		is.True(err != nil) // invalid manifest
+	}
+}
+
+// fakeWalhall serves an environment with auth 1.0.0 and db 1.0.0 deployed and records the changes made to it.
+type fakeWalhall struct {
+	walhallapi.WalhallAPIer
+	env     walhallapi.Environment
+	configs map[int][]walhallapi.Config
+	calls   []string
+	// extra is another version of auth, if set
+	extra walhallapi.ModuleVersion
+}
+
+var (
+	auth100 = walhallapi.ModuleVersion{ID: 1, UUID: "auth-100", Version: "1.0.0"}
+	auth110 = walhallapi.ModuleVersion{ID: 2, UUID: "auth-110", Version: "1.1.0"}
+	web200  = walhallapi.ModuleVersion{ID: 3, UUID: "web-200", Version: "2.0.0"}
+	db100   = walhallapi.ModuleVersion{ID: 4, UUID: "db-100", Version: "1.0.0"}
+)
+
+func newFakeWalhall() *fakeWalhall {
+	f := &fakeWalhall{configs: map[int][]walhallapi.Config{
+		auth110.ID: {
+			{ID: 10, Type: "env", Spec: map[string]interface{}{"LOG_LEVEL": "info"}},
+			{ID: 11, Type: "secret", Spec: map[string]interface{}{}},
+		},
+		web200.ID: {
+			{ID: 12, Type: "env", Spec: map[string]interface{}{"PORT": 80.0}},
+		},
+	}}
+	f.env.UUID = "env-uuid"
+	f.env.Name = "dev"
+	for _, deployed := range []struct {
+		mv   walhallapi.ModuleVersion
+		name string
+	}{{auth100, "auth"}, {db100, "db"}} {
+		f.env.ModuleVersions = append(f.env.ModuleVersions, struct {
+			walhallapi.ModuleVersion
+			Module walhallapi.Module `json:"logic_module"`
+		}{deployed.mv, walhallapi.Module{Name: deployed.name}})
+	}
+	return f
+}
+
+func (f *fakeWalhall) GetEnv(orgName, appName, envName string) (walhallapi.Environment, error) {
+	if orgName != "my-org" || appName != "my-app" || envName != "dev" {
+		return walhallapi.Environment{}, walhallapi.ErrNotFound
+	}
+	return f.env, nil
+}
+
+func (f *fakeWalhall) ListEnvs(orgName, appName string) ([]walhallapi.Environment, error) {
+	return []walhallapi.Environment{f.env}, nil
+}
+
+func (f *fakeWalhall) ListModules(orgName string) ([]walhallapi.Module, error) {
+	auth := []walhallapi.ModuleVersion{auth100, auth110}
+	if f.extra.ID != 0 {
+		auth = append(auth, f.extra)
+	}
+	return []walhallapi.Module{
+		{Name: "auth", Versions: auth},
+		{Name: "web", Versions: []walhallapi.ModuleVersion{web200}},
+		{Name: "db", Versions: []walhallapi.ModuleVersion{db100}},
+	}, nil
+}
+
+func (f *fakeWalhall) GetConfigsForModuleVersionInEnv(env walhallapi.Environment, mv walhallapi.ModuleVersion) ([]walhallapi.Config, error) {
+	return f.configs[mv.ID], nil
+}
+
+func (f *fakeWalhall) PatchEnv(env walhallapi.Environment, moduleVersions []int) (walhallapi.Environment, error) {
+	f.calls = append(f.calls, "patch "+specJSON(map[string]interface{}{"ids": moduleVersions}))
+	return env, nil
+}
+
+func (f *fakeWalhall) CreateConfiguration(env walhallapi.Environment, mv walhallapi.ModuleVersion, configType string) (walhallapi.Config, error) {
+	f.calls = append(f.calls, "create "+mv.UUID+" "+configType)
+	return walhallapi.Config{ID: 20, Type: configType}, nil
+}
+
+func (f *fakeWalhall) UpdateConfiguration(config walhallapi.Config) (walhallapi.Config, error) {
+	f.calls = append(f.calls, "update "+specJSON(map[string]interface{}{"id": config.ID, "spec": config.Spec}))
+	return config, nil
+}
+
+func (f *fakeWalhall) DeleteConfiguration(configID int) error {
+	f.calls = append(f.calls, "delete "+specJSON(map[string]interface{}{"id": configID}))
+	return nil
+}
+
+func TestPlanAndApply(t *testing.T) {
+	is := is.New(t)
+	m, err := Parse([]byte(testManifest))
+	is.NoErr(err)
+	walhall := newFakeWalhall()
+
+	plan, err := NewPlan(context.Background(), walhall, m, Options{})
+	is.NoErr(err)
+	var out bytes.Buffer
+	is.NoErr(plan.Write(&out))
+	is.Equal(out.String(), strings.Join([]string{
+		"my-org/my-app/dev:",
+		"  ~ module auth 1.0.0 -> 1.1.0",
+		"  + module web 2.0.0",
+		"  - module db 1.0.0",
+		`  ~ config auth/env {"LOG_LEVEL":"debug","NESTED":{"a":[1,{"b":"c"}]},"REPLICAS":2}`,
+		"  - config auth/secret",
+		"",
+	}, "\n"))
+	is.True(walhall.calls == nil) // planning changes nothing
+
+	is.NoErr(plan.Apply(walhall))
+	is.Equal(walhall.calls, []string{
+		`patch {"ids":[2,3]}`,
+		`update {"id":10,"spec":{"LOG_LEVEL":"debug","NESTED":{"a":[1,{"b":"c"}]},"REPLICAS":2}}`,
+		`delete {"id":11}`,
+	})
+}
+
+func TestPlanCreatesConfigs(t *testing.T) {
+	is := is.New(t)
+	m, err := Parse([]byte(testManifest))
+	is.NoErr(err)
+	walhall := newFakeWalhall()
+	walhall.configs[auth110.ID] = nil
+
+	plan, err := NewPlan(context.Background(), walhall, m, Options{})
+	is.NoErr(err)
+	walhall.calls = nil
+	is.NoErr(plan.Apply(walhall))
+	is.Equal(walhall.calls[1:], []string{
+		"create auth-110 env",
+		`update {"id":20,"spec":{"LOG_LEVEL":"debug","NESTED":{"a":[1,{"b":"c"}]},"REPLICAS":2}}`,
+	})
+}
+
+func TestPlanUpToDate(t *testing.T) {
+	is := is.New(t)
+	walhall := newFakeWalhall()
+	m := Manifest{Org: "my-org", App: "my-app", Env: "dev", Modules: []Module{
+		{Name: "auth", Version: "1.0.0"},
+		{Name: "db", Version: "1.0.0"},
+	}}
+
+	plan, err := NewPlan(context.Background(), walhall, m, Options{})
+	is.NoErr(err)
+	is.True(plan.Empty())
+	is.NoErr(plan.Apply(walhall))
+	is.True(walhall.calls == nil)
+
+	// Ranges resolve to the newest matching version
+	m.Modules[0].Version = "latest"
+	m.Modules[1].Version = "^1"
+	plan, err = NewPlan(context.Background(), walhall, m, Options{})
+	is.NoErr(err)
+	is.Equal(plan.Changes[0].String(), "~ module auth 1.0.0 -> 1.1.0")
+
+	// The tag policy decides whether other tags are the latest version
+	walhall.extra = walhallapi.ModuleVersion{ID: 5, UUID: "auth-new", Version: "NEW_TAG"}
+	m.Modules[0].Version = "latest"
+	plan, err = NewPlan(context.Background(), walhall, m, Options{TagPolicy: walhallapi.TagsNewest})
+	is.NoErr(err)
+	is.Equal(plan.Changes[0].String(), "~ module auth 1.0.0 -> NEW_TAG")
+	plan, err = NewPlan(context.Background(), walhall, m, Options{})
+	is.NoErr(err)
+	is.Equal(plan.Changes[0].String(), "~ module auth 1.0.0 -> 1.1.0")
+
+	m.Modules[0].Version = "9.9.9"
+	_, err = NewPlan(context.Background(), walhall, m, Options{})
+	is.True(err != nil) // unknown version
+	m.Env = "prod"
+	_, err = NewPlan(context.Background(), walhall, m, Options{})
+	is.True(err != nil) // unknown environment
+}
+
+func TestPlanIsCancelled(t *testing.T) {
+	is := is.New(t)
+	m, err := Parse([]byte(testManifest))
+	is.NoErr(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = NewPlan(ctx, newFakeWalhall(), m, Options{Parallelism: 1})
+	is.True(errors.Is(err, context.Canceled)) // no configurations are fetched
+}
@@ -0,0 +1,289 @@
+package manifest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+
+	"humanitec.io/walhallapiadaptor/internal/semver"
+	"humanitec.io/walhallapiadaptor/internal/walhallapi"
+)
+
+// Action is what a Change does.
+type Action string
+
+const (
+	AddModule    Action = "add-module"
+	ChangeModule Action = "change-module"
+	RemoveModule Action = "remove-module"
+	CreateConfig Action = "create-config"
+	UpdateConfig Action = "update-config"
+	DeleteConfig Action = "delete-config"
+)
+
+// Change is one difference between an environment and its manifest.
+type Change struct {
+	Action Action `json:"action"`
+	Module string `json:"module"`
+	// From and To are the module versions before and after the change
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	// ConfigType and Spec describe the configuration for config changes
+	ConfigType string                 `json:"config_type,omitempty"`
+	Spec       map[string]interface{} `json:"spec,omitempty"`
+
+	moduleVersion walhallapi.ModuleVersion
+	config        walhallapi.Config
+}
+
+func (c Change) String() string {
+	switch c.Action {
+	case AddModule:
+		return fmt.Sprintf("+ module %s %s", c.Module, c.To)
+	case ChangeModule:
+		return fmt.Sprintf("~ module %s %s -> %s", c.Module, c.From, c.To)
+	case RemoveModule:
+		return fmt.Sprintf("- module %s %s", c.Module, c.From)
+	case CreateConfig:
+		return fmt.Sprintf("+ config %s/%s %s", c.Module, c.ConfigType, specJSON(c.Spec))
+	case UpdateConfig:
+		return fmt.Sprintf("~ config %s/%s %s", c.Module, c.ConfigType, specJSON(c.Spec))
+	case DeleteConfig:
+		return fmt.Sprintf("- config %s/%s", c.Module, c.ConfigType)
+	}
+	return string(c.Action)
+}
+
+// Plan is the set of changes which make an environment match its manifest.
+type Plan struct {
+	Org     string   `json:"org"`
+	App     string   `json:"app"`
+	Env     string   `json:"env"`
+	Changes []Change `json:"changes"`
+
+	env walhallapi.Environment
+	// moduleVersions is the argument to PatchEnv, or nil if the module versions do not change
+	moduleVersions []int
+}
+
+// Options tune how NewPlan, Export and Import call Walhall. The zero value is valid.
+type Options struct {
+	// Parallelism is how many calls to Walhall are made at once when fetching many configurations. Zero means
+	// walhallapi.DefaultParallelism.
+	Parallelism int
+	// TagPolicy orders the versions of modules when resolving ranges like latest. Empty means walhallapi.TagsOldest.
+	TagPolicy walhallapi.TagPolicy
+}
+
+func (o Options) parallelism() int {
+	if o.Parallelism < 1 {
+		return walhallapi.DefaultParallelism
+	}
+	return o.Parallelism
+}
+
+// NewPlan compares the environment named in m with m. The module versions in m are looked up with ListModules and
+// the configurations of each module version in the environment with GetConfigsForModuleVersionInEnv, which stop
+// once ctx is done.
+func NewPlan(ctx context.Context, walhall walhallapi.WalhallAPIer, m Manifest, opts Options) (*Plan, error) {
+	env, err := walhall.GetEnv(m.Org, m.App, m.Env)
+	if err != nil {
+		return nil, fmt.Errorf("get environment %s: %w", m.Env, err)
+	}
+	desired, err := moduleVersions(walhall, m, opts)
+	if err != nil {
+		return nil, err
+	}
+	plan := &Plan{Org: m.Org, App: m.App, Env: m.Env, Changes: []Change{}, env: env}
+
+	current := make(map[string]walhallapi.ModuleVersion)
+	for _, mv := range env.ModuleVersions {
+		current[mv.Module.Name] = mv.ModuleVersion
+	}
+	listed := make(map[string]bool)
+	var ids []int
+	for i, module := range m.Modules {
+		listed[module.Name] = true
+		ids = append(ids, desired[i].ID)
+		mv, ok := current[module.Name]
+		switch {
+		case !ok:
+			plan.Changes = append(plan.Changes, Change{Action: AddModule, Module: module.Name, To: desired[i].Version})
+		case mv.ID != desired[i].ID:
+			plan.Changes = append(plan.Changes, Change{
+				Action: ChangeModule, Module: module.Name, From: mv.Version, To: desired[i].Version,
+			})
+		}
+	}
+	for _, mv := range env.ModuleVersions {
+		if !listed[mv.Module.Name] {
+			plan.Changes = append(plan.Changes, Change{Action: RemoveModule, Module: mv.Module.Name, From: mv.Version})
+		}
+	}
+	if len(plan.Changes) > 0 {
+		plan.moduleVersions = ids
+		if plan.moduleVersions == nil {
+			plan.moduleVersions = []int{}
+		}
+	}
+
+	// The configurations are looked up for the desired module versions, whether or not they are in the
+	// environment yet
+	configs := make([][]walhallapi.Config, len(m.Modules))
+	err = walhallapi.FanOut(ctx, len(m.Modules), opts.parallelism(),
+		func(ctx context.Context, i int) error {
+			var err error
+			configs[i], err = walhall.GetConfigsForModuleVersionInEnv(env, desired[i])
+			return err
+		})
+	if err != nil {
+		return nil, fmt.Errorf("get configs: %w", err)
+	}
+	for i, module := range m.Modules {
+		plan.Changes = append(plan.Changes, configChanges(module, desired[i], configs[i])...)
+	}
+	return plan, nil
+}
+
+// moduleVersions resolves the version of each module in m.
+func moduleVersions(walhall walhallapi.WalhallAPIer, m Manifest, opts Options) ([]walhallapi.ModuleVersion, error) {
+	resolver := walhallapi.NewResolver(walhall, m.Org)
+	if opts.TagPolicy != "" {
+		resolver.SetTagPolicy(opts.TagPolicy)
+	}
+	versions := make([]walhallapi.ModuleVersion, len(m.Modules))
+	for i, module := range m.Modules {
+		c, err := semver.ParseConstraint(module.Version)
+		if err != nil {
+			return nil, fmt.Errorf("module %s: %w", module.Name, err)
+		}
+		if versions[i], err = resolver.Resolve(module.Name, c); err != nil {
+			return nil, err
+		}
+	}
+	return versions, nil
+}
+
+// configChanges compares the configurations of module with those of mv in the environment. Configurations are
+// matched by type.
+func configChanges(module Module, mv walhallapi.ModuleVersion, current []walhallapi.Config) []Change {
+	var changes []Change
+	existing := make(map[string]walhallapi.Config)
+	for _, config := range current {
+		if _, ok := existing[config.Type]; ok {
+			// Only one configuration of each type is kept
+			changes = append(changes, Change{
+				Action: DeleteConfig, Module: module.Name, ConfigType: config.Type, config: config,
+			})
+			continue
+		}
+		existing[config.Type] = config
+	}
+	for _, config := range module.Configs {
+		change := Change{
+			Module: module.Name, ConfigType: config.Type, Spec: config.Spec, moduleVersion: mv,
+		}
+		found, ok := existing[config.Type]
+		delete(existing, config.Type)
+		switch {
+		case !ok:
+			change.Action = CreateConfig
+		case !specEqual(found.Spec, config.Spec):
+			change.Action = UpdateConfig
+			change.config = found
+		default:
+			continue
+		}
+		changes = append(changes, change)
+	}
+	for _, config := range current {
+		if found, ok := existing[config.Type]; ok && found.ID == config.ID {
+			changes = append(changes, Change{
+				Action: DeleteConfig, Module: module.Name, ConfigType: config.Type, config: config,
+			})
+		}
+	}
+	return changes
+}
+
+// Empty reports whether the environment already matches the manifest.
+func (p *Plan) Empty() bool {
+	return len(p.Changes) == 0
+}
+
+// Write prints the changes, one per line.
+func (p *Plan) Write(w io.Writer) error {
+	if p.Empty() {
+		_, err := fmt.Fprintf(w, "%s/%s/%s is up to date\n", p.Org, p.App, p.Env)
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "%s/%s/%s:\n", p.Org, p.App, p.Env); err != nil {
+		return err
+	}
+	for _, change := range p.Changes {
+		if _, err := fmt.Fprintf(w, "  %s\n", change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Apply makes the changes: the module versions are set with one call to PatchEnv, then configurations are created,
+// updated and deleted. It stops at the first change which fails.
+func (p *Plan) Apply(walhall walhallapi.WalhallAPIer) error {
+	env := p.env
+	if p.moduleVersions != nil {
+		var err error
+		if env, err = walhall.PatchEnv(env, p.moduleVersions); err != nil {
+			return err
+		}
+	}
+	for _, change := range p.Changes {
+		var err error
+		switch change.Action {
+		case CreateConfig:
+			var config walhallapi.Config
+			config, err = walhall.CreateConfiguration(env, change.moduleVersion, change.ConfigType)
+			if err == nil {
+				config.Spec = change.Spec
+				_, err = walhall.UpdateConfiguration(config)
+			}
+		case UpdateConfig:
+			config := change.config
+			config.Spec = change.Spec
+			_, err = walhall.UpdateConfiguration(config)
+		case DeleteConfig:
+			err = walhall.DeleteConfiguration(change.config.ID)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", change, err)
+		}
+	}
+	return nil
+}
+
+// specEqual compares specifications by their JSON encoding, so that numbers decoded from YAML and JSON compare
+// equal.
+func specEqual(a, b map[string]interface{}) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	var decodedA, decodedB interface{}
+	if json.Unmarshal([]byte(specJSON(a)), &decodedA) != nil || json.Unmarshal([]byte(specJSON(b)), &decodedB) != nil {
+		return false
+	}
+	return reflect.DeepEqual(decodedA, decodedB)
+}
+
+func specJSON(spec map[string]interface{}) string {
+	if spec == nil {
+		return "{}"
+	}
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return fmt.Sprintf("%v", spec)
+	}
+	return string(data)
+}
@@ -0,0 +1,311 @@
+// Package metrics implements the counters, gauges and histograms the adaptor exposes, rendered in the Prometheus
+// text exposition format (version 0.0.4).
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are histogram buckets (in seconds) suited to HTTP latencies.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds a set of metrics which are rendered together.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Default is the registry served by the adaptor's /metrics endpoint.
+var Default = NewRegistry()
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.metrics {
+		if existing.name() == m.name() {
+			panic(fmt.Sprintf("metrics: %s registered twice", m.name()))
+		}
+	}
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteText renders every metric in the registry in the Prometheus text format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	metrics := append([]metric{}, r.metrics...)
+	r.mu.Unlock()
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name() < metrics[j].name() })
+
+	buffered := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(buffered)
+	}
+	return buffered.Flush()
+}
+
+// Handler serves the registry in the Prometheus text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
+
+// desc holds what every metric family has in common.
+type desc struct {
+	metricName string
+	help       string
+	labels     []string
+}
+
+func (d desc) name() string {
+	return d.metricName
+}
+
+func (d desc) writeHeader(w *bufio.Writer, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.metricName, strings.Replace(d.help, "\n", " ", -1))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.metricName, metricType)
+}
+
+func (d desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", d.metricName, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// labelString renders label pairs, e.g. {route="/orgs",status="200"}. extra is appended as is.
+func labelString(names, values []string, extra string) string {
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, escapeLabel(values[i])))
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// sortedKeys returns the keys of a series map in a stable order.
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// CounterVec is a set of monotonically increasing counters partitioned by label values.
+type CounterVec struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+	labels map[string][]string
+}
+
+// NewCounterVec registers a new counter family.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		desc:   desc{metricName: name, help: help, labels: labels},
+		values: make(map[string]float64),
+		labels: make(map[string][]string),
+	}
+	r.register(c)
+	return c
+}
+
+// Add increases the counter for the label values by delta, which must not be negative.
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("metrics: counters cannot decrease")
+	}
+	key := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.labels[key]; !ok {
+		c.labels[key] = append([]string{}, labelValues...)
+	}
+	c.values[key] += delta
+}
+
+// Inc increases the counter for the label values by one.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Value returns the current value of the counter for the label values.
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	key := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[key]
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.writeHeader(w, "counter")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range sortedKeys(c.labels) {
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName, labelString(c.desc.labels, c.labels[key], ""), formatFloat(c.values[key]))
+	}
+}
+
+// Gauge is a single value which can go up and down.
+type Gauge struct {
+	desc
+	mu    sync.Mutex
+	value float64
+}
+
+// NewGauge registers a new gauge.
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	g := &Gauge{desc: desc{metricName: name, help: help}}
+	r.register(g)
+	return g
+}
+
+// Add changes the gauge by delta.
+func (g *Gauge) Add(delta float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.value += delta
+}
+
+// Inc increases the gauge by one.
+func (g *Gauge) Inc() { g.Add(1) }
+
+// Dec decreases the gauge by one.
+func (g *Gauge) Dec() { g.Add(-1) }
+
+// Value returns the current value of the gauge.
+func (g *Gauge) Value() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.value
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.writeHeader(w, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.metricName, formatFloat(g.Value()))
+}
+
+// HistogramVec is a set of histograms partitioned by label values.
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogram
+	labels  map[string][]string
+}
+
+type histogram struct {
+	counts []uint64 // cumulative counts are computed when rendering
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec registers a new histogram family. buckets are upper bounds in increasing order.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("metrics: buckets for %s are not sorted", name))
+	}
+	h := &HistogramVec{
+		desc:    desc{metricName: name, help: help, labels: labels},
+		buckets: buckets,
+		series:  make(map[string]*histogram),
+		labels:  make(map[string][]string),
+	}
+	r.register(h)
+	return h
+}
+
+// Observe records a value for the label values.
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = series
+		h.labels[key] = append([]string{}, labelValues...)
+	}
+	for i, upper := range h.buckets {
+		if value <= upper {
+			series.counts[i]++
+			break
+		}
+	}
+	series.count++
+	series.sum += value
+}
+
+// Count returns the number of observations for the label values.
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if series, ok := h.series[key]; ok {
+		return series.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.writeHeader(w, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.labels) {
+		series := h.series[key]
+		values := h.labels[key]
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += series.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName,
+				labelString(h.desc.labels, values, fmt.Sprintf(`le="%s"`, formatFloat(upper))), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, labelString(h.desc.labels, values, `le="+Inf"`), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, labelString(h.desc.labels, values, ""), formatFloat(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, labelString(h.desc.labels, values, ""), series.count)
+	}
+}
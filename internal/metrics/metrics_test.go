@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestWriteText(t *testing.T) {
+	is := is.New(t)
+	r := NewRegistry()
+	requests := r.NewCounterVec("test_requests_total", "Requests.", "route", "status")
+	inFlight := r.NewGauge("test_in_flight", "In flight.")
+	latency := r.NewHistogramVec("test_duration_seconds", "Latency.", []float64{0.1, 1}, "route")
+
+	requests.Inc("/orgs", "200")
+	requests.Add(2, "/orgs/{orgId}/modules", "500")
+	inFlight.Inc()
+	latency.Observe(0.05, "/orgs")
+	latency.Observe(0.5, "/orgs")
+	latency.Observe(3, "/orgs")
+
+	var buf bytes.Buffer
+	is.NoErr(r.WriteText(&buf))
+	is.Equal(buf.String(), `# HELP test_duration_seconds Latency.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{route="/orgs",le="0.1"} 1
+test_duration_seconds_bucket{route="/orgs",le="1"} 2
+test_duration_seconds_bucket{route="/orgs",le="+Inf"} 3
+test_duration_seconds_sum{route="/orgs"} 3.55
+test_duration_seconds_count{route="/orgs"} 3
+# HELP test_in_flight In flight.
+# TYPE test_in_flight gauge
+test_in_flight 1
+# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{route="/orgs/{orgId}/modules",status="500"} 2
+test_requests_total{route="/orgs",status="200"} 1
+`)
+}
+
+func TestLabelEscaping(t *testing.T) {
+	is := is.New(t)
+	r := NewRegistry()
+	r.NewCounterVec("test_total", "Test.", "path").Inc("a\"b\\c\nd")
+
+	var buf bytes.Buffer
+	is.NoErr(r.WriteText(&buf))
+	is.True(bytes.Contains(buf.Bytes(), []byte(`test_total{path="a\"b\\c\nd"} 1`)))
+}
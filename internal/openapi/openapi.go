@@ -0,0 +1,247 @@
+// Package openapi validates requests against the subset of an OpenAPI 3 document the adaptor uses: path, query and
+// header parameters and JSON request bodies, with schemas made of types, enums, patterns, lengths, ranges, required
+// and additional properties, items and references to components.
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Schema is a JSON schema as used by OpenAPI 3.0.
+type Schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Nullable             bool               `json:"nullable"`
+	Enum                 []interface{}      `json:"enum"`
+	Pattern              string             `json:"pattern"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	MinItems             *int               `json:"minItems"`
+	MaxItems             *int               `json:"maxItems"`
+	Required             []string           `json:"required"`
+	Properties           map[string]*Schema `json:"properties"`
+	AdditionalProperties json.RawMessage    `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+
+	pattern    *regexp.Regexp
+	additional *Schema // nil if any additional property is allowed
+	closed     bool    // additionalProperties: false
+}
+
+// Parameter is a path, query or header parameter.
+type Parameter struct {
+	Ref      string  `json:"$ref"`
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+type mediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type requestBody struct {
+	Ref      string               `json:"$ref"`
+	Required bool                 `json:"required"`
+	Content  map[string]mediaType `json:"content"`
+}
+
+type operation struct {
+	Parameters  []*Parameter `json:"parameters"`
+	RequestBody *requestBody `json:"requestBody"`
+}
+
+type document struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas       map[string]*Schema      `json:"schemas"`
+		Parameters    map[string]*Parameter   `json:"parameters"`
+		RequestBodies map[string]*requestBody `json:"requestBodies"`
+	} `json:"components"`
+}
+
+// Operation is what a request to one method and path template must satisfy.
+type Operation struct {
+	// Parameters are those of the path item and the operation, with references resolved.
+	Parameters []*Parameter
+	// Body is the schema of a JSON request body, or nil if the operation takes none.
+	Body         *Schema
+	BodyRequired bool
+}
+
+// Document is a parsed OpenAPI document.
+type Document struct {
+	operations map[string]*Operation
+}
+
+var methods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// Parse parses an OpenAPI 3 document in JSON, resolves its references and compiles its patterns.
+func Parse(data []byte) (*Document, error) {
+	var doc document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse openapi document: %w", err)
+	}
+	r := resolver{doc: &doc, done: make(map[*Schema]bool)}
+
+	d := &Document{operations: make(map[string]*Operation)}
+	for path, item := range doc.Paths {
+		var shared []*Parameter
+		if raw, ok := item["parameters"]; ok {
+			if err := json.Unmarshal(raw, &shared); err != nil {
+				return nil, fmt.Errorf("parse parameters of %s: %w", path, err)
+			}
+		}
+		for _, method := range methods {
+			raw, ok := item[method]
+			if !ok {
+				continue
+			}
+			var op operation
+			if err := json.Unmarshal(raw, &op); err != nil {
+				return nil, fmt.Errorf("parse %s %s: %w", strings.ToUpper(method), path, err)
+			}
+			resolved, err := r.operation(shared, op)
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: %w", strings.ToUpper(method), path, err)
+			}
+			d.operations[key(method, path)] = resolved
+		}
+	}
+	return d, nil
+}
+
+// MustParse is like Parse but panics if the document is invalid.
+func MustParse(data []byte) *Document {
+	d, err := Parse(data)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// Operation returns the operation for a method and path template, or nil if the document does not describe it.
+func (d *Document) Operation(method, pathTemplate string) *Operation {
+	return d.operations[key(method, pathTemplate)]
+}
+
+func key(method, path string) string {
+	return strings.ToUpper(method) + " " + path
+}
+
+// resolver replaces references to components with the components themselves.
+type resolver struct {
+	doc  *document
+	done map[*Schema]bool
+}
+
+func (r resolver) operation(shared []*Parameter, op operation) (*Operation, error) {
+	resolved := &Operation{}
+	// Parameters of the operation override those of the path item with the same name and location
+	params := make(map[string]int)
+	for _, p := range append(shared, op.Parameters...) {
+		p, err := r.parameter(p)
+		if err != nil {
+			return nil, err
+		}
+		if i, ok := params[p.In+" "+p.Name]; ok {
+			resolved.Parameters[i] = p
+			continue
+		}
+		params[p.In+" "+p.Name] = len(resolved.Parameters)
+		resolved.Parameters = append(resolved.Parameters, p)
+	}
+
+	if body := op.RequestBody; body != nil {
+		if body.Ref != "" {
+			name := strings.TrimPrefix(body.Ref, "#/components/requestBodies/")
+			if body = r.doc.Components.RequestBodies[name]; body == nil {
+				return nil, fmt.Errorf("unknown request body %q", op.RequestBody.Ref)
+			}
+		}
+		content, ok := body.Content["application/json"]
+		if !ok {
+			return nil, fmt.Errorf("request body is not application/json")
+		}
+		schema, err := r.schema(content.Schema)
+		if err != nil {
+			return nil, err
+		}
+		resolved.Body = schema
+		resolved.BodyRequired = body.Required
+	}
+	return resolved, nil
+}
+
+func (r resolver) parameter(p *Parameter) (*Parameter, error) {
+	if p.Ref != "" {
+		name := strings.TrimPrefix(p.Ref, "#/components/parameters/")
+		ref := p.Ref
+		if p = r.doc.Components.Parameters[name]; p == nil {
+			return nil, fmt.Errorf("unknown parameter %q", ref)
+		}
+	}
+	schema, err := r.schema(p.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("parameter %s: %w", p.Name, err)
+	}
+	p.Schema = schema
+	return p, nil
+}
+
+// schema resolves a schema and everything it contains. Resolved schemas are shared, so recursive schemas are fine.
+func (r resolver) schema(s *Schema) (*Schema, error) {
+	if s == nil {
+		return &Schema{}, nil
+	}
+	if s.Ref != "" {
+		name := strings.TrimPrefix(s.Ref, "#/components/schemas/")
+		target := r.doc.Components.Schemas[name]
+		if target == nil {
+			return nil, fmt.Errorf("unknown schema %q", s.Ref)
+		}
+		s = target
+	}
+	if r.done[s] {
+		return s, nil
+	}
+	r.done[s] = true
+
+	var err error
+	if s.Pattern != "" {
+		if s.pattern, err = regexp.Compile(s.Pattern); err != nil {
+			return nil, fmt.Errorf("invalid pattern: %w", err)
+		}
+	}
+	for name, property := range s.Properties {
+		if s.Properties[name], err = r.schema(property); err != nil {
+			return nil, fmt.Errorf("property %s: %w", name, err)
+		}
+	}
+	if s.Items != nil {
+		if s.Items, err = r.schema(s.Items); err != nil {
+			return nil, fmt.Errorf("items: %w", err)
+		}
+	}
+	if len(s.AdditionalProperties) > 0 {
+		var allowed bool
+		if json.Unmarshal(s.AdditionalProperties, &allowed) == nil {
+			s.closed = !allowed
+		} else {
+			var additional Schema
+			if err := json.Unmarshal(s.AdditionalProperties, &additional); err != nil {
+				return nil, fmt.Errorf("additionalProperties: %w", err)
+			}
+			if s.additional, err = r.schema(&additional); err != nil {
+				return nil, fmt.Errorf("additionalProperties: %w", err)
+			}
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,171 @@
+package openapi
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+const testSpec = `{
+  "openapi": "3.0.3",
+  "paths": {
+    "/orgs/{orgId}/apps": {
+      "parameters": [{"$ref": "#/components/parameters/orgId"}],
+      "get": {
+        "parameters": [
+          {"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 1, "maximum": 100}},
+          {"name": "archived", "in": "query", "schema": {"type": "boolean"}}
+        ]
+      },
+      "post": {
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/App"}}}
+        }
+      }
+    }
+  },
+  "components": {
+    "parameters": {
+      "orgId": {"name": "orgId", "in": "path", "required": true, "schema": {"type": "string", "pattern": "^[a-z0-9-]+$", "maxLength": 10}}
+    },
+    "schemas": {
+      "App": {
+        "type": "object",
+        "required": ["name", "envs"],
+        "additionalProperties": false,
+        "properties": {
+          "name": {"type": "string", "minLength": 1},
+          "envs": {"type": "array", "minItems": 1, "items": {"$ref": "#/components/schemas/Env"}}
+        }
+      },
+      "Env": {
+        "type": "object",
+        "required": ["name"],
+        "properties": {
+          "name": {"type": "string"},
+          "type": {"type": "string", "enum": ["development", "production"]},
+          "replicas": {"type": "integer", "minimum": 0}
+        }
+      }
+    }
+  }
+}`
+
+func validate(t *testing.T, method, url, body string, pathParams map[string]string) []FieldError {
+	doc, err := Parse([]byte(testSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	op := doc.Operation(method, "/orgs/{orgId}/apps")
+	if op == nil {
+		t.Fatalf("no operation for %s", method)
+	}
+	req, _ := http.NewRequest(method, url, nil)
+	if body != "" {
+		req, _ = http.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	}
+	err = op.Validate(req, pathParams)
+	if err == nil {
+		return nil
+	}
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatal(err)
+	}
+	return invalid.Errors
+}
+
+func TestValidateParameters(t *testing.T) {
+	for name, tc := range map[string]struct {
+		url      string
+		orgID    string
+		expected []FieldError
+	}{
+		"valid":         {"/orgs/my-org/apps?limit=10&archived=true", "my-org", nil},
+		"no query":      {"/orgs/my-org/apps", "my-org", nil},
+		"bad org":       {"/orgs/My_Org/apps", "My_Org", []FieldError{{"path", "orgId", "must match ^[a-z0-9-]+$"}}},
+		"long org":      {"/orgs/my-very-long-org/apps", "my-very-long-org", []FieldError{{"path", "orgId", "must be at most 10 characters long"}}},
+		"missing org":   {"/orgs//apps", "", []FieldError{{"path", "orgId", "is required"}}},
+		"not a number":  {"/orgs/my-org/apps?limit=ten", "my-org", []FieldError{{"query", "limit", "must be an integer"}}},
+		"out of range":  {"/orgs/my-org/apps?limit=0", "my-org", []FieldError{{"query", "limit", "must be at least 1"}}},
+		"not integer":   {"/orgs/my-org/apps?limit=1.5", "my-org", []FieldError{{"query", "limit", "must be an integer"}}},
+		"not a boolean": {"/orgs/my-org/apps?archived=maybe", "my-org", []FieldError{{"query", "archived", "must be a boolean"}}},
+		"repeated":      {"/orgs/my-org/apps?limit=1&limit=2", "my-org", []FieldError{{"query", "limit", "must be given once"}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(validate(t, http.MethodGet, tc.url, "", map[string]string{"orgId": tc.orgID}), tc.expected)
+		})
+	}
+}
+
+func TestValidateBody(t *testing.T) {
+	for name, tc := range map[string]struct {
+		body     string
+		expected []FieldError
+	}{
+		"valid":         {`{"name": "app", "envs": [{"name": "dev", "type": "development", "replicas": 2}]}`, nil},
+		"missing":       {"", []FieldError{{"body", "", "is required"}}},
+		"invalid JSON":  {`{"name": `, []FieldError{{"body", "", "is not valid JSON: unexpected EOF"}}},
+		"not an object": {`[]`, []FieldError{{"body", "", "must be an object"}}},
+		"fields": {`{"name": "", "envs": [{"type": "staging", "replicas": -1}, {"name": 1}], "owner": "me"}`, []FieldError{
+			{"body", "envs[0].name", "is required"},
+			{"body", "envs[0].replicas", "must be at least 0"},
+			{"body", "envs[0].type", "must be one of development, production"},
+			{"body", "envs[1].name", "must be a string"},
+			{"body", "name", "must be at least 1 characters long"},
+			{"body", "owner", "is not allowed"},
+		}},
+		"required": {`{}`, []FieldError{{"body", "name", "is required"}, {"body", "envs", "is required"}}},
+		"null":     {`{"name": null, "envs": []}`, []FieldError{{"body", "envs", "must have at least 1 items"}, {"body", "name", "must not be null"}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(validate(t, http.MethodPost, "/orgs/my-org/apps", tc.body, map[string]string{"orgId": "my-org"}), tc.expected)
+		})
+	}
+}
+
+func TestValidateKeepsBody(t *testing.T) {
+	is := is.New(t)
+	doc := MustParse([]byte(testSpec))
+	body := `{"name": "app", "envs": [{"name": "dev"}]}`
+	req, _ := http.NewRequest(http.MethodPost, "/orgs/my-org/apps", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	is.NoErr(doc.Operation(http.MethodPost, "/orgs/{orgId}/apps").Validate(req, map[string]string{"orgId": "my-org"}))
+	read, err := ioutil.ReadAll(req.Body)
+	is.NoErr(err)
+	is.Equal(string(read), body) // the handler can still read the body
+}
+
+func TestValidateContentType(t *testing.T) {
+	is := is.New(t)
+	doc := MustParse([]byte(testSpec))
+	req, _ := http.NewRequest(http.MethodPost, "/orgs/my-org/apps", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "text/plain")
+	err := doc.Operation(http.MethodPost, "/orgs/{orgId}/apps").Validate(req, map[string]string{"orgId": "my-org"})
+	var invalid *ValidationError
+	is.True(errors.As(err, &invalid))
+	is.Equal(invalid.Errors, []FieldError{{"header", "Content-Type", "must be application/json"}})
+}
+
+func TestParseRejectsInvalidDocuments(t *testing.T) {
+	for name, spec := range map[string]string{
+		"unknown ref":     `{"paths": {"/a": {"get": {"parameters": [{"$ref": "#/components/parameters/missing"}]}}}}`,
+		"invalid pattern": `{"paths": {"/a": {"get": {"parameters": [{"name": "a", "in": "query", "schema": {"type": "string", "pattern": "("}}]}}}}`,
+		"not JSON body":   `{"paths": {"/a": {"post": {"requestBody": {"content": {"text/plain": {}}}}}}}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse([]byte(spec))
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
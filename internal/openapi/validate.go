@@ -0,0 +1,299 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxBodySize is the largest request body which is validated. Larger bodies are rejected.
+const MaxBodySize = 1 << 20
+
+// FieldError describes one invalid part of a request.
+type FieldError struct {
+	// In is where the field is: "path", "query", "header" or "body".
+	In string `json:"in"`
+	// Field is the parameter name, or the path of the value within the body such as "modules[0].name". It is empty
+	// if the body as a whole is invalid.
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists everything wrong with a request.
+type ValidationError struct {
+	Errors []FieldError `json:"errors"`
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, f := range e.Errors {
+		messages[i] = fmt.Sprintf("%s %s: %s", f.In, f.Field, f.Message)
+	}
+	return "invalid request: " + strings.Join(messages, "; ")
+}
+
+// Validate checks a request's parameters and body against the operation. pathParams are the values matched from the
+// path template. A body which is validated is replaced, so handlers can still read it. The error is a
+// *ValidationError if the request is invalid.
+func (o *Operation) Validate(r *http.Request, pathParams map[string]string) error {
+	v := &validator{}
+	for _, p := range o.Parameters {
+		var values []string
+		switch p.In {
+		case "path":
+			if value, ok := pathParams[p.Name]; ok {
+				values = []string{value}
+			}
+		case "query":
+			values = r.URL.Query()[p.Name]
+		case "header":
+			values = r.Header[http.CanonicalHeaderKey(p.Name)]
+		default:
+			continue
+		}
+		v.parameter(p, values)
+	}
+	if o.Body != nil {
+		if err := v.body(r, o.Body, o.BodyRequired); err != nil {
+			return err
+		}
+	}
+
+	if len(v.errors) == 0 {
+		return nil
+	}
+	return &ValidationError{Errors: v.errors}
+}
+
+type validator struct {
+	in     string
+	errors []FieldError
+}
+
+func (v *validator) fail(field, format string, args ...interface{}) {
+	v.errors = append(v.errors, FieldError{In: v.in, Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) parameter(p *Parameter, values []string) {
+	v.in = p.In
+	if len(values) == 0 || (p.In == "path" && values[0] == "") {
+		if p.Required {
+			v.fail(p.Name, "is required")
+		}
+		return
+	}
+	if p.Schema.Type == "array" {
+		items := make([]interface{}, len(values))
+		for i, value := range values {
+			items[i] = p.Schema.Items.convert(value)
+		}
+		v.value(p.Name, items, p.Schema)
+		return
+	}
+	if len(values) > 1 {
+		v.fail(p.Name, "must be given once")
+		return
+	}
+	v.value(p.Name, p.Schema.convert(values[0]), p.Schema)
+}
+
+// convert parses a parameter as the schema's type, so it can be validated like a value from a JSON body. Values which
+// do not parse are returned as strings and fail validation.
+func (s *Schema) convert(value string) interface{} {
+	if s == nil {
+		return value
+	}
+	switch s.Type {
+	case "integer", "number":
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			return json.Number(value)
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+func (v *validator) body(r *http.Request, schema *Schema, required bool) error {
+	v.in = "body"
+	if r.Body == nil || r.Body == http.NoBody {
+		if required {
+			v.fail("", "is required")
+		}
+		return nil
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, MaxBodySize+1))
+	r.Body.Close()
+	if err != nil {
+		return fmt.Errorf("read request body: %w", err)
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(data))
+	if len(data) == 0 {
+		if required {
+			v.fail("", "is required")
+		}
+		return nil
+	}
+	if len(data) > MaxBodySize {
+		v.fail("", "must not be larger than %d bytes", MaxBodySize)
+		return nil
+	}
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		v.in = "header"
+		v.fail("Content-Type", "must be application/json")
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		v.fail("", "is not valid JSON: %v", err)
+		return nil
+	}
+	if decoder.More() {
+		v.fail("", "must contain a single JSON value")
+		return nil
+	}
+	v.value("", value, schema)
+	return nil
+}
+
+func join(field, property string) string {
+	if field == "" {
+		return property
+	}
+	return field + "." + property
+}
+
+// value validates a value decoded from JSON against a schema.
+func (v *validator) value(field string, value interface{}, s *Schema) {
+	if value == nil {
+		if !s.Nullable && s.Type != "" {
+			v.fail(field, "must not be null")
+		}
+		return
+	}
+	if len(s.Enum) > 0 && !inEnum(value, s.Enum) {
+		allowed := make([]string, len(s.Enum))
+		for i, e := range s.Enum {
+			allowed[i] = fmt.Sprint(e)
+		}
+		v.fail(field, "must be one of %s", strings.Join(allowed, ", "))
+		return
+	}
+
+	switch s.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			v.fail(field, "must be an object")
+			return
+		}
+		v.object(field, object, s)
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			v.fail(field, "must be an array")
+			return
+		}
+		if s.MinItems != nil && len(items) < *s.MinItems {
+			v.fail(field, "must have at least %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(items) > *s.MaxItems {
+			v.fail(field, "must have at most %d items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range items {
+				v.value(fmt.Sprintf("%s[%d]", field, i), item, s.Items)
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			v.fail(field, "must be a string")
+			return
+		}
+		length := utf8.RuneCountInString(str)
+		if s.MinLength != nil && length < *s.MinLength {
+			v.fail(field, "must be at least %d characters long", *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			v.fail(field, "must be at most %d characters long", *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(str) {
+			v.fail(field, "must match %s", s.Pattern)
+		}
+	case "integer", "number":
+		expected := "a number"
+		if s.Type == "integer" {
+			expected = "an integer"
+		}
+		number, ok := value.(json.Number)
+		if !ok {
+			v.fail(field, "must be %s", expected)
+			return
+		}
+		f, err := number.Float64()
+		if err != nil {
+			v.fail(field, "must be %s", expected)
+			return
+		}
+		if _, err := number.Int64(); s.Type == "integer" && err != nil {
+			v.fail(field, "must be %s", expected)
+			return
+		}
+		if s.Minimum != nil && f < *s.Minimum {
+			v.fail(field, "must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			v.fail(field, "must be at most %v", *s.Maximum)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			v.fail(field, "must be a boolean")
+		}
+	}
+}
+
+func (v *validator) object(field string, object map[string]interface{}, s *Schema) {
+	for _, name := range s.Required {
+		if _, ok := object[name]; !ok {
+			v.fail(join(field, name), "is required")
+		}
+	}
+	// Sorted so that the errors are in a stable order
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if property, ok := s.Properties[name]; ok {
+			v.value(join(field, name), object[name], property)
+		} else if s.additional != nil {
+			v.value(join(field, name), object[name], s.additional)
+		} else if s.closed {
+			v.fail(join(field, name), "is not allowed")
+		}
+	}
+}
+
+func inEnum(value interface{}, enum []interface{}) bool {
+	for _, e := range enum {
+		if fmt.Sprint(e) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
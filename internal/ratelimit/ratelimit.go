@@ -0,0 +1,103 @@
+// Package ratelimit implements token bucket rate limiting for arbitrary keys, e.g. a user and org.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit is a token bucket budget: Burst requests at once, refilled at PerMinute requests per minute.
+type Limit struct {
+	PerMinute float64
+	Burst     int
+}
+
+// Enabled reports whether the limit restricts anything.
+func (l Limit) Enabled() bool {
+	return l.PerMinute > 0 && l.Burst > 0
+}
+
+// Result is the outcome of a call to Allow.
+type Result struct {
+	Allowed bool
+	// Limit is the size of the bucket.
+	Limit int
+	// Remaining is the number of requests which would be allowed right now.
+	Remaining int
+	// RetryAfter is how long until the next request is allowed. It is 0 if Remaining is not.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// Limiter keeps one token bucket per key. It is safe for concurrent use.
+type Limiter struct {
+	limit Limit
+	now   func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// sweepInterval is how often buckets which have refilled completely are forgotten.
+const sweepInterval = time.Minute
+
+// New returns a limiter enforcing limit for each key.
+func New(limit Limit) *Limiter {
+	return &Limiter{limit: limit, now: time.Now, buckets: make(map[string]*bucket)}
+}
+
+// Allow takes a token from the bucket for key, if there is one.
+func (l *Limiter) Allow(key string) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+
+	burst := float64(l.limit.Burst)
+	perSecond := l.limit.PerMinute / 60
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.updated).Seconds()*perSecond)
+	b.updated = now
+
+	result := Result{Limit: l.limit.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	}
+	result.Remaining = int(b.tokens)
+	if b.tokens < 1 {
+		result.RetryAfter = secondsToDuration((1 - b.tokens) / perSecond)
+	}
+	result.Reset = secondsToDuration((burst - b.tokens) / perSecond)
+	return result
+}
+
+// sweep forgets buckets which are full, as they behave the same as new ones.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	fullAfter := secondsToDuration(float64(l.limit.Burst) / (l.limit.PerMinute / 60))
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) >= fullAfter {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(math.Ceil(seconds * float64(time.Second)))
+}
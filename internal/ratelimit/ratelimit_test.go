@@ -0,0 +1,53 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestLimiter(t *testing.T) {
+	is := is.New(t)
+	now := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	l := New(Limit{PerMinute: 6, Burst: 2})
+	l.now = func() time.Time { return now }
+
+	result := l.Allow("user/org")
+	is.True(result.Allowed)
+	is.Equal(result.Limit, 2)
+	is.Equal(result.Remaining, 1)
+	is.Equal(result.Reset, 10*time.Second)
+
+	is.True(l.Allow("user/org").Allowed)
+	result = l.Allow("user/org")
+	is.True(!result.Allowed)
+	is.Equal(result.Remaining, 0)
+	is.Equal(result.RetryAfter, 10*time.Second) // one token every 10s
+	is.Equal(result.Reset, 20*time.Second)
+
+	is.True(l.Allow("user/other-org").Allowed) // buckets are per key
+
+	now = now.Add(5 * time.Second)
+	result = l.Allow("user/org")
+	is.True(!result.Allowed)
+	is.Equal(result.RetryAfter, 5*time.Second)
+
+	now = now.Add(5 * time.Second)
+	is.True(l.Allow("user/org").Allowed)
+}
+
+func TestLimiterForgetsFullBuckets(t *testing.T) {
+	is := is.New(t)
+	now := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	l := New(Limit{PerMinute: 60, Burst: 5})
+	l.now = func() time.Time { return now }
+
+	l.Allow("a")
+	l.Allow("b")
+	is.Equal(len(l.buckets), 2)
+
+	now = now.Add(2 * time.Minute)
+	l.Allow("c")
+	is.Equal(len(l.buckets), 1)
+}
@@ -0,0 +1,354 @@
+// Package semver parses and compares semantic versions (https://semver.org) and matches them against ranges like
+// ^1.2 or >=1.0.0 <2.0.0.
+package semver
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version.
+type Version struct {
+	Major, Minor, Patch uint64
+	// Prerelease holds the dot-separated identifiers after "-"
+	Prerelease []string
+	// Build is the metadata after "+", which does not affect precedence
+	Build string
+}
+
+// Parse parses a version of the form MAJOR.MINOR.PATCH[-PRERELEASE][+BUILD], optionally prefixed with "v".
+func Parse(s string) (Version, error) {
+	v, parts, err := parse(s)
+	if err != nil {
+		return Version{}, err
+	}
+	if parts != 3 {
+		return Version{}, fmt.Errorf("version %q needs major, minor and patch numbers", s)
+	}
+	return v, nil
+}
+
+// ParseTolerant is like Parse, but also accepts versions without minor or patch numbers, like 1.0, which are taken
+// to be 0.
+func ParseTolerant(s string) (Version, error) {
+	v, _, err := parse(s)
+	return v, err
+}
+
+// IsValid reports whether s can be parsed with Parse.
+func IsValid(s string) bool {
+	_, err := Parse(s)
+	return err == nil
+}
+
+// parse is like Parse, but minor and patch may be left out. It returns how many of the numbers were given.
+func parse(s string) (Version, int, error) {
+	var v Version
+	rest := strings.TrimPrefix(s, "v")
+	if i := strings.IndexByte(rest, '+'); i >= 0 {
+		v.Build = rest[i+1:]
+		rest = rest[:i]
+		if !validIdentifiers(v.Build) {
+			return Version{}, 0, fmt.Errorf("invalid build metadata in version %q", s)
+		}
+	}
+	if i := strings.IndexByte(rest, '-'); i >= 0 {
+		pre := rest[i+1:]
+		rest = rest[:i]
+		if !validIdentifiers(pre) {
+			return Version{}, 0, fmt.Errorf("invalid prerelease in version %q", s)
+		}
+		v.Prerelease = strings.Split(pre, ".")
+	}
+	numbers := strings.Split(rest, ".")
+	if len(numbers) > 3 {
+		return Version{}, 0, fmt.Errorf("invalid version %q", s)
+	}
+	fields := []*uint64{&v.Major, &v.Minor, &v.Patch}
+	for i, number := range numbers {
+		if !isNumeric(number) || (len(number) > 1 && number[0] == '0') {
+			return Version{}, 0, fmt.Errorf("invalid version %q", s)
+		}
+		n, err := strconv.ParseUint(number, 10, 64)
+		if err != nil {
+			return Version{}, 0, fmt.Errorf("invalid version %q", s)
+		}
+		*fields[i] = n
+	}
+	if len(v.Prerelease) > 0 && len(numbers) != 3 {
+		return Version{}, 0, fmt.Errorf("version %q needs major, minor and patch numbers", s)
+	}
+	return v, len(numbers), nil
+}
+
+func validIdentifiers(s string) bool {
+	for _, identifier := range strings.Split(s, ".") {
+		if identifier == "" {
+			return false
+		}
+		for _, r := range identifier {
+			if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Prerelease) > 0 {
+		s += "-" + strings.Join(v.Prerelease, ".")
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1 if v has lower, equal or higher precedence than w.
+func (v Version) Compare(w Version) int {
+	if c := compareUint(v.Major, w.Major); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Minor, w.Minor); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Patch, w.Patch); c != 0 {
+		return c
+	}
+	// A release has higher precedence than its prereleases
+	switch {
+	case len(v.Prerelease) == 0 && len(w.Prerelease) == 0:
+		return 0
+	case len(v.Prerelease) == 0:
+		return 1
+	case len(w.Prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.Prerelease) && i < len(w.Prerelease); i++ {
+		if c := compareIdentifier(v.Prerelease[i], w.Prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	return compareUint(uint64(len(v.Prerelease)), uint64(len(w.Prerelease)))
+}
+
+func compareUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareIdentifier compares prerelease identifiers: numeric ones numerically and lower than alphanumeric ones,
+// which are compared in ASCII order.
+func compareIdentifier(a, b string) int {
+	aNumeric, bNumeric := isNumeric(a), isNumeric(b)
+	switch {
+	case aNumeric && bNumeric:
+		if len(a) != len(b) {
+			return compareUint(uint64(len(a)), uint64(len(b)))
+		}
+		return strings.Compare(a, b)
+	case aNumeric:
+		return -1
+	case bNumeric:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// Constraint is a version range, or the literal tag of a version which is not a semantic version.
+type Constraint struct {
+	raw     string
+	literal bool
+	clauses []clause
+	// prerelease allows prereleases, when a clause names one
+	prerelease bool
+}
+
+type clause struct {
+	op      string
+	version Version
+}
+
+// Latest is the constraint matching all releases.
+const Latest = "latest"
+
+var errEmptyRange = errors.New("empty version range")
+
+// ParseConstraint parses a version range. Ranges are one or more of the following, separated by spaces or commas,
+// all of which must match:
+//
+//	latest, *     any release
+//	1.2.3         exactly 1.2.3; 1.2 means ~1.2 and 1 means ^1
+//	^1.2.3        compatible with 1.2.3: >=1.2.3 <2.0.0 (<0.3.0 for ^0.2.3)
+//	~1.2.3        patch releases of 1.2: >=1.2.3 <1.3.0
+//	>=1.2.0, >1, <2.0.0, <=2, =1.2.3
+//
+// Anything else which is not a range, like NEW_TAG, matches only that exact tag. Prereleases only match ranges which
+// name a prerelease.
+func ParseConstraint(s string) (Constraint, error) {
+	s = strings.TrimSpace(s)
+	c := Constraint{raw: s}
+	if s == "" || s == Latest || s == "*" {
+		return c, nil
+	}
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool { return r == ' ' || r == ',' }) {
+		clauses, prerelease, err := parseClause(field)
+		if err != nil {
+			if !strings.ContainsAny(s, "^~<>=*, ") {
+				return Constraint{raw: s, literal: true}, nil
+			}
+			return Constraint{}, err
+		}
+		c.prerelease = c.prerelease || prerelease
+		c.clauses = append(c.clauses, clauses...)
+	}
+	if len(c.clauses) == 0 {
+		return Constraint{}, errEmptyRange
+	}
+	return c, nil
+}
+
+// parseClause returns the comparisons a range stands for, and whether it names a prerelease.
+func parseClause(s string) ([]clause, bool, error) {
+	if s == "*" {
+		return nil, false, nil
+	}
+	op := ""
+	for _, prefix := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(s, prefix) {
+			op = prefix
+			break
+		}
+	}
+	v, parts, err := parse(s[len(op):])
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid version range %q: %w", s, err)
+	}
+	prerelease := len(v.Prerelease) > 0
+	if op == "" {
+		switch parts {
+		case 1:
+			op = "^"
+		case 2:
+			op = "~"
+		default:
+			op = "="
+		}
+	}
+	switch op {
+	case "^":
+		upper := Version{Major: v.Major + 1}
+		switch {
+		case v.Major == 0 && parts == 1:
+			upper = Version{Major: 1}
+		case v.Major == 0 && v.Minor == 0 && parts == 3:
+			upper = Version{Patch: v.Patch + 1}
+		case v.Major == 0:
+			upper = Version{Minor: v.Minor + 1}
+		}
+		return []clause{{">=", v}, {"<", prereleaseFloor(upper)}}, prerelease, nil
+	case "~":
+		upper := Version{Major: v.Major, Minor: v.Minor + 1}
+		if parts == 1 {
+			upper = Version{Major: v.Major + 1}
+		}
+		return []clause{{">=", v}, {"<", prereleaseFloor(upper)}}, prerelease, nil
+	case "<=", ">":
+		// 1.2 means all of 1.2.x
+		switch parts {
+		case 1:
+			v = Version{Major: v.Major + 1}
+		case 2:
+			v = Version{Major: v.Major, Minor: v.Minor + 1}
+		default:
+			return []clause{{op, v}}, prerelease, nil
+		}
+		if op == "<=" {
+			return []clause{{"<", prereleaseFloor(v)}}, false, nil
+		}
+		return []clause{{">=", v}}, false, nil
+	case "=":
+		if parts != 3 {
+			return parseClause("~" + s[1:])
+		}
+	case "<":
+		v = prereleaseFloor(v)
+	}
+	return []clause{{op, v}}, prerelease, nil
+}
+
+// prereleaseFloor returns the lowest version with v's numbers, so that "<2.0.0" excludes 2.0.0-alpha.
+func prereleaseFloor(v Version) Version {
+	if len(v.Prerelease) > 0 {
+		return v
+	}
+	v.Prerelease = []string{"0"}
+	return v
+}
+
+// Match reports whether the version tag satisfies c. Tags are parsed with ParseTolerant.
+func (c Constraint) Match(tag string) bool {
+	if c.literal {
+		return tag == c.raw
+	}
+	v, err := ParseTolerant(tag)
+	if err != nil {
+		return false
+	}
+	if len(v.Prerelease) > 0 && !c.prerelease {
+		return false
+	}
+	for _, cl := range c.clauses {
+		cmp := v.Compare(cl.version)
+		var ok bool
+		switch cl.op {
+		case ">=":
+			ok = cmp >= 0
+		case ">":
+			ok = cmp > 0
+		case "<=":
+			ok = cmp <= 0
+		case "<":
+			ok = cmp < 0
+		case "=":
+			ok = cmp == 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// IsLiteral reports whether c matches a single tag which is not a semantic version.
+func (c Constraint) IsLiteral() bool {
+	return c.literal
+}
+
+func (c Constraint) String() string {
+	if c.raw == "" {
+		return Latest
+	}
+	return c.raw
+}
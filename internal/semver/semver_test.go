@@ -0,0 +1,94 @@
+package semver
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestParse(t *testing.T) {
+	is := is.New(t)
+	v, err := Parse("v1.2.3-rc.1+build.5")
+	is.NoErr(err)
+	is.Equal(v, Version{Major: 1, Minor: 2, Patch: 3, Prerelease: []string{"rc", "1"}, Build: "build.5"})
+	is.Equal(v.String(), "1.2.3-rc.1+build.5")
+
+	v, err = ParseTolerant("1.2")
+	is.NoErr(err)
+	is.Equal(v.String(), "1.2.0")
+
+	for _, invalid := range []string{"", "NEW_TAG", "1.2", "1.2.3.4", "01.2.3", "1.2.x", "1.2.3-", "1.2.3-a..b", "1.2.3+"} {
+		is.True(!IsValid(invalid)) // invalid version
+	}
+}
+
+func TestCompare(t *testing.T) {
+	is := is.New(t)
+	// In increasing precedence, from the semver specification
+	ordered := []string{
+		"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11",
+		"1.0.0-rc.1", "1.0.0", "1.0.1", "1.2.0", "1.10.0", "2.0.0",
+	}
+	versions := make([]Version, len(ordered))
+	for i := range ordered {
+		var err error
+		versions[i], err = Parse(ordered[len(ordered)-1-i])
+		is.NoErr(err)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Compare(versions[j]) < 0 })
+	for i, v := range versions {
+		is.Equal(v.String(), ordered[i])
+	}
+
+	a, _ := Parse("1.0.0+build.1")
+	b, _ := Parse("1.0.0+build.2")
+	is.Equal(a.Compare(b), 0) // build metadata is ignored
+}
+
+func TestConstraint(t *testing.T) {
+	for _, test := range []struct {
+		constraint string
+		matches    []string
+		misses     []string
+	}{
+		{"latest", []string{"0.0.1", "1.2.3", "10.0.0"}, []string{"1.0.0-rc.1", "NEW_TAG"}},
+		{"*", []string{"1.2.3"}, []string{"NEW_TAG"}},
+		{"^1.2", []string{"1.2.0", "1.9.9", "1.3"}, []string{"1.1.9", "2.0.0", "2.0.0-alpha", "1.3.0-rc.1"}},
+		{"^1.2.3", []string{"1.2.3", "1.3.0"}, []string{"1.2.2", "2.0.0"}},
+		{"^0.2.3", []string{"0.2.3", "0.2.9"}, []string{"0.3.0"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.4"}},
+		{"~1.2.3", []string{"1.2.3", "1.2.9"}, []string{"1.3.0"}},
+		{"~1", []string{"1.0.0", "1.9.0"}, []string{"2.0.0"}},
+		{"1", []string{"1.0.0", "1.9.0"}, []string{"2.0.0", "0.9.0"}},
+		{"1.2", []string{"1.2.0", "1.2.9"}, []string{"1.3.0"}},
+		{"1.2.3", []string{"1.2.3", "v1.2.3"}, []string{"1.2.4"}},
+		{"=1.2", []string{"1.2.5"}, []string{"1.3.0"}},
+		{">=1.2.0 <2", []string{"1.2.0", "1.99.0"}, []string{"1.1.0", "2.0.0"}},
+		{">1.2, <=1.4", []string{"1.3.0", "1.4.9"}, []string{"1.2.9", "1.5.0"}},
+		{">=1.0.0-rc.1", []string{"1.0.0-rc.2", "1.0.0"}, []string{"1.0.0-beta"}},
+		{"NEW_TAG", []string{"NEW_TAG"}, []string{"NEW_TAG2", "1.0.0"}},
+	} {
+		c, err := ParseConstraint(test.constraint)
+		if err != nil {
+			t.Errorf("ParseConstraint(%q): %v", test.constraint, err)
+			continue
+		}
+		for _, tag := range test.matches {
+			if !c.Match(tag) {
+				t.Errorf("%q does not match %q", test.constraint, tag)
+			}
+		}
+		for _, tag := range test.misses {
+			if c.Match(tag) {
+				t.Errorf("%q matches %q", test.constraint, tag)
+			}
+		}
+	}
+
+	for _, invalid := range []string{"^x", ">=1.2.3.4", "~", "^1 <y"} {
+		if _, err := ParseConstraint(invalid); err == nil {
+			t.Errorf("ParseConstraint(%q) succeeded", invalid)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package testutil
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -18,6 +19,7 @@ func (d *FakeDoer) Do(req *http.Request) (*http.Response, error) {
 	doer, ok := d.doer[key]
 	if !ok {
 		d.t.Errorf("No match for %s, not previously registered", key)
+		return nil, fmt.Errorf("no response registered for %s", key)
 	}
 	return doer(req)
 }
@@ -62,3 +64,10 @@ func (d *FakeDoer) HandleBodyRequest(expectedMethod string, expectedURI string,
 		return &resp, nil
 	}
 }
+
+// HandleError makes a request fail with err as if it had not reached the server, e.g. because of a timeout.
+func (d *FakeDoer) HandleError(expectedMethod string, expectedURI string, err error) {
+	d.doer[expectedMethod+" "+expectedURI] = func(req *http.Request) (*http.Response, error) {
+		return nil, err
+	}
+}
@@ -0,0 +1,297 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Exporter sends a batch of finished spans somewhere.
+type Exporter interface {
+	Export(ctx context.Context, spans []*Span) error
+}
+
+// Doer is satisfied by *http.Client.
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Resource describes the process emitting spans.
+type Resource struct {
+	ServiceName string
+}
+
+// EncodeOTLP renders spans as an OTLP/JSON ExportTraceServiceRequest.
+func EncodeOTLP(resource Resource, spans []*Span) ([]byte, error) {
+	encoded := make([]otlpSpan, len(spans))
+	for i, span := range spans {
+		encoded[i] = span.toOTLP()
+	}
+	request := otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpKeyValue{
+			{Key: "service.name", Value: attributeValue(resource.ServiceName)},
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "humanitec.io/walhallapiadaptor/internal/tracing"},
+			Spans: encoded,
+		}},
+	}}}
+	return json.Marshal(request)
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              Kind           `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    StatusCode `json:"code"`
+	Message string     `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+func (s *Span) toOTLP() otlpSpan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	span := otlpSpan{
+		TraceID:           s.context.TraceID.String(),
+		SpanID:            s.context.SpanID.String(),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		Status:            otlpStatus{Code: s.statusCode, Message: s.statusMessage},
+	}
+	if s.parent.IsValid() {
+		span.ParentSpanID = s.parent.String()
+	}
+	keys := make([]string, 0, len(s.attributes))
+	for key := range s.attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		span.Attributes = append(span.Attributes, otlpKeyValue{Key: key, Value: attributeValue(s.attributes[key])})
+	}
+	return span
+}
+
+// attributeValue maps a Go value onto an OTLP AnyValue. 64 bit integers are strings in the JSON mapping.
+func attributeValue(value interface{}) map[string]interface{} {
+	switch v := value.(type) {
+	case string:
+		return map[string]interface{}{"stringValue": v}
+	case bool:
+		return map[string]interface{}{"boolValue": v}
+	case int:
+		return map[string]interface{}{"intValue": strconv.Itoa(v)}
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		return map[string]interface{}{"doubleValue": v}
+	}
+	return map[string]interface{}{"stringValue": fmt.Sprint(value)}
+}
+
+// WriterExporter writes each batch as one line of OTLP/JSON, e.g. to a file.
+type WriterExporter struct {
+	Resource Resource
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterExporter returns an exporter writing to w.
+func NewWriterExporter(w io.Writer, resource Resource) *WriterExporter {
+	return &WriterExporter{Resource: resource, w: w}
+}
+
+// NewFileExporter returns an exporter appending to the file at path.
+func NewFileExporter(path string, resource Resource) (*WriterExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open trace file: %w", err)
+	}
+	return NewWriterExporter(f, resource), nil
+}
+
+// Export implements Exporter.
+func (e *WriterExporter) Export(ctx context.Context, spans []*Span) error {
+	data, err := EncodeOTLP(e.Resource, spans)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err = e.w.Write(append(data, '\n'))
+	return err
+}
+
+// HTTPExporter posts batches to an OTLP/HTTP collector using the JSON encoding.
+type HTTPExporter struct {
+	Resource Resource
+	url      string
+	doer     Doer
+}
+
+// NewHTTPExporter returns an exporter for the collector at endpoint (e.g. "http://localhost:4318"). Spans are
+// posted to endpoint + "/v1/traces".
+func NewHTTPExporter(endpoint string, doer Doer, resource Resource) *HTTPExporter {
+	if doer == nil {
+		doer = http.DefaultClient
+	}
+	return &HTTPExporter{Resource: resource, url: strings.TrimRight(endpoint, "/") + "/v1/traces", doer: doer}
+}
+
+// Export implements Exporter.
+func (e *HTTPExporter) Export(ctx context.Context, spans []*Span) error {
+	data, err := EncodeOTLP(e.Resource, spans)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("export spans: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.doer.Do(req)
+	if err != nil {
+		return fmt.Errorf("export spans: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("export spans: collector returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// BatchProcessor buffers finished spans and exports them in batches, either when the batch is full or
+// periodically. Spans are dropped if the buffer is full, so that tracing never blocks requests.
+type BatchProcessor struct {
+	exporter  Exporter
+	batchSize int
+	onError   func(error)
+
+	spans    chan *Span
+	done     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// NewBatchProcessor starts a processor which exports at least every interval. onError may be nil.
+func NewBatchProcessor(exporter Exporter, batchSize int, interval time.Duration, onError func(error)) *BatchProcessor {
+	if onError == nil {
+		onError = func(error) {}
+	}
+	p := &BatchProcessor{
+		exporter:  exporter,
+		batchSize: batchSize,
+		onError:   onError,
+		spans:     make(chan *Span, batchSize*4),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	go p.run(interval)
+	return p
+}
+
+// OnEnd implements Processor.
+func (p *BatchProcessor) OnEnd(span *Span) {
+	select {
+	case p.spans <- span:
+	default:
+		// Buffer full: drop the span rather than slow down the request.
+	}
+}
+
+func (p *BatchProcessor) run(interval time.Duration) {
+	defer close(p.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	batch := make([]*Span, 0, p.batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := p.exporter.Export(ctx, batch); err != nil {
+			p.onError(err)
+		}
+		cancel()
+		batch = make([]*Span, 0, p.batchSize)
+	}
+	for {
+		select {
+		case span := <-p.spans:
+			batch = append(batch, span)
+			if len(batch) >= p.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-p.done:
+			for {
+				select {
+				case span := <-p.spans:
+					batch = append(batch, span)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// Shutdown exports any buffered spans and stops the processor.
+func (p *BatchProcessor) Shutdown(ctx context.Context) error {
+	p.stopOnce.Do(func() { close(p.done) })
+	select {
+	case <-p.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
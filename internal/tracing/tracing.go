@@ -0,0 +1,301 @@
+// Package tracing records OpenTelemetry style spans for requests handled by the adaptor and the calls it makes to
+// Walhall Core, propagating W3C trace context ("traceparent") headers and exporting spans as OTLP/JSON.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceparentHeader is the W3C trace context header.
+const TraceparentHeader = "traceparent"
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+// IsValid reports whether the ID is not all zeros, as required by the W3C spec.
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+// IsValid reports whether the ID is not all zeros, as required by the W3C spec.
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+// SpanContext is the part of a span which is propagated between processes.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid reports whether both IDs are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Kind describes the relationship of a span to its parent and children. Values match OTLP's SpanKind.
+type Kind int
+
+// Span kinds.
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// StatusCode is the outcome of a span. Values match OTLP's Status.StatusCode.
+type StatusCode int
+
+// Status codes.
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+// Span is a timed operation within a trace. Spans are safe for concurrent use.
+type Span struct {
+	tracer *Tracer
+
+	mu            sync.Mutex
+	context       SpanContext
+	parent        SpanID
+	name          string
+	kind          Kind
+	start         time.Time
+	end           time.Time
+	attributes    map[string]interface{}
+	statusCode    StatusCode
+	statusMessage string
+}
+
+// SpanContext returns the identifiers of the span.
+func (s *Span) SpanContext() SpanContext {
+	return s.context
+}
+
+// SetName changes the name of the span, e.g. once the route of a request is known.
+func (s *Span) SetName(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = name
+}
+
+// SetAttribute records a key/value pair on the span. Values should be strings, bools or numbers.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attributes[key] = value
+}
+
+// SetStatus records the outcome of the span.
+func (s *Span) SetStatus(code StatusCode, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statusCode = code
+	s.statusMessage = message
+}
+
+// RecordError marks the span as failed because of err.
+func (s *Span) RecordError(err error) {
+	if err != nil {
+		s.SetStatus(StatusError, err.Error())
+	}
+}
+
+// End finishes the span and hands it to the tracer's exporter. Calls after the first have no effect.
+func (s *Span) End() {
+	s.mu.Lock()
+	if !s.end.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.end = time.Now()
+	s.mu.Unlock()
+	if s.context.Sampled && s.tracer != nil {
+		s.tracer.export(s)
+	}
+}
+
+// Tracer creates spans and passes finished ones to a Processor.
+type Tracer struct {
+	mu        sync.RWMutex
+	processor Processor
+}
+
+// Processor receives finished spans.
+type Processor interface {
+	OnEnd(span *Span)
+	Shutdown(ctx context.Context) error
+}
+
+var defaultTracer = &Tracer{}
+
+// Default returns the process wide tracer. Until SetProcessor is called, spans are created and propagated but
+// not exported.
+func Default() *Tracer {
+	return defaultTracer
+}
+
+// SetProcessor sets where finished spans are sent.
+func (t *Tracer) SetProcessor(p Processor) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.processor = p
+}
+
+// Shutdown flushes and stops the tracer's processor, if any.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	t.mu.RLock()
+	p := t.processor
+	t.mu.RUnlock()
+	if p == nil {
+		return nil
+	}
+	return p.Shutdown(ctx)
+}
+
+func (t *Tracer) export(s *Span) {
+	t.mu.RLock()
+	p := t.processor
+	t.mu.RUnlock()
+	if p != nil {
+		p.OnEnd(s)
+	}
+}
+
+func (t *Tracer) sampling() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.processor != nil
+}
+
+type contextKey int
+
+const (
+	spanKey contextKey = iota
+	remoteKey
+)
+
+// Start begins a span which is a child of the span (or remote span context) in ctx, and returns a context
+// carrying the new span.
+func (t *Tracer) Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	span := &Span{
+		tracer:     t,
+		name:       name,
+		kind:       kind,
+		start:      time.Now(),
+		attributes: make(map[string]interface{}),
+	}
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.context.TraceID = parent.context.TraceID
+		span.context.Sampled = parent.context.Sampled
+		span.parent = parent.context.SpanID
+	} else if remote, ok := ctx.Value(remoteKey).(SpanContext); ok && remote.IsValid() {
+		span.context.TraceID = remote.TraceID
+		span.context.Sampled = remote.Sampled || t.sampling()
+		span.parent = remote.SpanID
+	} else {
+		span.context.TraceID = newTraceID()
+		span.context.Sampled = t.sampling()
+	}
+	span.context.SpanID = newSpanID()
+	return context.WithValue(ctx, spanKey, span), span
+}
+
+// Start begins a span using the default tracer.
+func Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	return defaultTracer.Start(ctx, name, kind)
+}
+
+// SpanFromContext returns the current span, if any.
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value(spanKey).(*Span)
+	return span
+}
+
+// Extract returns a copy of ctx carrying the remote span context from a traceparent header, if present and valid.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	sc, err := ParseTraceparent(header.Get(TraceparentHeader))
+	if err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey, sc)
+}
+
+// Inject sets the traceparent header for the current span in ctx.
+func Inject(ctx context.Context, header http.Header) {
+	if span := SpanFromContext(ctx); span != nil {
+		header.Set(TraceparentHeader, FormatTraceparent(span.context))
+	}
+}
+
+// FormatTraceparent renders a span context as a version 00 traceparent header.
+func FormatTraceparent(sc SpanContext) string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent parses a traceparent header.
+func ParseTraceparent(value string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}, fmt.Errorf("invalid traceparent %q", value)
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}, fmt.Errorf("invalid traceparent %q", value)
+	}
+	var sc SpanContext
+	if err := decodeHex(parts[1], sc.TraceID[:]); err != nil || !sc.TraceID.IsValid() {
+		return SpanContext{}, fmt.Errorf("invalid trace id in traceparent %q", value)
+	}
+	if err := decodeHex(parts[2], sc.SpanID[:]); err != nil || !sc.SpanID.IsValid() {
+		return SpanContext{}, fmt.Errorf("invalid parent id in traceparent %q", value)
+	}
+	var flags [1]byte
+	if err := decodeHex(parts[3], flags[:]); err != nil {
+		return SpanContext{}, fmt.Errorf("invalid flags in traceparent %q", value)
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, nil
+}
+
+func decodeHex(s string, dst []byte) error {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return fmt.Errorf("expected %d lower case hex digits", hex.EncodedLen(len(dst)))
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
@@ -0,0 +1,108 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestTraceparent(t *testing.T) {
+	is := is.New(t)
+	sc, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	is.NoErr(err)
+	is.Equal(sc.TraceID.String(), "4bf92f3577b34da6a3ce929d0e0e4736")
+	is.Equal(sc.SpanID.String(), "00f067aa0ba902b7")
+	is.True(sc.Sampled)
+	is.Equal(FormatTraceparent(sc), "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	for _, invalid := range []string{
+		"",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		_, err := ParseTraceparent(invalid)
+		is.True(err != nil)
+	}
+}
+
+type recordingProcessor struct {
+	spans []*Span
+}
+
+func (p *recordingProcessor) OnEnd(span *Span)                   { p.spans = append(p.spans, span) }
+func (p *recordingProcessor) Shutdown(ctx context.Context) error { return nil }
+
+func TestSpansContinueRemoteTrace(t *testing.T) {
+	is := is.New(t)
+	processor := &recordingProcessor{}
+	tracer := &Tracer{}
+	tracer.SetProcessor(processor)
+
+	incoming := http.Header{}
+	incoming.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, server := tracer.Start(Extract(context.Background(), incoming), "GET /orgs", KindServer)
+	_, client := tracer.Start(ctx, "GET /api/walhalluser/{id}", KindClient)
+
+	outgoing := http.Header{}
+	Inject(ctx, outgoing)
+	client.End()
+	server.End()
+
+	is.Equal(len(processor.spans), 2)
+	is.Equal(server.SpanContext().TraceID.String(), "4bf92f3577b34da6a3ce929d0e0e4736")
+	is.Equal(server.parent.String(), "00f067aa0ba902b7")
+	is.Equal(client.SpanContext().TraceID, server.SpanContext().TraceID)
+	is.Equal(client.parent, server.SpanContext().SpanID)
+	is.Equal(outgoing.Get(TraceparentHeader), FormatTraceparent(server.SpanContext()))
+}
+
+func TestBatchProcessorWritesOTLP(t *testing.T) {
+	is := is.New(t)
+	var buf bytes.Buffer
+	tracer := &Tracer{}
+	processor := NewBatchProcessor(NewWriterExporter(&buf, Resource{ServiceName: "walhallapiadaptor"}), 10, time.Hour, nil)
+	tracer.SetProcessor(processor)
+
+	_, span := tracer.Start(context.Background(), "GET /orgs", KindServer)
+	span.SetAttribute("http.status_code", 500)
+	span.RecordError(errors.New("boom"))
+	span.End()
+	is.NoErr(tracer.Shutdown(context.Background()))
+
+	var request struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID    string `json:"traceId"`
+					Name       string `json:"name"`
+					Kind       int    `json:"kind"`
+					Attributes []struct {
+						Key   string                 `json:"key"`
+						Value map[string]interface{} `json:"value"`
+					} `json:"attributes"`
+					Status struct {
+						Code    int    `json:"code"`
+						Message string `json:"message"`
+					} `json:"status"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	is.NoErr(json.Unmarshal(buf.Bytes(), &request))
+	exported := request.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	is.Equal(exported.TraceID, span.SpanContext().TraceID.String())
+	is.Equal(exported.Name, "GET /orgs")
+	is.Equal(exported.Kind, int(KindServer))
+	is.Equal(exported.Attributes[0].Value["intValue"], "500")
+	is.Equal(exported.Status.Code, int(StatusError))
+	is.Equal(exported.Status.Message, "boom")
+}
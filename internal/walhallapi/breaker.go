@@ -0,0 +1,146 @@
+package walhallapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"humanitec.io/walhallapiadaptor/internal/logging"
+)
+
+// ErrCircuitOpen is returned (wrapped in a CircuitOpenError) for requests which are not attempted because the
+// upstream host has been failing.
+var ErrCircuitOpen = errors.New("circuit open")
+
+// CircuitOpenError reports that requests to Host are being rejected for RetryAfter.
+type CircuitOpenError struct {
+	Host       string
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s is unavailable: %v", e.Host, ErrCircuitOpen)
+}
+
+// Unwrap makes errors.Is(err, ErrCircuitOpen) true.
+func (e *CircuitOpenError) Unwrap() error {
+	return ErrCircuitOpen
+}
+
+// BreakerPolicy controls when CircuitBreakerDoer stops sending requests to a host.
+type BreakerPolicy struct {
+	// FailureThreshold is the number of consecutive failures (transport errors or 5xx responses) which open the circuit.
+	FailureThreshold int
+	// Cooldown is how long the circuit stays open before a single trial request is let through.
+	Cooldown time.Duration
+}
+
+// DefaultBreakerPolicy opens after 5 consecutive failures for 30 seconds.
+var DefaultBreakerPolicy = BreakerPolicy{FailureThreshold: 5, Cooldown: 30 * time.Second}
+
+// CircuitBreakerDoer tracks failures per upstream host. Once a host has failed FailureThreshold times in a row,
+// requests to it fail immediately with a CircuitOpenError until Cooldown has passed. Then one trial request is let
+// through: if it succeeds the circuit closes again, otherwise it stays open for another Cooldown.
+type CircuitBreakerDoer struct {
+	doer   Doer
+	policy BreakerPolicy
+	now    func() time.Time
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	failures int
+	open     bool
+	openedAt time.Time
+	probing  bool
+}
+
+// NewCircuitBreakerDoer wraps doer.
+func NewCircuitBreakerDoer(doer Doer, policy BreakerPolicy) *CircuitBreakerDoer {
+	return &CircuitBreakerDoer{doer: doer, policy: policy, now: time.Now, circuits: make(map[string]*circuit)}
+}
+
+// Do implements Doer.
+func (b *CircuitBreakerDoer) Do(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	if err := b.allow(host); err != nil {
+		upstreamRejected.Inc(host)
+		return nil, err
+	}
+	resp, err := b.doer.Do(req)
+	switch {
+	case err != nil && req.Context().Err() != nil:
+		// The caller gave up, which says nothing about the health of the host
+		b.release(host)
+	case err != nil || resp.StatusCode >= http.StatusInternalServerError:
+		b.failure(req.Context(), host)
+	default:
+		b.success(req.Context(), host)
+	}
+	return resp, err
+}
+
+func (b *CircuitBreakerDoer) allow(host string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[host]
+	if !ok || !c.open {
+		return nil
+	}
+	remaining := c.openedAt.Add(b.policy.Cooldown).Sub(b.now())
+	if remaining > 0 || c.probing {
+		if remaining <= 0 {
+			remaining = time.Second
+		}
+		return &CircuitOpenError{Host: host, RetryAfter: remaining}
+	}
+	c.probing = true
+	return nil
+}
+
+func (b *CircuitBreakerDoer) release(host string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c, ok := b.circuits[host]; ok {
+		c.probing = false
+	}
+}
+
+func (b *CircuitBreakerDoer) failure(ctx context.Context, host string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[host]
+	if !ok {
+		c = &circuit{}
+		b.circuits[host] = c
+	}
+	c.failures++
+	wasProbing := c.probing
+	c.probing = false
+	if wasProbing || (!c.open && c.failures >= b.policy.FailureThreshold) {
+		if !c.open {
+			logging.FromContext(ctx).Warn("[walhallapi] circuit opened", "component", "walhallapi", "host", host,
+				"failures", c.failures, "cooldown_ms", b.policy.Cooldown)
+		}
+		c.open = true
+		c.openedAt = b.now()
+	}
+}
+
+func (b *CircuitBreakerDoer) success(ctx context.Context, host string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[host]
+	if !ok {
+		return
+	}
+	if c.open {
+		logging.FromContext(ctx).Info("[walhallapi] circuit closed", "component", "walhallapi", "host", host)
+	}
+	delete(b.circuits, host)
+}
@@ -0,0 +1,70 @@
+package walhallapi
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"humanitec.io/walhallapiadaptor/internal/testutil"
+)
+
+func TestCircuitBreakerDoer(t *testing.T) {
+	is := is.New(t)
+	now := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	status := http.StatusServiceUnavailable
+	calls := 0
+	b := NewCircuitBreakerDoer(doerFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		return fakeResponse(status, nil), nil
+	}), BreakerPolicy{FailureThreshold: 2, Cooldown: 10 * time.Second})
+	b.now = func() time.Time { return now }
+
+	req, _ := http.NewRequest(http.MethodGet, "http://walhall/api/logicmodule", nil)
+	other, _ := http.NewRequest(http.MethodGet, "http://other/api/logicmodule", nil)
+	for i := 0; i < 2; i++ {
+		resp, err := b.Do(req)
+		is.NoErr(err)
+		is.Equal(resp.StatusCode, http.StatusServiceUnavailable)
+	}
+
+	_, err := b.Do(req)
+	is.True(errors.Is(err, ErrCircuitOpen)) // open after 2 failures
+	var open *CircuitOpenError
+	is.True(errors.As(err, &open))
+	is.Equal(open.RetryAfter, 10*time.Second)
+	is.Equal(calls, 2)
+
+	_, err = b.Do(other)
+	is.NoErr(err) // circuits are per host
+	is.Equal(calls, 3)
+
+	// After the cooldown a failing trial request reopens the circuit
+	now = now.Add(10 * time.Second)
+	_, err = b.Do(req)
+	is.NoErr(err)
+	is.Equal(calls, 4)
+	_, err = b.Do(req)
+	is.True(errors.Is(err, ErrCircuitOpen))
+
+	// A successful trial request closes it
+	now = now.Add(10 * time.Second)
+	status = http.StatusOK
+	_, err = b.Do(req)
+	is.NoErr(err)
+	_, err = b.Do(req)
+	is.NoErr(err)
+	is.Equal(calls, 6)
+}
+
+func TestCircuitOpenErrorIsReturned(t *testing.T) {
+	is := is.New(t)
+	b := NewCircuitBreakerDoer(testutil.NewFakeDoer(t), BreakerPolicy{FailureThreshold: 1, Cooldown: time.Minute})
+	b.circuits["walhall"] = &circuit{open: true, openedAt: time.Now()}
+
+	api, err := New("http://walhall", exampleJWT, b)
+	is.NoErr(err)
+	_, err = api.ListModules("chrishumanitec")
+	is.True(errors.Is(err, ErrCircuitOpen)) // the error is passed up, not a panic on a nil response
+}
@@ -0,0 +1,70 @@
+package walhallapi
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Coalescer lets concurrent identical GET requests to Walhall share one upstream call: while a request is in flight,
+// later identical ones wait for its response instead of making their own. It is safe for concurrent use.
+type Coalescer struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+type flight struct {
+	done     chan struct{}
+	response response
+	err      error
+}
+
+// NewCoalescer returns a Coalescer with nothing in flight.
+func NewCoalescer() *Coalescer {
+	return &Coalescer{flights: make(map[string]*flight)}
+}
+
+// Coalesce makes the GET requests of a share upstream calls with those of other APIStates using c. If verified is
+// true, the token's claims have been checked, so requests of users with the same organizations and scope are shared.
+// Otherwise the claims may be forged and only requests made with the same token are shared.
+func (a *APIState) Coalesce(c *Coalescer, verified bool) {
+	a.coalescer = c
+	if verified {
+		orgs := append([]string{}, a.claims.OrgUUIDs...)
+		sort.Strings(orgs)
+		a.access = "claims:" + strings.Join(orgs, ",") + ";" + a.claims.Scope
+	} else {
+		sum := sha256.Sum256([]byte(a.jwt))
+		a.access = "token:" + hex.EncodeToString(sum[:])
+	}
+}
+
+// do calls fetch unless an identical call is already in flight, in which case it waits for that call's result.
+// shared reports whether the result came from another caller's call.
+func (c *Coalescer) do(ctx context.Context, key string, fetch func() (response, error)) (resp response, err error, shared bool) {
+	c.mu.Lock()
+	if f, ok := c.flights[key]; ok {
+		c.mu.Unlock()
+		select {
+		case <-f.done:
+			return f.response, f.err, true
+		case <-ctx.Done():
+			return response{}, ctx.Err(), true
+		}
+	}
+	f := &flight{done: make(chan struct{})}
+	c.flights[key] = f
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.flights, key)
+		c.mu.Unlock()
+		close(f.done)
+	}()
+	f.response, f.err = fetch()
+	return f.response, f.err, false
+}
@@ -0,0 +1,143 @@
+package walhallapi
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/matryer/is"
+)
+
+// blockingDoer answers every request with body once release is closed, counting the requests it received.
+type blockingDoer struct {
+	calls   int32
+	release chan struct{}
+	body    string
+}
+
+func (d *blockingDoer) Do(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&d.calls, 1)
+	select {
+	case <-d.release:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(d.body))}, nil
+}
+
+// waitForCalls waits until n requests have reached the doer.
+func (d *blockingDoer) waitForCalls(t *testing.T, n int32) {
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&d.calls) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d calls, got %d", n, atomic.LoadInt32(&d.calls))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func tokenFor(t *testing.T, user string, orgUUIDs ...string) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, WalhallClaims{
+		UserUUID: user,
+		Scope:    "read",
+		OrgUUIDs: orgUUIDs,
+	}).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+const statusURL = "/api/repositories/github/status?organization_uuid=a79d9e99-476d-4e29-a5f2-60102a5fff29"
+
+func TestCoalesceSharesConcurrentRequests(t *testing.T) {
+	for name, tc := range map[string]struct {
+		verified      bool
+		tokens        []string
+		expectedCalls int32
+	}{
+		"same access": {true, []string{
+			tokenFor(t, "user-one", "a79d9e99-476d-4e29-a5f2-60102a5fff29"),
+			tokenFor(t, "user-two", "a79d9e99-476d-4e29-a5f2-60102a5fff29"),
+			tokenFor(t, "user-three", "a79d9e99-476d-4e29-a5f2-60102a5fff29"),
+		}, 1},
+		"different access": {true, []string{
+			tokenFor(t, "user-one", "a79d9e99-476d-4e29-a5f2-60102a5fff29"),
+			tokenFor(t, "user-two", "a79d9e99-476d-4e29-a5f2-60102a5fff29", "f33f013e-e532-4b27-958e-50220a18a2bd"),
+		}, 2},
+		"unverified tokens": {false, []string{
+			tokenFor(t, "user-one", "a79d9e99-476d-4e29-a5f2-60102a5fff29"),
+			tokenFor(t, "user-two", "a79d9e99-476d-4e29-a5f2-60102a5fff29"),
+		}, 2},
+	} {
+		t.Run(name, func(t *testing.T) {
+			is := is.New(t)
+			doer := &blockingDoer{release: make(chan struct{}), body: `{"status": "done"}`}
+			coalescer := NewCoalescer()
+
+			var wg sync.WaitGroup
+			results := make([]string, len(tc.tokens))
+			for i, token := range tc.tokens {
+				api, err := New("http://api.walhall.io", token, doer)
+				is.NoErr(err)
+				api.Coalesce(coalescer, tc.verified)
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					var status struct {
+						Status string `json:"status"`
+					}
+					if err := api.doJSON(http.MethodGet, statusURL, nil, &status); err != nil {
+						t.Error(err)
+					}
+					results[i] = status.Status
+				}(i)
+				if i == 0 {
+					doer.waitForCalls(t, 1) // make sure the first request is in flight before the others start
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			close(doer.release)
+			wg.Wait()
+
+			is.Equal(atomic.LoadInt32(&doer.calls), tc.expectedCalls)
+			for _, result := range results {
+				is.Equal(result, "done")
+			}
+		})
+	}
+}
+
+func TestCoalesceWaiterOutlivesAbandonedRequest(t *testing.T) {
+	is := is.New(t)
+	doer := &blockingDoer{release: make(chan struct{}), body: `{"status": "done"}`}
+	coalescer := NewCoalescer()
+	token := tokenFor(t, "user-one", "a79d9e99-476d-4e29-a5f2-60102a5fff29")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first, err := NewWithContext(ctx, "http://api.walhall.io", token, doer)
+	is.NoErr(err)
+	first.Coalesce(coalescer, true)
+	second, err := New("http://api.walhall.io", token, doer)
+	is.NoErr(err)
+	second.Coalesce(coalescer, true)
+
+	firstErr := make(chan error)
+	go func() { firstErr <- first.doJSON(http.MethodGet, statusURL, nil, nil) }()
+	doer.waitForCalls(t, 1)
+	secondErr := make(chan error)
+	go func() { secondErr <- second.doJSON(http.MethodGet, statusURL, nil, nil) }()
+	time.Sleep(20 * time.Millisecond)
+
+	cancel()
+	is.True(<-firstErr != nil)
+	doer.waitForCalls(t, 2) // the waiter makes its own request
+	close(doer.release)
+	is.NoErr(<-secondErr)
+}
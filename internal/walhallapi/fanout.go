@@ -0,0 +1,127 @@
+package walhallapi
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultParallelism is how many calls to Walhall an APIState makes at once when it fans out.
+const DefaultParallelism = 4
+
+// ItemError is the failure of one item of a fan-out.
+type ItemError struct {
+	Index int
+	Err   error
+}
+
+// PartialError reports that some items of a fan-out failed. The results of the other items are valid.
+type PartialError struct {
+	Total    int
+	Failures []ItemError
+}
+
+func (e *PartialError) Error() string {
+	messages := make([]string, len(e.Failures))
+	for i, failure := range e.Failures {
+		messages[i] = failure.Err.Error()
+	}
+	return fmt.Sprintf("%d of %d calls failed: %s", len(e.Failures), e.Total, strings.Join(messages, "; "))
+}
+
+// Unwrap returns the first failure, so that errors.Is and errors.As see e.g. a circuit breaker error.
+func (e *PartialError) Unwrap() error {
+	if len(e.Failures) == 0 {
+		return nil
+	}
+	return e.Failures[0].Err
+}
+
+// FanOut calls fn for each index in [0, n) with at most parallelism calls running at once. A failing call does not
+// stop the others; all failures are returned, ordered by index, in a *PartialError. Once ctx is done no further calls
+// are started and the remaining items fail with ctx's error.
+func FanOut(ctx context.Context, n, parallelism int, fn func(ctx context.Context, i int) error) error {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	var mu sync.Mutex
+	var failures []ItemError
+	fail := func(i int, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		failures = append(failures, ItemError{Index: i, Err: err})
+	}
+
+	slots := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		if err := ctx.Err(); err != nil {
+			fail(i, err)
+			continue
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			fail(i, ctx.Err())
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			if err := fn(ctx, i); err != nil {
+				fail(i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if len(failures) == 0 {
+		return nil
+	}
+	sort.Slice(failures, func(i, j int) bool { return failures[i].Index < failures[j].Index })
+	return &PartialError{Total: n, Failures: failures}
+}
+
+// SetParallelism sets how many calls to Walhall are made at once when a method fans out.
+func (a *APIState) SetParallelism(n int) {
+	a.parallelism = n
+}
+
+// EnvironmentDetail is an environment together with the configurations of each of its module versions.
+type EnvironmentDetail struct {
+	Environment
+	// Configs maps module version IDs to their configurations in the environment.
+	Configs map[int][]Config
+}
+
+// GetEnvDetail fetches an environment and the configurations of all its module versions in parallel. If only some
+// configurations could be fetched, the detail holds those and a *PartialError is returned whose item indexes refer to
+// env.ModuleVersions.
+func (a *APIState) GetEnvDetail(orgName, appName, envName string) (EnvironmentDetail, error) {
+	env, err := a.GetEnv(orgName, appName, envName)
+	if err != nil {
+		return EnvironmentDetail{}, err
+	}
+	configs := make([][]Config, len(env.ModuleVersions))
+	fetched := make([]bool, len(env.ModuleVersions))
+	err = FanOut(a.ctx, len(env.ModuleVersions), a.parallelism, func(ctx context.Context, i int) error {
+		var err error
+		configs[i], err = a.GetConfigsForModuleVersionInEnv(env, env.ModuleVersions[i].ModuleVersion)
+		fetched[i] = err == nil
+		return err
+	})
+
+	detail := EnvironmentDetail{Environment: env, Configs: make(map[int][]Config)}
+	for i, mv := range env.ModuleVersions {
+		if fetched[i] {
+			detail.Configs[mv.ID] = configs[i]
+		}
+	}
+	if err != nil {
+		return detail, fmt.Errorf("get env detail: %w", err)
+	}
+	return detail, nil
+}
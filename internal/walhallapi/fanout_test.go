@@ -0,0 +1,92 @@
+package walhallapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestFanOut(t *testing.T) {
+	is := is.New(t)
+	var running, maxRunning int32
+	results := make([]int, 10)
+	err := FanOut(context.Background(), len(results), 3, func(ctx context.Context, i int) error {
+		now := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if now <= max || atomic.CompareAndSwapInt32(&maxRunning, max, now) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		if i%4 == 1 {
+			return fmt.Errorf("item %d failed", i)
+		}
+		results[i] = i * i
+		return nil
+	})
+
+	is.True(atomic.LoadInt32(&maxRunning) <= 3) // parallelism is bounded
+	var partial *PartialError
+	is.True(errors.As(err, &partial))
+	is.Equal(partial.Total, 10)
+	is.Equal(len(partial.Failures), 3)
+	is.Equal(partial.Failures[0].Index, 1) // failures are ordered by index
+	is.Equal(partial.Failures[2].Index, 9)
+	is.Equal(results[8], 64) // other items still ran
+}
+
+func TestFanOutStopsWhenContextIsDone(t *testing.T) {
+	is := is.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls int32
+	err := FanOut(ctx, 5, 1, func(ctx context.Context, i int) error {
+		atomic.AddInt32(&calls, 1)
+		cancel()
+		return nil
+	})
+	is.True(atomic.LoadInt32(&calls) < 5)
+	is.True(errors.Is(err, context.Canceled))
+}
+
+func TestGetEnvDetail(t *testing.T) {
+	is := is.New(t)
+	const envUUID = "fa9852ef-963c-45a8-a420-0f099543c989"
+	responses := map[string]string{
+		"/api/walhalluser/0b618579-f546-4338-9ece-a1c981f90c80":                             getUserResponse,
+		"/api/application?limit=100&organization_uuid=f33f013e-e532-4b27-958e-50220a18a2bd": getListAppsResponse,
+		"/api/environments?application=10a1604d-da69-4e12-a5c6-ac5fad87ae62":                `{"results": [{"env_uuid": "` + envUUID + `", "name": "Development", "logic_module_versions": [{"id": 1}, {"id": 2}, {"id": 3}]}]}`,
+		"/api/configuration?logic_module_version=1&environment=" + envUUID:                  `{"results": [{"id": 11, "type": "config-map"}]}`,
+		"/api/configuration?logic_module_version=3&environment=" + envUUID:                  `{"results": []}`,
+	}
+	client := doerFunc(func(req *http.Request) (*http.Response, error) {
+		body, ok := responses[req.URL.RequestURI()]
+		if !ok {
+			return nil, errors.New("connection reset")
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+	})
+	api, err := New("http://api.walhall.io", exampleJWT, client)
+	is.NoErr(err)
+	api.SetParallelism(2)
+
+	detail, err := api.GetEnvDetail("corporate-org", "test-app-one", "Development")
+	var partial *PartialError
+	is.True(errors.As(err, &partial))
+	is.Equal(len(partial.Failures), 1)
+	is.Equal(partial.Failures[0].Index, 1) // module version 2
+	is.Equal(detail.UUID, envUUID)
+	is.Equal(len(detail.Configs), 2)
+	is.Equal(detail.Configs[1][0].ID, 11)
+	_, ok := detail.Configs[2]
+	is.True(!ok)
+}
@@ -0,0 +1,60 @@
+package walhallapi
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"humanitec.io/walhallapiadaptor/internal/metrics"
+)
+
+var (
+	upstreamRequests = metrics.Default.NewCounterVec("walhallapiadaptor_upstream_requests_total",
+		"Requests made to Walhall Core by endpoint, method and status code (\"error\" if no response was received).",
+		"endpoint", "method", "status")
+	upstreamDuration = metrics.Default.NewHistogramVec("walhallapiadaptor_upstream_request_duration_seconds",
+		"Time until the response headers from Walhall Core were received.",
+		metrics.DefaultBuckets, "endpoint", "method")
+	cacheLookups = metrics.Default.NewCounterVec("walhallapiadaptor_cache_lookups_total",
+		"Lookups in the per-request Walhall response cache by operation and result (\"hit\" or \"miss\").",
+		"operation", "result")
+	upstreamRetries = metrics.Default.NewCounterVec("walhallapiadaptor_upstream_retries_total",
+		"Requests to Walhall Core which were retried after a timeout or 5xx response.",
+		"endpoint", "method")
+	upstreamCoalesced = metrics.Default.NewCounterVec("walhallapiadaptor_upstream_coalesced_total",
+		"GET requests to Walhall Core which were served by an identical concurrent request instead of their own.",
+		"endpoint")
+	upstreamRejected = metrics.Default.NewCounterVec("walhallapiadaptor_upstream_circuit_open_total",
+		"Requests to Walhall Core which failed immediately because the circuit breaker for the host was open.",
+		"host")
+)
+
+// idSegment matches path segments which identify a particular entity (UUIDs or numeric IDs).
+var idSegment = regexp.MustCompile(`^([0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}|[0-9]+)$`)
+
+// endpointTemplate reduces a Walhall URL to its path with IDs replaced, so that it can be used as a metric label
+// without creating a series per entity. e.g. "/api/environments/<uuid>/deploy" becomes "/api/environments/{id}/deploy".
+func endpointTemplate(url string) string {
+	if i := strings.IndexByte(url, '?'); i >= 0 {
+		url = url[:i]
+	}
+	segments := strings.Split(url, "/")
+	for i, segment := range segments {
+		if idSegment.MatchString(segment) {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func statusLabel(code int) string {
+	return strconv.Itoa(code)
+}
+
+func recordCacheLookup(operation string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheLookups.Inc(operation, result)
+}
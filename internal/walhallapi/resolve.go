@@ -0,0 +1,218 @@
+package walhallapi
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"humanitec.io/walhallapiadaptor/internal/semver"
+)
+
+// ParseModuleRef splits a reference like auth@^1.2 into the module name and a version range. Without a range, the
+// latest version is meant.
+func ParseModuleRef(ref string) (string, semver.Constraint, error) {
+	name, constraint := ref, semver.Latest
+	if i := strings.LastIndexByte(ref, '@'); i >= 0 {
+		name, constraint = ref[:i], ref[i+1:]
+	}
+	if name == "" {
+		return "", semver.Constraint{}, fmt.Errorf("module reference %q has no module name", ref)
+	}
+	c, err := semver.ParseConstraint(constraint)
+	if err != nil {
+		return "", semver.Constraint{}, fmt.Errorf("module reference %q: %w", ref, err)
+	}
+	return name, c, nil
+}
+
+// TagPolicy decides how versions whose tags are not semantic versions, like NEW_TAG, are ordered among the others.
+// Tags which lack minor or patch numbers, like 1.0, count as semantic versions.
+type TagPolicy string
+
+const (
+	// TagsOldest orders other tags before all semantic versions. They are only the latest version of a module which
+	// has no semantic versions. This is the default.
+	TagsOldest TagPolicy = "oldest"
+	// TagsNewest orders other tags after all semantic versions, so that the newest of them is the latest version.
+	TagsNewest TagPolicy = "newest"
+	// TagsExcluded orders other tags like TagsOldest, but never picks one as the latest version.
+	TagsExcluded TagPolicy = "exclude"
+)
+
+// ParseTagPolicy returns the policy named s; empty means TagsOldest.
+func ParseTagPolicy(s string) (TagPolicy, error) {
+	switch policy := TagPolicy(s); policy {
+	case "":
+		return TagsOldest, nil
+	case TagsOldest, TagsNewest, TagsExcluded:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown tag policy %q, must be one of %s, %s or %s", s, TagsOldest, TagsNewest, TagsExcluded)
+}
+
+// Sort orders module versions from oldest to newest. Semantic versions are ordered by precedence, other tags by ID.
+func (p TagPolicy) Sort(versions []ModuleVersion) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		a, aErr := semver.ParseTolerant(versions[i].Version)
+		b, bErr := semver.ParseTolerant(versions[j].Version)
+		switch {
+		case aErr != nil && bErr != nil:
+			return versions[i].ID < versions[j].ID
+		case aErr != nil || bErr != nil:
+			// Only one of them is a semantic version
+			return (aErr != nil) != (p == TagsNewest)
+		}
+		if c := a.Compare(b); c != 0 {
+			return c < 0
+		}
+		return versions[i].ID < versions[j].ID
+	})
+}
+
+// Latest returns the newest release in versions, in the order of Sort, or else the newest prerelease. Other tags are
+// releases with TagsNewest; with TagsOldest, the newest of them is only picked if there are no semantic versions at
+// all. It returns false if there is no such version.
+func (p TagPolicy) Latest(versions []ModuleVersion) (ModuleVersion, bool) {
+	sorted := append([]ModuleVersion(nil), versions...)
+	p.Sort(sorted)
+	var prerelease, tag *ModuleVersion
+	for i := len(sorted) - 1; i >= 0; i-- {
+		v, err := semver.ParseTolerant(sorted[i].Version)
+		switch {
+		case err != nil && p != TagsNewest:
+			if tag == nil && p != TagsExcluded {
+				tag = &sorted[i]
+			}
+			continue
+		case err == nil && len(v.Prerelease) > 0:
+			if prerelease == nil {
+				prerelease = &sorted[i]
+			}
+			continue
+		}
+		return sorted[i], true
+	}
+	if prerelease != nil {
+		return *prerelease, true
+	}
+	if tag != nil {
+		return *tag, true
+	}
+	return ModuleVersion{}, false
+}
+
+// SortVersions orders module versions from oldest to newest with TagsOldest.
+func SortVersions(versions []ModuleVersion) {
+	TagsOldest.Sort(versions)
+}
+
+// LatestMatching returns the newest version of module which satisfies c, in the order of Sort. A version tagged
+// exactly like c, e.g. 1.2 or latest, is returned before any other, so that such a tag is not read as a range.
+// Otherwise latest is the version picked by Latest.
+func (p TagPolicy) LatestMatching(module Module, c semver.Constraint) (ModuleVersion, bool) {
+	versions := append([]ModuleVersion(nil), module.Versions...)
+	p.Sort(versions)
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].Version == c.String() {
+			return versions[i], true
+		}
+	}
+	if c.String() == semver.Latest {
+		return p.Latest(versions)
+	}
+	for i := len(versions) - 1; i >= 0; i-- {
+		if c.Match(versions[i].Version) {
+			return versions[i], true
+		}
+	}
+	return ModuleVersion{}, false
+}
+
+// Resolver looks up module versions by reference in one org. The modules are listed once, on first use.
+type Resolver struct {
+	walhall WalhallAPIer
+	orgName string
+	policy  TagPolicy
+
+	once    sync.Once
+	modules map[string]Module
+	err     error
+}
+
+// NewResolver returns a Resolver for the modules of orgName.
+func NewResolver(walhall WalhallAPIer, orgName string) *Resolver {
+	return &Resolver{walhall: walhall, orgName: orgName, policy: TagsOldest}
+}
+
+// SetTagPolicy sets how r orders tags which are not semantic versions. The default is TagsOldest.
+func (r *Resolver) SetTagPolicy(p TagPolicy) {
+	r.policy = p
+}
+
+// Resolve returns the newest version of the named module which satisfies c (see TagPolicy.LatestMatching). It fails
+// with ErrNotFound if there is no such module or version.
+func (r *Resolver) Resolve(name string, c semver.Constraint) (ModuleVersion, error) {
+	r.once.Do(func() {
+		var modules []Module
+		modules, r.err = r.walhall.ListModules(r.orgName)
+		r.modules = make(map[string]Module, len(modules))
+		for _, module := range modules {
+			r.modules[module.Name] = module
+		}
+	})
+	if r.err != nil {
+		return ModuleVersion{}, r.err
+	}
+	module, ok := r.modules[name]
+	if !ok {
+		return ModuleVersion{}, fmt.Errorf("module %s: %w", name, ErrNotFound)
+	}
+	mv, ok := r.policy.LatestMatching(module, c)
+	if !ok {
+		return ModuleVersion{}, fmt.Errorf("module %s@%s: %w", name, c, ErrNotFound)
+	}
+	return mv, nil
+}
+
+// ResolveRef resolves a reference like auth@^1.2 (see ParseModuleRef).
+func (r *Resolver) ResolveRef(ref string) (ModuleVersion, error) {
+	name, c, err := ParseModuleRef(ref)
+	if err != nil {
+		return ModuleVersion{}, err
+	}
+	return r.Resolve(name, c)
+}
+
+// SetModuleVersions resolves refs (see ParseModuleRef) against the modules of orgName and sets those versions in env
+// with one PatchEnv. The other modules of env keep their versions; referenced modules which are not in env are added.
+func SetModuleVersions(walhall WalhallAPIer, orgName string, env Environment, refs []string) (Environment, error) {
+	return NewResolver(walhall, orgName).SetModuleVersions(env, refs)
+}
+
+// SetModuleVersions is like the function of the same name, with the modules and tag policy of r.
+func (r *Resolver) SetModuleVersions(env Environment, refs []string) (Environment, error) {
+	var ids []int
+	positions := make(map[string]int)
+	for _, mv := range env.ModuleVersions {
+		positions[mv.Module.Name] = len(ids)
+		ids = append(ids, mv.ID)
+	}
+	for _, ref := range refs {
+		name, c, err := ParseModuleRef(ref)
+		if err != nil {
+			return Environment{}, err
+		}
+		mv, err := r.Resolve(name, c)
+		if err != nil {
+			return Environment{}, err
+		}
+		if i, ok := positions[name]; ok {
+			ids[i] = mv.ID
+		} else {
+			positions[name] = len(ids)
+			ids = append(ids, mv.ID)
+		}
+	}
+	return r.walhall.PatchEnv(env, ids)
+}
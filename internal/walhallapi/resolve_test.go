@@ -0,0 +1,136 @@
+package walhallapi
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+type modulesLister struct {
+	WalhallAPIer
+	modules []Module
+	calls   int
+}
+
+func (l *modulesLister) ListModules(orgName string) ([]Module, error) {
+	l.calls++
+	return l.modules, nil
+}
+
+func versionsOf(versions []ModuleVersion) []string {
+	tags := make([]string, len(versions))
+	for i, version := range versions {
+		tags[i] = version.Version
+	}
+	return tags
+}
+
+func TestSortVersions(t *testing.T) {
+	is := is.New(t)
+	versions := []ModuleVersion{
+		{ID: 5, Version: "NEW_TAG"}, {ID: 4, Version: "1.10.0"}, {ID: 3, Version: "1.2.0"},
+		{ID: 6, Version: "2.0.0-rc.1"}, {ID: 1, Version: "OLD_TAG"}, {ID: 2, Version: "v1.2.1"},
+	}
+	SortVersions(versions)
+	is.Equal(versionsOf(versions), []string{"OLD_TAG", "NEW_TAG", "1.2.0", "v1.2.1", "1.10.0", "2.0.0-rc.1"})
+}
+
+func TestTagPolicy(t *testing.T) {
+	is := is.New(t)
+	versions := []ModuleVersion{{ID: 3, Version: "NEW_TAG"}, {ID: 2, Version: "1.1.0"}, {ID: 1, Version: "1.0"}}
+
+	sorted := append([]ModuleVersion(nil), versions...)
+	TagsNewest.Sort(sorted)
+	is.Equal(versionsOf(sorted), []string{"1.0", "1.1.0", "NEW_TAG"})
+
+	for policy, id := range map[TagPolicy]int{TagsOldest: 2, TagsNewest: 3, TagsExcluded: 2} {
+		latest, ok := policy.Latest(versions)
+		is.True(ok)
+		is.Equal(latest.ID, id) // latest version
+	}
+
+	latest, ok := TagsOldest.Latest([]ModuleVersion{{ID: 2, Version: "2.0.0-rc.1"}, {ID: 1, Version: "1.0.0"}})
+	is.True(ok)
+	is.Equal(latest.ID, 1) // releases are preferred over prereleases
+	latest, ok = TagsOldest.Latest([]ModuleVersion{{ID: 1, Version: "NEW_TAG"}, {ID: 2, Version: "2.0.0-rc.1"}})
+	is.True(ok)
+	is.Equal(latest.ID, 2) // prereleases are preferred over other tags
+	latest, ok = TagsOldest.Latest([]ModuleVersion{{ID: 1, Version: "NEW_TAG"}})
+	is.True(ok)
+	is.Equal(latest.ID, 1)
+	_, ok = TagsExcluded.Latest([]ModuleVersion{{ID: 1, Version: "NEW_TAG"}})
+	is.True(!ok)
+	_, ok = TagsOldest.Latest(nil)
+	is.True(!ok)
+
+	policy, err := ParseTagPolicy("")
+	is.NoErr(err)
+	is.Equal(policy, TagsOldest)
+	_, err = ParseTagPolicy("first")
+	is.True(err != nil)
+}
+
+func TestResolver(t *testing.T) {
+	is := is.New(t)
+	lister := &modulesLister{modules: []Module{
+		{Name: "auth", Versions: []ModuleVersion{
+			{ID: 4, Version: "NEW_TAG"}, {ID: 3, Version: "2.0.0-rc.1"}, {ID: 2, Version: "1.10.0"}, {ID: 1, Version: "1.2.0"},
+		}},
+		{Name: "web", Versions: []ModuleVersion{{ID: 5, Version: "NEW_TAG"}, {ID: 6, Version: "OTHER_TAG"}}},
+		{Name: "api", Versions: []ModuleVersion{
+			{ID: 7, Version: "1.2"}, {ID: 8, Version: "1.2.5"}, {ID: 9, Version: "1"}, {ID: 10, Version: "latest"},
+		}},
+	}}
+	resolver := NewResolver(lister, "my-org")
+
+	for ref, id := range map[string]int{
+		"auth":             2,
+		"auth@latest":      2,
+		"auth@^1.2":        2,
+		"auth@~1.2":        1,
+		"auth@1.2.0":       1,
+		"auth@>=2.0.0-rc":  3,
+		"auth@NEW_TAG":     4,
+		"web":              6,
+		"web@NEW_TAG":      5,
+		"auth@>1.0 <=1.10": 2,
+		// Tags which look like ranges pin exactly that tag
+		"api@1.2":    7,
+		"api@~1.2":   8,
+		"api@1":      9,
+		"api@^1":     8,
+		"api@latest": 10,
+	} {
+		mv, err := resolver.ResolveRef(ref)
+		is.NoErr(err)
+		is.Equal(mv.ID, id) // resolved version
+	}
+	is.Equal(lister.calls, 1) // modules are listed once
+
+	for _, ref := range []string{"db", "auth@^3", "web@^1", "auth@OTHER_TAG"} {
+		_, err := resolver.ResolveRef(ref)
+		is.True(errors.Is(err, ErrNotFound))
+	}
+	for _, ref := range []string{"@1.0.0", "auth@^x"} {
+		_, err := resolver.ResolveRef(ref)
+		is.True(err != nil)
+		is.True(!errors.Is(err, ErrNotFound))
+	}
+
+	// The tag policy decides whether other tags are the latest version
+	resolver.SetTagPolicy(TagsNewest)
+	mv, err := resolver.ResolveRef("auth@latest")
+	is.NoErr(err)
+	is.Equal(mv.ID, 4)
+	mv, err = resolver.ResolveRef("auth@^1.2")
+	is.NoErr(err)
+	is.Equal(mv.ID, 2)
+	resolver.SetTagPolicy(TagsExcluded)
+	_, err = resolver.ResolveRef("web@latest")
+	is.True(errors.Is(err, ErrNotFound))
+	mv, err = resolver.ResolveRef("web@NEW_TAG")
+	is.NoErr(err)
+	is.Equal(mv.ID, 5)
+	is.Equal(lister.calls, 1)
+}
@@ -0,0 +1,149 @@
+package walhallapi
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"humanitec.io/walhallapiadaptor/internal/logging"
+)
+
+// RetryPolicy controls how RetryingDoer retries failed requests.
+type RetryPolicy struct {
+	// MaxRetries is the number of attempts made after the first one.
+	MaxRetries int
+	// BaseDelay is the backoff before the first retry. It doubles for every further retry.
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff. A Retry-After longer than this is not waited for.
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy retries twice, after roughly 100ms and 200ms.
+var DefaultRetryPolicy = RetryPolicy{MaxRetries: 2, BaseDelay: 100 * time.Millisecond, MaxDelay: 2 * time.Second}
+
+// RetryingDoer retries reads (GET, HEAD and OPTIONS) which time out or receive a 5xx response, using jittered
+// exponential backoff and honoring Retry-After. Other requests are passed through untouched: Walhall may have acted on
+// a request which failed, and e.g. a deploy must not be repeated.
+type RetryingDoer struct {
+	doer   Doer
+	policy RetryPolicy
+
+	// sleep waits for d or until ctx is done. It is replaced in tests.
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewRetryingDoer wraps doer.
+func NewRetryingDoer(doer Doer, policy RetryPolicy) *RetryingDoer {
+	return &RetryingDoer{doer: doer, policy: policy, sleep: sleepContext}
+}
+
+// Do implements Doer.
+func (d *RetryingDoer) Do(req *http.Request) (*http.Response, error) {
+	if !retryable(req.Method) || (req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) {
+		return d.doer.Do(req)
+	}
+	ctx := req.Context()
+	attemptReq := req
+	for attempt := 0; ; attempt++ {
+		resp, err := d.doer.Do(attemptReq)
+		if attempt >= d.policy.MaxRetries || !shouldRetry(ctx, resp, err) {
+			return resp, err
+		}
+		delay := d.backoff(attempt)
+		if retryAfter, ok := parseRetryAfter(resp); ok {
+			if retryAfter > d.policy.MaxDelay {
+				return resp, err
+			}
+			delay = retryAfter
+		}
+		if resp != nil {
+			drainAndClose(resp.Body)
+		}
+
+		logger := logging.FromContext(ctx).With("component", "walhallapi", "method", req.Method, "url", req.URL.String())
+		if err != nil {
+			logger.Warn("[walhallapi] retrying upstream request", "attempt", attempt+1, "delay_ms", delay, "error", err)
+		} else {
+			logger.Warn("[walhallapi] retrying upstream request", "attempt", attempt+1, "delay_ms", delay, "status", resp.StatusCode)
+		}
+		upstreamRetries.Inc(endpointTemplate(req.URL.Path), req.Method)
+
+		if err := d.sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+		attemptReq = req.Clone(ctx)
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq.Body = body
+		}
+	}
+}
+
+// backoff returns the delay before retry number attempt+1: half of it fixed, half random.
+func (d *RetryingDoer) backoff(attempt int) time.Duration {
+	delay := d.policy.BaseDelay << uint(attempt)
+	if delay <= 0 || delay > d.policy.MaxDelay {
+		delay = d.policy.MaxDelay
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+func retryable(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+func shouldRetry(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		var netErr net.Error
+		return errors.As(err, &netErr) && netErr.Timeout()
+	}
+	return resp.StatusCode >= http.StatusInternalServerError && resp.StatusCode != http.StatusNotImplemented
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an HTTP date.
+func parseRetryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		delay := time.Until(date)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
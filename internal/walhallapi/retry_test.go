@@ -0,0 +1,161 @@
+package walhallapi
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func fakeResponse(status int, header http.Header) *http.Response {
+	return &http.Response{StatusCode: status, Header: header, Body: ioutil.NopCloser(strings.NewReader("{}"))}
+}
+
+// newTestRetryingDoer records the delays instead of sleeping.
+func newTestRetryingDoer(doer Doer, delays *[]time.Duration) *RetryingDoer {
+	d := NewRetryingDoer(doer, RetryPolicy{MaxRetries: 2, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second})
+	d.sleep = func(ctx context.Context, delay time.Duration) error {
+		*delays = append(*delays, delay)
+		return ctx.Err()
+	}
+	return d
+}
+
+func TestRetryingDoerRetriesReads(t *testing.T) {
+	is := is.New(t)
+	var bodies []string
+	statuses := []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusOK}
+	var delays []time.Duration
+	d := newTestRetryingDoer(doerFunc(func(req *http.Request) (*http.Response, error) {
+		body, _ := ioutil.ReadAll(req.Body)
+		bodies = append(bodies, string(body))
+		status := statuses[0]
+		statuses = statuses[1:]
+		return fakeResponse(status, nil), nil
+	}), &delays)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://walhall/api/configuration/1", bytes.NewBufferString(`{"a":1}`))
+	resp, err := d.Do(req)
+	is.NoErr(err)
+	is.Equal(resp.StatusCode, http.StatusOK)
+	is.Equal(bodies, []string{`{"a":1}`, `{"a":1}`, `{"a":1}`}) // the body is resent
+	is.Equal(len(delays), 2)
+	is.True(delays[0] >= 50*time.Millisecond && delays[0] <= 100*time.Millisecond)
+	is.True(delays[1] >= 100*time.Millisecond && delays[1] <= 200*time.Millisecond)
+}
+
+func TestRetryingDoerGivesUp(t *testing.T) {
+	is := is.New(t)
+	calls := 0
+	var delays []time.Duration
+	d := newTestRetryingDoer(doerFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		return nil, timeoutError{}
+	}), &delays)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://walhall/api/walhalluser/1", nil)
+	_, err := d.Do(req)
+	is.Equal(err, timeoutError{})
+	is.Equal(calls, 3) // the first attempt and MaxRetries retries
+}
+
+func TestRetryingDoerDoesNotRetry(t *testing.T) {
+	for name, tc := range map[string]struct {
+		method string
+		status int
+		err    error
+	}{
+		"POST":            {http.MethodPost, http.StatusServiceUnavailable, nil},
+		"PATCH":           {http.MethodPatch, http.StatusServiceUnavailable, nil},
+		"PUT":             {http.MethodPut, http.StatusServiceUnavailable, nil},
+		"DELETE":          {http.MethodDelete, http.StatusServiceUnavailable, nil},
+		"PUT timeout":     {http.MethodPut, 0, timeoutError{}},
+		"client error":    {http.MethodGet, http.StatusNotFound, nil},
+		"not implemented": {http.MethodGet, http.StatusNotImplemented, nil},
+		"circuit open":    {http.MethodGet, 0, &CircuitOpenError{Host: "walhall"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			is := is.New(t)
+			calls := 0
+			var delays []time.Duration
+			d := newTestRetryingDoer(doerFunc(func(req *http.Request) (*http.Response, error) {
+				calls++
+				if tc.err != nil {
+					return nil, tc.err
+				}
+				return fakeResponse(tc.status, nil), nil
+			}), &delays)
+
+			req, _ := http.NewRequest(tc.method, "http://walhall/api/logicmodule", nil)
+			d.Do(req)
+			is.Equal(calls, 1)
+		})
+	}
+}
+
+func TestDeployIsNotRetried(t *testing.T) {
+	is := is.New(t)
+	calls := 0
+	var delays []time.Duration
+	d := newTestRetryingDoer(doerFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		return fakeResponse(http.StatusServiceUnavailable, nil), nil
+	}), &delays)
+	api, err := New("http://walhall", exampleJWT, d)
+	is.NoErr(err)
+
+	err = api.DeployToEnvironment(Environment{UUID: "env"})
+	is.True(err != nil)
+	is.Equal(calls, 1) // Walhall may have started the deploy
+}
+
+func TestRetryingDoerHonorsRetryAfter(t *testing.T) {
+	is := is.New(t)
+	var delays []time.Duration
+	retryAfter := "1"
+	d := newTestRetryingDoer(doerFunc(func(req *http.Request) (*http.Response, error) {
+		return fakeResponse(http.StatusServiceUnavailable, http.Header{"Retry-After": []string{retryAfter}}), nil
+	}), &delays)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://walhall/api/logicmodule", nil)
+	resp, err := d.Do(req)
+	is.NoErr(err)
+	is.Equal(resp.StatusCode, http.StatusServiceUnavailable)
+	is.Equal(delays, []time.Duration{time.Second, time.Second})
+
+	// Retry-After longer than MaxDelay is returned to the caller straight away
+	delays = nil
+	retryAfter = "120"
+	resp, err = d.Do(req)
+	is.NoErr(err)
+	is.Equal(resp.StatusCode, http.StatusServiceUnavailable)
+	is.Equal(len(delays), 0)
+}
+
+func TestRetryingDoerStopsWhenContextIsDone(t *testing.T) {
+	is := is.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	d := NewRetryingDoer(doerFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		cancel()
+		return fakeResponse(http.StatusBadGateway, nil), nil
+	}), DefaultRetryPolicy)
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://walhall/api/logicmodule", nil)
+	resp, err := d.Do(req)
+	is.NoErr(err)
+	is.Equal(resp.StatusCode, http.StatusBadGateway)
+	is.Equal(calls, 1)
+}
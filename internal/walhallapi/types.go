@@ -50,6 +50,7 @@ type WalhallAPIer interface {
 	GetRefreshModulesStatus(orgName string) (string, error)
 	ListEnvs(orgName, appName string) ([]Environment, error)
 	GetEnv(orgName, appName, envName string) (Environment, error)
+	GetEnvDetail(orgName, appName, envName string) (EnvironmentDetail, error)
 	PatchEnv(env Environment, moduleVersions []int) (Environment, error)
 	DeleteModuleVersionFromEnv(env Environment, mv ModuleVersion) (Environment, error)
 	GetConfigsForModuleVersionInEnv(env Environment, mv ModuleVersion) ([]Config, error)
@@ -1,21 +1,60 @@
 package walhallapi
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"io/ioutil"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
+	"humanitec.io/walhallapiadaptor/internal/logging"
+	"humanitec.io/walhallapiadaptor/internal/tracing"
 )
 
+// RequestIDHeader is used to correlate requests to Walhall with the request to the adaptor which caused them.
+const RequestIDHeader = "X-Request-ID"
+
+// MaxResponseSize is the largest response body from Walhall which is decoded.
+const MaxResponseSize = 8 << 20
+
+// drainLimit is how much of an unread response body is discarded so that the connection can be reused. Longer
+// bodies are closed without reading them to the end.
+const drainLimit = 64 << 10
+
+// ErrResponseTooLarge is returned if a response from Walhall is longer than MaxResponseSize.
+var ErrResponseTooLarge = errors.New("response too large")
+
+// StatusError is returned when Walhall responds with an unexpected status code.
+type StatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %s: unexpected status %d", e.Method, e.URL, e.StatusCode)
+}
+
 type APIState struct {
+	ctx       context.Context
 	claims    WalhallClaims
 	jwt       string
 	apiPrefix string
 	doer      Doer
 	cache     map[string]interface{}
+	cacheMu   sync.Mutex
+	coalescer *Coalescer
+	// parallelism bounds concurrent calls when fanning out (see FanOut)
+	parallelism int
+	// access identifies what the token may see, for sharing responses (see Coalesce)
+	access string
 }
 
 type Doer interface {
@@ -31,23 +70,50 @@ type WalhallClaims struct {
 }
 
 func New(apiPrefix, jwt string, doer Doer) (*APIState, error) {
+	return NewWithContext(context.Background(), apiPrefix, jwt, doer)
+}
+
+// NewWithContext is like New, but all requests to Walhall are made with ctx. The logger and request ID in ctx (see
+// package logging) are used for upstream request logs.
+func NewWithContext(ctx context.Context, apiPrefix, jwt string, doer Doer) (*APIState, error) {
 	// There is some funkiness with when the JWT string gets garbage collected, so use replace to guarantee a copy
 	jwt = strings.Replace(jwt, "JWT ", "", 1)
+	// Walhall only understands the JWT scheme, so standard bearer tokens are converted
+	jwt = strings.TrimPrefix(jwt, "Bearer ")
 	claims, err := claimsFromJWT(jwt)
 	if err != nil {
 		return nil, err
 	}
 	return &APIState{
+		ctx:       ctx,
 		claims:    claims,
 		jwt:       "JWT " + jwt,
 		apiPrefix: apiPrefix,
 		doer:      doer,
 		cache:     make(map[string]interface{}),
+
+		parallelism: DefaultParallelism,
 	}, nil
 }
 
+// cached returns the result stored for key, recording the lookup for operation.
+func (a *APIState) cached(operation, key string) (interface{}, bool) {
+	a.cacheMu.Lock()
+	defer a.cacheMu.Unlock()
+	result, ok := a.cache[key]
+	recordCacheLookup(operation, ok)
+	return result, ok
+}
+
+// store caches the result for key. Callers may store concurrently, e.g. when fanning out.
+func (a *APIState) store(key string, result interface{}) {
+	a.cacheMu.Lock()
+	defer a.cacheMu.Unlock()
+	a.cache[key] = result
+}
+
 func claimsFromJWT(JWT string) (WalhallClaims, error) {
-	parser := jwt.Parser{nil, false, true}
+	parser := jwt.Parser{SkipClaimsValidation: true}
 	var claims WalhallClaims
 	_, _, err := (&parser).ParseUnverified(JWT, &claims)
 	if err != nil {
@@ -57,19 +123,133 @@ func claimsFromJWT(JWT string) (WalhallClaims, error) {
 }
 
 func (a *APIState) makeRequest(method string, url string, body io.Reader) (*http.Response, error) {
+	endpoint := endpointTemplate(url)
+	ctx, span := tracing.Start(a.ctx, method+" "+endpoint, tracing.KindClient)
+	defer span.End()
+	span.SetAttribute("http.method", method)
+	span.SetAttribute("http.url", a.apiPrefix+url)
+
 	var req *http.Request
 	var err error
 	// We need to handle typed and non-typed nils (see: https://golang.org/doc/faq#nil_error)
 	if body == nil {
-		req, err = http.NewRequest(method, a.apiPrefix+url, nil)
+		req, err = http.NewRequestWithContext(ctx, method, a.apiPrefix+url, nil)
 	} else {
-		req, err = http.NewRequest(method, a.apiPrefix+url, body)
+		req, err = http.NewRequestWithContext(ctx, method, a.apiPrefix+url, body)
 	}
 	if err != nil {
+		span.RecordError(err)
 		return nil, fmt.Errorf("make request: %w", err)
 	}
+	tracing.Inject(ctx, req.Header)
 	req.Header["authorization"] = []string{a.jwt}
 	req.Header["Content-Type"] = []string{"application/json"}
-	log.Printf("[walhallapi] %s %s%s", method, a.apiPrefix, url)
-	return a.doer.Do(req)
+	if requestID := logging.RequestID(a.ctx); requestID != "" {
+		req.Header.Set(RequestIDHeader, requestID)
+	}
+
+	logger := logging.FromContext(a.ctx).With("component", "walhallapi", "method", method, "url", a.apiPrefix+url)
+	start := time.Now()
+	resp, err := a.doer.Do(req)
+	duration := time.Since(start)
+	upstreamDuration.Observe(duration.Seconds(), endpoint, method)
+	if err != nil {
+		upstreamRequests.Inc(endpoint, method, "error")
+		span.RecordError(err)
+		logger.Error("[walhallapi] upstream request failed", "duration_ms", duration, "error", err)
+		return resp, err
+	}
+	upstreamRequests.Inc(endpoint, method, statusLabel(resp.StatusCode))
+	span.SetAttribute("http.status_code", resp.StatusCode)
+	if resp.StatusCode >= http.StatusInternalServerError {
+		span.SetStatus(tracing.StatusError, resp.Status)
+	}
+	logger.Info("[walhallapi] upstream request", "status", resp.StatusCode, "duration_ms", duration)
+	return resp, nil
+}
+
+// doJSON makes a request to Walhall and, if the response status is one of expected (200 if none are given), decodes
+// the JSON body into out unless out is nil. Unexpected statuses are returned as a *StatusError. GET requests are
+// coalesced with identical concurrent ones if Coalesce was called.
+func (a *APIState) doJSON(method, url string, body io.Reader, out interface{}, expected ...int) error {
+	var resp response
+	var err error
+	if method == http.MethodGet && a.coalescer != nil {
+		resp, err = a.fetchShared(url)
+	} else {
+		resp, err = a.fetch(method, url, body)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(expected) == 0 {
+		expected = []int{http.StatusOK}
+	}
+	ok := false
+	for _, status := range expected {
+		ok = ok || resp.status == status
+	}
+	if !ok {
+		return &StatusError{Method: method, URL: url, StatusCode: resp.status}
+	}
+	if out == nil {
+		return nil
+	}
+	if resp.tooLarge {
+		return fmt.Errorf("decode response from %s: %w", url, ErrResponseTooLarge)
+	}
+	if err := json.NewDecoder(bytes.NewReader(resp.data)).Decode(out); err != nil {
+		return fmt.Errorf("decode response from %s: %w", url, err)
+	}
+	return nil
+}
+
+// response is a response from Walhall which has been read completely.
+type response struct {
+	status int
+	data   []byte
+	// tooLarge is set if the body was longer than MaxResponseSize, in which case data is incomplete.
+	tooLarge bool
+}
+
+// fetch makes a request and reads up to MaxResponseSize of the response. The body is always drained and closed.
+func (a *APIState) fetch(method, url string, body io.Reader) (response, error) {
+	resp, err := a.makeRequest(method, url, body)
+	if err != nil {
+		return response{}, fmt.Errorf("%s %s: %w", method, url, err)
+	}
+	defer drainAndClose(resp.Body)
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxResponseSize+1))
+	if err != nil {
+		return response{}, fmt.Errorf("read response from %s: %w", url, err)
+	}
+	return response{status: resp.StatusCode, data: data, tooLarge: len(data) > MaxResponseSize}, nil
+}
+
+// fetchShared is fetch for GET requests, sharing the upstream call with identical concurrent requests of users with
+// the same access.
+func (a *APIState) fetchShared(url string) (response, error) {
+	key := a.apiPrefix + url + "\xff" + a.access
+	resp, err, shared := a.coalescer.do(a.ctx, key, func() (response, error) {
+		return a.fetch(http.MethodGet, url, nil)
+	})
+	if !shared {
+		return resp, err
+	}
+	if err != nil && a.ctx.Err() == nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+		// The request we waited for was abandoned by its caller, but we still want the response
+		return a.fetch(http.MethodGet, url, nil)
+	}
+	upstreamCoalesced.Inc(endpointTemplate(url))
+	logging.FromContext(a.ctx).Debug("[walhallapi] shared upstream response", "component", "walhallapi",
+		"method", http.MethodGet, "url", a.apiPrefix+url)
+	return resp, err
+}
+
+// drainAndClose reads the rest of a (short) body so that the connection can be reused and closes it.
+func drainAndClose(body io.ReadCloser) {
+	io.Copy(ioutil.Discard, io.LimitReader(body, drainLimit))
+	body.Close()
 }
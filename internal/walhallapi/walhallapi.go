@@ -3,6 +3,7 @@ package walhallapi
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -15,50 +16,43 @@ func (a *APIState) GetCurrentUser() string {
 // ListOrgs returns a map from org name to UUIDs for the user's orgs - excluding the self org.
 func (a *APIState) ListOrgs() (map[string]string, error) {
 	cacheKey := "ListOrgs()"
-	cachedResult, ok := a.cache[cacheKey]
-	if ok {
+	if cachedResult, ok := a.cached("ListOrgs", cacheKey); ok {
 		result := cachedResult.(map[string]string)
 		return result, nil
 	}
 	url := "/api/walhalluser/" + a.claims.UserUUID
-	resp, err := a.makeRequest(http.MethodGet, url, nil)
-	defer resp.Body.Close()
-	if err != nil {
-		return nil, fmt.Errorf("list orgs: %v", err)
-	}
-	if resp.StatusCode != http.StatusOK {
-		return nil, NewHTTPError(resp.StatusCode, a.claims.Username)
-	}
-	decoder := json.NewDecoder(resp.Body)
 	var userDetail struct {
 		Orgs []struct {
 			UUID string `json:"organization_uuid"`
 			Name string `json:"name"`
 		} `json:"organizations"`
 	}
-	err = decoder.Decode(&userDetail)
+	err := a.doJSON(http.MethodGet, url, nil, &userDetail)
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return nil, NewHTTPError(statusErr.StatusCode, a.claims.Username)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("Response from %s: %v ", url, err)
+		return nil, fmt.Errorf("list orgs: %w", err)
 	}
 
 	orgs := make(map[string]string)
 	for _, org := range userDetail.Orgs {
 		orgs[org.Name] = org.UUID
 	}
-	a.cache[cacheKey] = orgs
+	a.store(cacheKey, orgs)
 	return orgs, nil
 }
 
 func (a *APIState) ListApps(orgName string) (map[string]string, error) {
 	cacheKey := fmt.Sprintf(`ListApps("%s")`, orgName)
-	cachedResult, ok := a.cache[cacheKey]
-	if ok {
+	if cachedResult, ok := a.cached("ListApps", cacheKey); ok {
 		result := cachedResult.(map[string]string)
 		return result, nil
 	}
 	orgs, err := a.ListOrgs()
 	if err != nil {
-		return nil, fmt.Errorf("list apps: %v", err)
+		return nil, fmt.Errorf("list apps: %w", err)
 	}
 	orgUUID, ok := orgs[orgName]
 	if !ok {
@@ -66,25 +60,14 @@ func (a *APIState) ListApps(orgName string) (map[string]string, error) {
 	}
 
 	url := "/api/application?limit=100&organization_uuid=" + orgUUID
-	resp, err := a.makeRequest(http.MethodGet, url, nil)
-	defer resp.Body.Close()
-	if err != nil {
-		return nil, fmt.Errorf("list apps: %v", err)
-	}
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Response from %s: Status %d ", url, resp.StatusCode)
-	}
-
-	decoder := json.NewDecoder(resp.Body)
 	var appDetail struct {
 		Results []struct {
 			UUID string `json:"app_uuid"`
 			Name string `json:"name"`
 		} `json:"results"`
 	}
-	err = decoder.Decode(&appDetail)
-	if err != nil {
-		return nil, fmt.Errorf("Response from %s: %v ", url, err)
+	if err := a.doJSON(http.MethodGet, url, nil, &appDetail); err != nil {
+		return nil, fmt.Errorf("list apps: %w", err)
 	}
 
 	apps := make(map[string]string)
@@ -92,14 +75,14 @@ func (a *APIState) ListApps(orgName string) (map[string]string, error) {
 
 		apps[app.Name] = app.UUID
 	}
-	a.cache[cacheKey] = apps
+	a.store(cacheKey, apps)
 	return apps, nil
 }
 
 func (a *APIState) ListModules(orgName string) ([]Module, error) {
 	orgs, err := a.ListOrgs()
 	if err != nil {
-		return nil, fmt.Errorf("list modules: %v", err)
+		return nil, fmt.Errorf("list modules: %w", err)
 	}
 	orgUUID, ok := orgs[orgName]
 	if !ok {
@@ -107,22 +90,11 @@ func (a *APIState) ListModules(orgName string) ([]Module, error) {
 	}
 
 	url := fmt.Sprintf("/api/logicmodule?organization=%s&limit=50&status=internal", orgUUID)
-	resp, err := a.makeRequest(http.MethodGet, url, nil)
-	defer resp.Body.Close()
-	if err != nil {
-		return nil, fmt.Errorf("list modules: %v", err)
-	}
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Response from %s: Status %d ", url, resp.StatusCode)
-	}
-
-	decoder := json.NewDecoder(resp.Body)
 	var moduleResponse struct {
 		Results []Module `json:"results"`
 	}
-	err = decoder.Decode(&moduleResponse)
-	if err != nil {
-		return nil, fmt.Errorf("Response from %s: %v ", url, err)
+	if err := a.doJSON(http.MethodGet, url, nil, &moduleResponse); err != nil {
+		return nil, fmt.Errorf("list modules: %w", err)
 	}
 	return moduleResponse.Results, nil
 }
@@ -130,7 +102,7 @@ func (a *APIState) ListModules(orgName string) ([]Module, error) {
 func (a *APIState) RefreshModules(orgName string) (string, error) {
 	orgs, err := a.ListOrgs()
 	if err != nil {
-		return "", fmt.Errorf("list modules: %v", err)
+		return "", fmt.Errorf("list modules: %w", err)
 	}
 	orgUUID, ok := orgs[orgName]
 	if !ok {
@@ -138,29 +110,18 @@ func (a *APIState) RefreshModules(orgName string) (string, error) {
 	}
 
 	url := fmt.Sprintf("/api/repositories/github/sync?organization_uuid=%s", orgUUID)
-	resp, err := a.makeRequest(http.MethodPost, url, nil)
-	defer resp.Body.Close()
-	if err != nil {
-		return "", fmt.Errorf("list modules: %v", err)
-	}
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("Response from %s: Status %d ", url, resp.StatusCode)
-	}
-
-	decoder := json.NewDecoder(resp.Body)
 	var syncStatus struct {
 		Status string `json:"status"`
 	}
-	err = decoder.Decode(&syncStatus)
-	if err != nil {
-		return "", fmt.Errorf("Response from %s: %v ", url, err)
+	if err := a.doJSON(http.MethodPost, url, nil, &syncStatus); err != nil {
+		return "", fmt.Errorf("refresh modules: %w", err)
 	}
 	return syncStatus.Status, nil
 }
 func (a *APIState) GetRefreshModulesStatus(orgName string) (string, error) {
 	orgs, err := a.ListOrgs()
 	if err != nil {
-		return "", fmt.Errorf("refresh modules: %v", err)
+		return "", fmt.Errorf("refresh modules: %w", err)
 	}
 	orgUUID, ok := orgs[orgName]
 	if !ok {
@@ -168,36 +129,24 @@ func (a *APIState) GetRefreshModulesStatus(orgName string) (string, error) {
 	}
 
 	url := fmt.Sprintf("/api/repositories/github/status?organization_uuid=%s", orgUUID)
-	resp, err := a.makeRequest(http.MethodGet, url, nil)
-	defer resp.Body.Close()
-	if err != nil {
-		return "", fmt.Errorf("get refresh modules status: %v", err)
-	}
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("Response from %s: Status %d ", url, resp.StatusCode)
-	}
-
-	decoder := json.NewDecoder(resp.Body)
 	var syncStatus struct {
 		Status string `json:"status"`
 	}
-	err = decoder.Decode(&syncStatus)
-	if err != nil {
-		return "", fmt.Errorf("Response from %s: %v ", url, err)
+	if err := a.doJSON(http.MethodGet, url, nil, &syncStatus); err != nil {
+		return "", fmt.Errorf("get refresh modules status: %w", err)
 	}
 	return syncStatus.Status, nil
 }
 
 func (a *APIState) ListEnvs(orgName, appName string) ([]Environment, error) {
 	cacheKey := fmt.Sprintf(`ListEnvs("%s","%s")`, orgName, appName)
-	cachedResult, ok := a.cache[cacheKey]
-	if ok {
+	if cachedResult, ok := a.cached("ListEnvs", cacheKey); ok {
 		result := cachedResult.([]Environment)
 		return result, nil
 	}
 	apps, err := a.ListApps(orgName)
 	if err != nil {
-		return nil, fmt.Errorf("get environment as deployment set: %v", err)
+		return nil, fmt.Errorf("get environment as deployment set: %w", err)
 	}
 	appUUID, ok := apps[appName]
 	if !ok {
@@ -205,24 +154,13 @@ func (a *APIState) ListEnvs(orgName, appName string) ([]Environment, error) {
 	}
 
 	url := "/api/environments?application=" + appUUID
-	resp, err := a.makeRequest(http.MethodGet, url, nil)
-	defer resp.Body.Close()
-	if err != nil {
-		return nil, fmt.Errorf("list apps: %v", err)
-	}
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Response from %s: Status %d ", url, resp.StatusCode)
-	}
-
-	decoder := json.NewDecoder(resp.Body)
 	var envDetails struct {
 		Results []Environment `json:"results"`
 	}
-	err = decoder.Decode(&envDetails)
-	if err != nil {
-		return nil, fmt.Errorf("Response from %s: %v ", url, err)
+	if err := a.doJSON(http.MethodGet, url, nil, &envDetails); err != nil {
+		return nil, fmt.Errorf("list envs: %w", err)
 	}
-	a.cache[cacheKey] = envDetails.Results
+	a.store(cacheKey, envDetails.Results)
 	return envDetails.Results, nil
 }
 
@@ -248,56 +186,29 @@ func (a *APIState) PatchEnv(env Environment, moduleVersions []int) (Environment,
 	moduleVersionsWrapper.LogicModuleVersionIds = moduleVersions
 	encoder.Encode(moduleVersionsWrapper)
 
-	resp, err := a.makeRequest(http.MethodPatch, "/api/environments/"+env.UUID, &buffer)
-	defer resp.Body.Close()
-	if err != nil {
-		return Environment{}, fmt.Errorf("patch environment: %v", err)
-	}
-	if resp.StatusCode != http.StatusOK {
-		return Environment{}, fmt.Errorf("patch environment: expected 200, got %d", resp.StatusCode)
-	}
-
-	decoder := json.NewDecoder(resp.Body)
 	var envDetail Environment
-	decoder.Decode(&envDetail)
+	if err := a.doJSON(http.MethodPatch, "/api/environments/"+env.UUID, &buffer, &envDetail); err != nil {
+		return Environment{}, fmt.Errorf("patch environment: %w", err)
+	}
 	return envDetail, nil
 }
 
 func (a *APIState) DeleteModuleVersionFromEnv(env Environment, mv ModuleVersion) (Environment, error) {
 	deleteMVURL := fmt.Sprintf("/api/environments/%s/remove/%s", env.UUID, mv.UUID)
-	resp, err := a.makeRequest(http.MethodDelete, deleteMVURL, nil)
-	defer resp.Body.Close()
-	if err != nil {
-		return Environment{}, fmt.Errorf("delete module version from env: %v", err)
-	}
-	if resp.StatusCode != http.StatusOK {
-		return Environment{}, fmt.Errorf("delete module version from env: expected 200, got %d", resp.StatusCode)
-	}
-
-	decoder := json.NewDecoder(resp.Body)
 	var envDetail Environment
-	decoder.Decode(&envDetail)
+	if err := a.doJSON(http.MethodDelete, deleteMVURL, nil, &envDetail); err != nil {
+		return Environment{}, fmt.Errorf("delete module version from env: %w", err)
+	}
 	return envDetail, nil
 }
 
 func (a *APIState) GetConfigsForModuleVersionInEnv(env Environment, mv ModuleVersion) ([]Config, error) {
 	getConfigsURL := fmt.Sprintf("/api/configuration?logic_module_version=%d&environment=%s", mv.ID, env.UUID)
-	resp, err := a.makeRequest(http.MethodGet, getConfigsURL, nil)
-	defer resp.Body.Close()
-	if err != nil {
-		return []Config{}, fmt.Errorf("get config for module in env: %v", err)
-	}
-	if resp.StatusCode != http.StatusOK {
-		return []Config{}, fmt.Errorf("get config for module in env: expected 200, got %d", resp.StatusCode)
-	}
-
-	decoder := json.NewDecoder(resp.Body)
 	var configResults struct {
 		Results []Config `json:"results"`
 	}
-	err = decoder.Decode(&configResults)
-	if err != nil {
-		return []Config{}, fmt.Errorf("get config for module in env: %v", err)
+	if err := a.doJSON(http.MethodGet, getConfigsURL, nil, &configResults); err != nil {
+		return []Config{}, fmt.Errorf("get config for module in env: %w", err)
 	}
 	return configResults.Results, nil
 }
@@ -309,20 +220,9 @@ func (a *APIState) UpdateConfiguration(config Config) (Config, error) {
 	var buffer bytes.Buffer
 	encoder := json.NewEncoder(&buffer)
 	encoder.Encode(config)
-	resp, err := a.makeRequest(http.MethodPut, "/api/configuration/"+strconv.Itoa(config.ID), &buffer)
-	defer resp.Body.Close()
-	if err != nil {
-		return Config{}, fmt.Errorf("put config: %v", err)
-	}
-	if resp.StatusCode != http.StatusOK {
-		return Config{}, fmt.Errorf("put config: expected 200, got %d", resp.StatusCode)
-	}
-
-	decoder := json.NewDecoder(resp.Body)
 	var configResult Config
-	err = decoder.Decode(&configResult)
-	if err != nil {
-		return Config{}, fmt.Errorf("put config: %v", err)
+	if err := a.doJSON(http.MethodPut, "/api/configuration/"+strconv.Itoa(config.ID), &buffer, &configResult); err != nil {
+		return Config{}, fmt.Errorf("put config: %w", err)
 	}
 	return configResult, nil
 }
@@ -345,20 +245,10 @@ func (a *APIState) CreateConfiguration(env Environment, mv ModuleVersion, config
 		EnvUUID         string `json:"environment"`
 	}{Type: configType, ModuleVersionID: mv.ID, EnvUUID: env.UUID}
 	encoder.Encode(configDef)
-	resp, err := a.makeRequest(http.MethodPost, "/api/configuration", &buffer)
-	defer resp.Body.Close()
-	if err != nil {
-		return Config{}, fmt.Errorf("post config for module in env: %v", err)
-	}
-	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
-		return Config{}, fmt.Errorf("post config for module in env: expected 201, got %d", resp.StatusCode)
-	}
-
-	decoder := json.NewDecoder(resp.Body)
 	var configResult Config
-	err = decoder.Decode(&configResult)
+	err := a.doJSON(http.MethodPost, "/api/configuration", &buffer, &configResult, http.StatusCreated, http.StatusOK)
 	if err != nil {
-		return Config{}, fmt.Errorf("put config for module in env: %v", err)
+		return Config{}, fmt.Errorf("post config for module in env: %w", err)
 	}
 	return configResult, nil
 }
@@ -368,13 +258,8 @@ func (a *APIState) CreateConfiguration(env Environment, mv ModuleVersion, config
 // Nil error indicates success
 func (a *APIState) DeleteConfiguration(configID int) error {
 	deleteConfigURL := fmt.Sprintf("/api/configuration/%d", configID)
-	resp, err := a.makeRequest(http.MethodDelete, deleteConfigURL, nil)
-	defer resp.Body.Close()
-	if err != nil {
-		return fmt.Errorf("post config for module in env: %v", err)
-	}
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("post config for module in env: expected 200, got %d", resp.StatusCode)
+	if err := a.doJSON(http.MethodDelete, deleteConfigURL, nil, nil); err != nil {
+		return fmt.Errorf("delete config: %w", err)
 	}
 	return nil
 }
@@ -383,13 +268,8 @@ func (a *APIState) DeleteConfiguration(configID int) error {
 // cluster defined in the environment
 func (a *APIState) DeployToEnvironment(env Environment) error {
 	putDeployURL := fmt.Sprintf("/api/environments/%s/deploy", env.UUID)
-	resp, err := a.makeRequest(http.MethodPut, putDeployURL, bytes.NewBuffer([]byte("{}")))
-	defer resp.Body.Close()
-	if err != nil {
+	if err := a.doJSON(http.MethodPut, putDeployURL, bytes.NewBuffer([]byte("{}")), nil); err != nil {
 		return fmt.Errorf("deploy to env %s: %w", env.UUID, err)
 	}
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("post config for module in env: expected 200, got %d", resp.StatusCode)
-	}
 	return nil
 }
@@ -0,0 +1,4 @@
+// Package walhallpb holds the messages and the gRPC client and server of the Walhall service defined in walhall.proto.
+package walhallpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative walhall.proto
@@ -0,0 +1,611 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: walhall.proto
+
+package walhallpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Walhall_ListOrgs_FullMethodName                = "/walhall.v1.Walhall/ListOrgs"
+	Walhall_ListModules_FullMethodName             = "/walhall.v1.Walhall/ListModules"
+	Walhall_RefreshModules_FullMethodName          = "/walhall.v1.Walhall/RefreshModules"
+	Walhall_GetRefreshModulesStatus_FullMethodName = "/walhall.v1.Walhall/GetRefreshModulesStatus"
+	Walhall_ListApps_FullMethodName                = "/walhall.v1.Walhall/ListApps"
+	Walhall_ListEnvs_FullMethodName                = "/walhall.v1.Walhall/ListEnvs"
+	Walhall_GetEnv_FullMethodName                  = "/walhall.v1.Walhall/GetEnv"
+	Walhall_ListConfigs_FullMethodName             = "/walhall.v1.Walhall/ListConfigs"
+	Walhall_CreateConfig_FullMethodName            = "/walhall.v1.Walhall/CreateConfig"
+	Walhall_UpdateConfig_FullMethodName            = "/walhall.v1.Walhall/UpdateConfig"
+	Walhall_DeleteConfig_FullMethodName            = "/walhall.v1.Walhall/DeleteConfig"
+	Walhall_Deploy_FullMethodName                  = "/walhall.v1.Walhall/Deploy"
+	Walhall_SetModuleVersions_FullMethodName       = "/walhall.v1.Walhall/SetModuleVersions"
+)
+
+// WalhallClient is the client API for Walhall service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Walhall serves the adaptor's API over gRPC. Calls carry credentials in the "authorization" or "x-api-key" metadata,
+// exactly like the headers of REST requests, and are authorized with the same policy: each method is named like its
+// REST route, with a lower case first letter, e.g. "listModules".
+type WalhallClient interface {
+	// ListOrgs lists the names of the orgs the user is a member of.
+	ListOrgs(ctx context.Context, in *ListOrgsRequest, opts ...grpc.CallOption) (*ListOrgsResponse, error)
+	// ListModules lists the modules available to the user in an org.
+	ListModules(ctx context.Context, in *ListModulesRequest, opts ...grpc.CallOption) (*ListModulesResponse, error)
+	// RefreshModules starts a sync of the org's modules from GitHub.
+	RefreshModules(ctx context.Context, in *RefreshModulesRequest, opts ...grpc.CallOption) (*RefreshModulesResponse, error)
+	// GetRefreshModulesStatus returns the status of the last sync of the org's modules.
+	GetRefreshModulesStatus(ctx context.Context, in *GetRefreshModulesStatusRequest, opts ...grpc.CallOption) (*RefreshModulesResponse, error)
+	// ListApps lists the apps in an org.
+	ListApps(ctx context.Context, in *ListAppsRequest, opts ...grpc.CallOption) (*ListAppsResponse, error)
+	// ListEnvs lists the environments of an app with the module versions deployed in them.
+	ListEnvs(ctx context.Context, in *ListEnvsRequest, opts ...grpc.CallOption) (*ListEnvsResponse, error)
+	// GetEnv returns an environment with the configurations of each of its module versions.
+	GetEnv(ctx context.Context, in *GetEnvRequest, opts ...grpc.CallOption) (*Env, error)
+	// ListConfigs lists the configurations of a module version in an environment.
+	ListConfigs(ctx context.Context, in *ListConfigsRequest, opts ...grpc.CallOption) (*ListConfigsResponse, error)
+	// CreateConfig creates an empty configuration of a module version in an environment.
+	CreateConfig(ctx context.Context, in *CreateConfigRequest, opts ...grpc.CallOption) (*Config, error)
+	// UpdateConfig replaces a configuration.
+	UpdateConfig(ctx context.Context, in *UpdateConfigRequest, opts ...grpc.CallOption) (*Config, error)
+	// DeleteConfig deletes a configuration.
+	DeleteConfig(ctx context.Context, in *DeleteConfigRequest, opts ...grpc.CallOption) (*DeleteConfigResponse, error)
+	// Deploy deploys the current configurations of an environment to its cluster.
+	Deploy(ctx context.Context, in *DeployRequest, opts ...grpc.CallOption) (*DeployResponse, error)
+	// SetModuleVersions sets the versions of modules in an environment.
+	SetModuleVersions(ctx context.Context, in *SetModuleVersionsRequest, opts ...grpc.CallOption) (*Env, error)
+}
+
+type walhallClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewWalhallClient(cc grpc.ClientConnInterface) WalhallClient {
+	return &walhallClient{cc}
+}
+
+func (c *walhallClient) ListOrgs(ctx context.Context, in *ListOrgsRequest, opts ...grpc.CallOption) (*ListOrgsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListOrgsResponse)
+	err := c.cc.Invoke(ctx, Walhall_ListOrgs_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *walhallClient) ListModules(ctx context.Context, in *ListModulesRequest, opts ...grpc.CallOption) (*ListModulesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListModulesResponse)
+	err := c.cc.Invoke(ctx, Walhall_ListModules_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *walhallClient) RefreshModules(ctx context.Context, in *RefreshModulesRequest, opts ...grpc.CallOption) (*RefreshModulesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RefreshModulesResponse)
+	err := c.cc.Invoke(ctx, Walhall_RefreshModules_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *walhallClient) GetRefreshModulesStatus(ctx context.Context, in *GetRefreshModulesStatusRequest, opts ...grpc.CallOption) (*RefreshModulesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RefreshModulesResponse)
+	err := c.cc.Invoke(ctx, Walhall_GetRefreshModulesStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *walhallClient) ListApps(ctx context.Context, in *ListAppsRequest, opts ...grpc.CallOption) (*ListAppsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListAppsResponse)
+	err := c.cc.Invoke(ctx, Walhall_ListApps_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *walhallClient) ListEnvs(ctx context.Context, in *ListEnvsRequest, opts ...grpc.CallOption) (*ListEnvsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListEnvsResponse)
+	err := c.cc.Invoke(ctx, Walhall_ListEnvs_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *walhallClient) GetEnv(ctx context.Context, in *GetEnvRequest, opts ...grpc.CallOption) (*Env, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Env)
+	err := c.cc.Invoke(ctx, Walhall_GetEnv_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *walhallClient) ListConfigs(ctx context.Context, in *ListConfigsRequest, opts ...grpc.CallOption) (*ListConfigsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListConfigsResponse)
+	err := c.cc.Invoke(ctx, Walhall_ListConfigs_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *walhallClient) CreateConfig(ctx context.Context, in *CreateConfigRequest, opts ...grpc.CallOption) (*Config, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Config)
+	err := c.cc.Invoke(ctx, Walhall_CreateConfig_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *walhallClient) UpdateConfig(ctx context.Context, in *UpdateConfigRequest, opts ...grpc.CallOption) (*Config, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Config)
+	err := c.cc.Invoke(ctx, Walhall_UpdateConfig_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *walhallClient) DeleteConfig(ctx context.Context, in *DeleteConfigRequest, opts ...grpc.CallOption) (*DeleteConfigResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteConfigResponse)
+	err := c.cc.Invoke(ctx, Walhall_DeleteConfig_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *walhallClient) Deploy(ctx context.Context, in *DeployRequest, opts ...grpc.CallOption) (*DeployResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeployResponse)
+	err := c.cc.Invoke(ctx, Walhall_Deploy_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *walhallClient) SetModuleVersions(ctx context.Context, in *SetModuleVersionsRequest, opts ...grpc.CallOption) (*Env, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Env)
+	err := c.cc.Invoke(ctx, Walhall_SetModuleVersions_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// WalhallServer is the server API for Walhall service.
+// All implementations must embed UnimplementedWalhallServer
+// for forward compatibility.
+//
+// Walhall serves the adaptor's API over gRPC. Calls carry credentials in the "authorization" or "x-api-key" metadata,
+// exactly like the headers of REST requests, and are authorized with the same policy: each method is named like its
+// REST route, with a lower case first letter, e.g. "listModules".
+type WalhallServer interface {
+	// ListOrgs lists the names of the orgs the user is a member of.
+	ListOrgs(context.Context, *ListOrgsRequest) (*ListOrgsResponse, error)
+	// ListModules lists the modules available to the user in an org.
+	ListModules(context.Context, *ListModulesRequest) (*ListModulesResponse, error)
+	// RefreshModules starts a sync of the org's modules from GitHub.
+	RefreshModules(context.Context, *RefreshModulesRequest) (*RefreshModulesResponse, error)
+	// GetRefreshModulesStatus returns the status of the last sync of the org's modules.
+	GetRefreshModulesStatus(context.Context, *GetRefreshModulesStatusRequest) (*RefreshModulesResponse, error)
+	// ListApps lists the apps in an org.
+	ListApps(context.Context, *ListAppsRequest) (*ListAppsResponse, error)
+	// ListEnvs lists the environments of an app with the module versions deployed in them.
+	ListEnvs(context.Context, *ListEnvsRequest) (*ListEnvsResponse, error)
+	// GetEnv returns an environment with the configurations of each of its module versions.
+	GetEnv(context.Context, *GetEnvRequest) (*Env, error)
+	// ListConfigs lists the configurations of a module version in an environment.
+	ListConfigs(context.Context, *ListConfigsRequest) (*ListConfigsResponse, error)
+	// CreateConfig creates an empty configuration of a module version in an environment.
+	CreateConfig(context.Context, *CreateConfigRequest) (*Config, error)
+	// UpdateConfig replaces a configuration.
+	UpdateConfig(context.Context, *UpdateConfigRequest) (*Config, error)
+	// DeleteConfig deletes a configuration.
+	DeleteConfig(context.Context, *DeleteConfigRequest) (*DeleteConfigResponse, error)
+	// Deploy deploys the current configurations of an environment to its cluster.
+	Deploy(context.Context, *DeployRequest) (*DeployResponse, error)
+	// SetModuleVersions sets the versions of modules in an environment.
+	SetModuleVersions(context.Context, *SetModuleVersionsRequest) (*Env, error)
+	mustEmbedUnimplementedWalhallServer()
+}
+
+// UnimplementedWalhallServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedWalhallServer struct{}
+
+func (UnimplementedWalhallServer) ListOrgs(context.Context, *ListOrgsRequest) (*ListOrgsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListOrgs not implemented")
+}
+func (UnimplementedWalhallServer) ListModules(context.Context, *ListModulesRequest) (*ListModulesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListModules not implemented")
+}
+func (UnimplementedWalhallServer) RefreshModules(context.Context, *RefreshModulesRequest) (*RefreshModulesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RefreshModules not implemented")
+}
+func (UnimplementedWalhallServer) GetRefreshModulesStatus(context.Context, *GetRefreshModulesStatusRequest) (*RefreshModulesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRefreshModulesStatus not implemented")
+}
+func (UnimplementedWalhallServer) ListApps(context.Context, *ListAppsRequest) (*ListAppsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListApps not implemented")
+}
+func (UnimplementedWalhallServer) ListEnvs(context.Context, *ListEnvsRequest) (*ListEnvsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListEnvs not implemented")
+}
+func (UnimplementedWalhallServer) GetEnv(context.Context, *GetEnvRequest) (*Env, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetEnv not implemented")
+}
+func (UnimplementedWalhallServer) ListConfigs(context.Context, *ListConfigsRequest) (*ListConfigsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListConfigs not implemented")
+}
+func (UnimplementedWalhallServer) CreateConfig(context.Context, *CreateConfigRequest) (*Config, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateConfig not implemented")
+}
+func (UnimplementedWalhallServer) UpdateConfig(context.Context, *UpdateConfigRequest) (*Config, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateConfig not implemented")
+}
+func (UnimplementedWalhallServer) DeleteConfig(context.Context, *DeleteConfigRequest) (*DeleteConfigResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteConfig not implemented")
+}
+func (UnimplementedWalhallServer) Deploy(context.Context, *DeployRequest) (*DeployResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Deploy not implemented")
+}
+func (UnimplementedWalhallServer) SetModuleVersions(context.Context, *SetModuleVersionsRequest) (*Env, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetModuleVersions not implemented")
+}
+func (UnimplementedWalhallServer) mustEmbedUnimplementedWalhallServer() {}
+func (UnimplementedWalhallServer) testEmbeddedByValue()                 {}
+
+// UnsafeWalhallServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to WalhallServer will
+// result in compilation errors.
+type UnsafeWalhallServer interface {
+	mustEmbedUnimplementedWalhallServer()
+}
+
+func RegisterWalhallServer(s grpc.ServiceRegistrar, srv WalhallServer) {
+	// If the following call pancis, it indicates UnimplementedWalhallServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Walhall_ServiceDesc, srv)
+}
+
+func _Walhall_ListOrgs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListOrgsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalhallServer).ListOrgs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Walhall_ListOrgs_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalhallServer).ListOrgs(ctx, req.(*ListOrgsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Walhall_ListModules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListModulesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalhallServer).ListModules(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Walhall_ListModules_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalhallServer).ListModules(ctx, req.(*ListModulesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Walhall_RefreshModules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RefreshModulesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalhallServer).RefreshModules(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Walhall_RefreshModules_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalhallServer).RefreshModules(ctx, req.(*RefreshModulesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Walhall_GetRefreshModulesStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRefreshModulesStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalhallServer).GetRefreshModulesStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Walhall_GetRefreshModulesStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalhallServer).GetRefreshModulesStatus(ctx, req.(*GetRefreshModulesStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Walhall_ListApps_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListAppsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalhallServer).ListApps(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Walhall_ListApps_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalhallServer).ListApps(ctx, req.(*ListAppsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Walhall_ListEnvs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListEnvsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalhallServer).ListEnvs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Walhall_ListEnvs_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalhallServer).ListEnvs(ctx, req.(*ListEnvsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Walhall_GetEnv_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetEnvRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalhallServer).GetEnv(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Walhall_GetEnv_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalhallServer).GetEnv(ctx, req.(*GetEnvRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Walhall_ListConfigs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListConfigsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalhallServer).ListConfigs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Walhall_ListConfigs_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalhallServer).ListConfigs(ctx, req.(*ListConfigsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Walhall_CreateConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateConfigRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalhallServer).CreateConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Walhall_CreateConfig_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalhallServer).CreateConfig(ctx, req.(*CreateConfigRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Walhall_UpdateConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateConfigRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalhallServer).UpdateConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Walhall_UpdateConfig_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalhallServer).UpdateConfig(ctx, req.(*UpdateConfigRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Walhall_DeleteConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteConfigRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalhallServer).DeleteConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Walhall_DeleteConfig_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalhallServer).DeleteConfig(ctx, req.(*DeleteConfigRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Walhall_Deploy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeployRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalhallServer).Deploy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Walhall_Deploy_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalhallServer).Deploy(ctx, req.(*DeployRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Walhall_SetModuleVersions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetModuleVersionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WalhallServer).SetModuleVersions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Walhall_SetModuleVersions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WalhallServer).SetModuleVersions(ctx, req.(*SetModuleVersionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Walhall_ServiceDesc is the grpc.ServiceDesc for Walhall service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Walhall_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "walhall.v1.Walhall",
+	HandlerType: (*WalhallServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListOrgs",
+			Handler:    _Walhall_ListOrgs_Handler,
+		},
+		{
+			MethodName: "ListModules",
+			Handler:    _Walhall_ListModules_Handler,
+		},
+		{
+			MethodName: "RefreshModules",
+			Handler:    _Walhall_RefreshModules_Handler,
+		},
+		{
+			MethodName: "GetRefreshModulesStatus",
+			Handler:    _Walhall_GetRefreshModulesStatus_Handler,
+		},
+		{
+			MethodName: "ListApps",
+			Handler:    _Walhall_ListApps_Handler,
+		},
+		{
+			MethodName: "ListEnvs",
+			Handler:    _Walhall_ListEnvs_Handler,
+		},
+		{
+			MethodName: "GetEnv",
+			Handler:    _Walhall_GetEnv_Handler,
+		},
+		{
+			MethodName: "ListConfigs",
+			Handler:    _Walhall_ListConfigs_Handler,
+		},
+		{
+			MethodName: "CreateConfig",
+			Handler:    _Walhall_CreateConfig_Handler,
+		},
+		{
+			MethodName: "UpdateConfig",
+			Handler:    _Walhall_UpdateConfig_Handler,
+		},
+		{
+			MethodName: "DeleteConfig",
+			Handler:    _Walhall_DeleteConfig_Handler,
+		},
+		{
+			MethodName: "Deploy",
+			Handler:    _Walhall_Deploy_Handler,
+		},
+		{
+			MethodName: "SetModuleVersions",
+			Handler:    _Walhall_SetModuleVersions_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "walhall.proto",
+}